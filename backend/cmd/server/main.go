@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -131,10 +133,60 @@ func main() {
 	// TODO: Load these from settings table
 	checkinTime := "15:00"
 	checkoutTime := "11:00"
-	minPIN := 4
-	maxPIN := 6
+	if v, err := loadSetting(context.Background(), db, "checkin_time"); err == nil && v != "" {
+		checkinTime = v
+	}
+	if v, err := loadSetting(context.Background(), db, "checkout_time"); err == nil && v != "" {
+		checkoutTime = v
+	}
+	// Default property timezone; empty uses the server's local time
+	timezone, _ := loadSetting(context.Background(), db, "timezone")
+	minValue, _ := loadSetting(context.Background(), db, pin.SettingMinLength)
+	maxValue, _ := loadSetting(context.Background(), db, pin.SettingMaxLength)
+	minPIN, maxPIN, err := pin.ParseLengths(minValue, maxValue)
+	if err != nil {
+		log.Printf("Invalid PIN length setting, using %d to %d digits: %v", minPIN, maxPIN, err)
+	}
 	batchWindowSeconds := 30
 	defaultSyncIntervalMin := 15
+	recurrenceHorizonDays := calendar.DefaultRecurrenceHorizonDays
+	if v, err := loadSetting(context.Background(), db, "recurrence_horizon_days"); err == nil && v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days > 0 {
+			recurrenceHorizonDays = days
+		}
+	}
+	// file:// calendar feeds are read from the calendars directory
+	calendar.SetLocalCalendarDir(filepath.Join(*dataDir, "calendars"))
+	if err := os.MkdirAll(calendar.GetLocalCalendarDir(), 0o755); err != nil {
+		log.Printf("Warning: Failed to create calendar directory: %v", err)
+	}
+	snapshotRetention := calendar.DefaultSnapshotRetention
+	if v, err := loadSetting(context.Background(), db, "sync_snapshot_retention"); err == nil && v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			snapshotRetention = n
+		}
+	}
+	conflictPolicy := pin.ConflictPolicyAutoResolve
+	if v, err := loadSetting(context.Background(), db, "pin_conflict_policy"); err == nil && pin.IsValidConflictPolicy(v) {
+		conflictPolicy = v
+	}
+	codeReuseDays := calendar.DefaultCodeReuseDays
+	if v, err := loadSetting(context.Background(), db, "pin_code_reuse_days"); err == nil && v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			codeReuseDays = n
+		}
+	}
+	policySettings := make(map[string]string, len(pin.PolicySettings))
+	for _, key := range pin.PolicySettings {
+		if v, err := loadSetting(context.Background(), db, key); err == nil {
+			policySettings[key] = v
+		}
+	}
+	pinPolicy, err := pin.ParsePolicy(policySettings)
+	if err != nil {
+		log.Printf("Invalid PIN policy setting, using the default policy: %v", err)
+		pinPolicy = pin.DefaultPolicy()
+	}
 
 	// Initialize sync service
 	syncService := calendar.NewSyncService(
@@ -143,7 +195,13 @@ func main() {
 		guestPINRepo,
 		lockRepo,
 		checkinTime, checkoutTime,
+		timezone,
 		minPIN, maxPIN,
+		recurrenceHorizonDays,
+		snapshotRetention,
+		conflictPolicy,
+		codeReuseDays,
+		pinPolicy,
 	)
 
 	// Initialize lock manager
@@ -173,7 +231,7 @@ func main() {
 	}
 
 	// Initialize HTTP router with services
-	router := api.NewRouterWithServices(db, hub, *staticDir, syncService, calendarScheduler)
+	router := api.NewRouterWithServices(db, hub, *staticDir, syncService, calendarScheduler, lockManager)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -8,6 +8,4 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.24
 )
 
-require github.com/robfig/cron/v3 v3.0.1 // indirect
-
-
+require github.com/robfig/cron/v3 v3.0.1
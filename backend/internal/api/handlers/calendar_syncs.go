@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/guest-lock-manager/backend/internal/api/middleware"
+	"github.com/guest-lock-manager/backend/internal/calendar"
+	"github.com/guest-lock-manager/backend/internal/storage"
+)
+
+// CalendarSyncRunResponse represents a recorded sync run in API responses.
+type CalendarSyncRunResponse struct {
+	ID             string  `json:"id"`
+	CalendarID     string  `json:"calendar_id"`
+	StartedAt      string  `json:"started_at"`
+	FinishedAt     string  `json:"finished_at"`
+	DurationMS     int64   `json:"duration_ms"`
+	Status         string  `json:"status"`
+	HTTPStatus     *int    `json:"http_status,omitempty"`
+	EventsFound    int     `json:"events_found"`
+	EventsSkipped  int     `json:"events_skipped"`
+	PinsCreated    int     `json:"pins_created"`
+	PinsUpdated    int     `json:"pins_updated"`
+	PinsRemoved    int     `json:"pins_removed"`
+	PendingRemoval int     `json:"pending_removal"`
+	Cancellations  int     `json:"cancellations"`
+	Error          *string `json:"error,omitempty"`
+	HasSnapshot    bool    `json:"has_snapshot"`
+	SnapshotSize   *int    `json:"snapshot_size,omitempty"`
+}
+
+// ListCalendarSyncs returns a calendar's sync history, newest first.
+func ListCalendarSyncs(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		ctx := r.Context()
+
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 500 {
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Limit must be between 1 and 500")
+				return
+			}
+			limit = n
+		}
+
+		var exists bool
+		db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM calendar_subscriptions WHERE id = ?)", id).Scan(&exists)
+		if !exists {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
+			return
+		}
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT id, calendar_id, started_at, finished_at, duration_ms, status, http_status,
+			       events_found, events_skipped, pins_created, pins_updated, pins_removed, pending_removal,
+			       cancellations, error, snapshot_size
+			FROM calendar_sync_runs
+			WHERE calendar_id = ?
+			ORDER BY started_at DESC, id DESC
+			LIMIT ?
+		`, id, limit)
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to query sync history")
+			return
+		}
+		defer rows.Close()
+
+		var runs []CalendarSyncRunResponse
+		for rows.Next() {
+			var s CalendarSyncRunResponse
+			if err := rows.Scan(&s.ID, &s.CalendarID, &s.StartedAt, &s.FinishedAt, &s.DurationMS, &s.Status, &s.HTTPStatus,
+				&s.EventsFound, &s.EventsSkipped, &s.PinsCreated, &s.PinsUpdated, &s.PinsRemoved, &s.PendingRemoval,
+				&s.Cancellations, &s.Error, &s.SnapshotSize); err != nil {
+				middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to scan sync run")
+				return
+			}
+			s.HasSnapshot = s.SnapshotSize != nil
+			runs = append(runs, s)
+		}
+
+		if runs == nil {
+			runs = []CalendarSyncRunResponse{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runs)
+	}
+}
+
+// DiffCalendarSyncs compares the feed snapshots of two sync runs. The "from"
+// and "to" query parameters are run IDs; "to" defaults to the newest snapshot
+// and "from" to the one before it.
+func DiffCalendarSyncs(db *storage.DB, syncService *calendar.SyncService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		ctx := r.Context()
+
+		var exists bool
+		db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM calendar_subscriptions WHERE id = ?)", id).Scan(&exists)
+		if !exists {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
+			return
+		}
+
+		if syncService == nil {
+			middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Calendar sync is not available")
+			return
+		}
+
+		diff, err := syncService.DiffSyncRuns(ctx, id, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+		if errors.Is(err, calendar.ErrSnapshotNotFound) {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Sync snapshot not found; older snapshots are pruned")
+			return
+		}
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to diff sync snapshots")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
+	}
+}
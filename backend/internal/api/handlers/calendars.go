@@ -1,13 +1,21 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/guest-lock-manager/backend/internal/api/middleware"
 	"github.com/guest-lock-manager/backend/internal/calendar"
+	"github.com/guest-lock-manager/backend/internal/pin"
 	"github.com/guest-lock-manager/backend/internal/storage"
 	"github.com/guest-lock-manager/backend/internal/storage/models"
 	"github.com/guest-lock-manager/backend/internal/websocket"
@@ -16,21 +24,335 @@ import (
 // Calendar request/response types
 
 type CreateCalendarRequest struct {
-	Name            string `json:"name"`
-	URL             string `json:"url"`
-	SyncIntervalMin int    `json:"sync_interval_min"`
-	Enabled         bool   `json:"enabled"`
+	Name            string             `json:"name"`
+	URL             string             `json:"url"` // Not used by upload calendars
+	SourceType      string             `json:"source_type,omitempty"`
+	SyncIntervalMin int                `json:"sync_interval_min"`
+	Enabled         bool               `json:"enabled"`
+	Platform        string             `json:"platform"`
+	Timezone        *string            `json:"timezone,omitempty"`
+	CheckinTime     *string            `json:"checkin_time,omitempty"`
+	CheckoutTime    *string            `json:"checkout_time,omitempty"`
+	EventRules      *models.EventRules `json:"event_rules,omitempty"`
+	// Grace before expiring PINs of events missing from the feed; nil uses the defaults.
+	RemovalGraceSyncs *int `json:"removal_grace_syncs,omitempty"`
+	RemovalGraceMin   *int `json:"removal_grace_min,omitempty"`
+	// Percentage drop from the last good sync that holds removals; nil uses the default, 0 disables.
+	AnomalyThresholdPct *int `json:"anomaly_threshold_pct,omitempty"`
+	// Feed credentials are write-only: nil keeps the stored ones on update and
+	// {"type": "none"} removes them.
+	Auth           *models.FeedAuth  `json:"auth,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	MaxFeedBytes   int64             `json:"max_feed_bytes,omitempty"` // 0 uses the default limit
+	RedirectPolicy string            `json:"redirect_policy,omitempty"`
+	CABundle       *string           `json:"ca_bundle,omitempty"`
+	// Field mapping of a reservation_api calendar's JSON responses.
+	ReservationMapping *models.ReservationMapping `json:"reservation_mapping,omitempty"`
+	// Priority picks which calendar's reservation is programmed when calendars
+	// sharing a lock list the same stay; the highest wins.
+	Priority int `json:"priority"`
+	// PIN generation methods tried in order, and the code length; nil uses
+	// the default method order and the global PIN length settings.
+	PINMethods []string `json:"pin_methods,omitempty"`
+	PINLength  *int     `json:"pin_length,omitempty"`
+}
+
+// fetchOptions returns the feed fetch options of a validated request.
+func (req *CreateCalendarRequest) fetchOptions() models.FetchOptions {
+	return models.FetchOptions{
+		Auth:           req.Auth,
+		Headers:        req.Headers,
+		MaxBytes:       req.MaxFeedBytes,
+		RedirectPolicy: req.RedirectPolicy,
+		CABundle:       req.CABundle,
+	}
 }
 
 type CalendarResponse struct {
-	ID              string  `json:"id"`
-	Name            string  `json:"name"`
-	URL             string  `json:"url"`
-	SyncIntervalMin int     `json:"sync_interval_min"`
-	LastSyncAt      *string `json:"last_sync_at,omitempty"`
-	SyncStatus      string  `json:"sync_status"`
-	SyncError       *string `json:"sync_error,omitempty"`
-	Enabled         bool    `json:"enabled"`
+	ID              string             `json:"id"`
+	Name            string             `json:"name"`
+	URL             string             `json:"url"`
+	SourceType      string             `json:"source_type"`
+	UploadedAt      *string            `json:"uploaded_at,omitempty"` // Last ICS upload of an upload calendar
+	SyncIntervalMin int                `json:"sync_interval_min"`
+	LastSyncAt      *string            `json:"last_sync_at,omitempty"`
+	NextSyncAt      *string            `json:"next_sync_at,omitempty"` // Set by the scheduler; adapts to upcoming check-ins
+	SyncStatus      string             `json:"sync_status"`
+	SyncError       *string            `json:"sync_error,omitempty"`
+	Enabled         bool               `json:"enabled"`
+	Platform        string             `json:"platform"`
+	Timezone        *string            `json:"timezone,omitempty"`
+	CheckinTime     *string            `json:"checkin_time,omitempty"`
+	CheckoutTime    *string            `json:"checkout_time,omitempty"`
+	EventRules      *models.EventRules `json:"event_rules,omitempty"`
+	// Grace before expiring PINs of events missing from the feed.
+	RemovalGraceSyncs int `json:"removal_grace_syncs"`
+	RemovalGraceMin   int `json:"removal_grace_min"`
+	// Feed anomaly guard; AnomalyReason is set while PIN removals are held.
+	AnomalyThresholdPct int     `json:"anomaly_threshold_pct"`
+	AnomalyReason       *string `json:"anomaly_reason,omitempty"`
+	AnomalyDetectedAt   *string `json:"anomaly_detected_at,omitempty"`
+	// Feed fetch options; credentials are never returned, only their type.
+	AuthType       string            `json:"auth_type"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	MaxFeedBytes   int64             `json:"max_feed_bytes"`
+	RedirectPolicy string            `json:"redirect_policy"`
+	CABundle       *string           `json:"ca_bundle,omitempty"`
+	// Field mapping of a reservation_api calendar's JSON responses.
+	ReservationMapping *models.ReservationMapping `json:"reservation_mapping,omitempty"`
+	// WebhookEnabled is set when channel managers can trigger syncs; the secret is never returned.
+	WebhookEnabled bool `json:"webhook_enabled"`
+	// Priority among calendars listing the same stay on a shared lock.
+	Priority int `json:"priority"`
+	// PIN generation methods tried in order, and the code length.
+	PINMethods []string `json:"pin_methods,omitempty"`
+	PINLength  *int     `json:"pin_length,omitempty"`
+}
+
+// calendarResponseColumns is the column list read by scanCalendarResponse.
+const calendarResponseColumns = `id, name, url, source_type,
+			(SELECT uploaded_at FROM calendar_uploads WHERE calendar_id = calendar_subscriptions.id), sync_interval_min, last_sync_at, next_sync_at, sync_status, sync_error, enabled,
+			platform, timezone, checkin_time, checkout_time, event_rules, removal_grace_syncs, removal_grace_min,
+			anomaly_threshold_pct, anomaly_reason, anomaly_detected_at,
+			auth_type, fetch_headers, max_feed_bytes, redirect_policy, ca_bundle, reservation_mapping, webhook_secret IS NOT NULL, priority,
+			pin_methods, pin_length`
+
+// scanCalendarResponse scans a row selected with calendarResponseColumns.
+func scanCalendarResponse(row interface{ Scan(...interface{}) error }, c *CalendarResponse) error {
+	var eventRules, headers, mapping, pinMethods *string
+	err := row.Scan(
+		&c.ID, &c.Name, &c.URL, &c.SourceType, &c.UploadedAt, &c.SyncIntervalMin, &c.LastSyncAt, &c.NextSyncAt, &c.SyncStatus, &c.SyncError, &c.Enabled,
+		&c.Platform, &c.Timezone, &c.CheckinTime, &c.CheckoutTime, &eventRules,
+		&c.RemovalGraceSyncs, &c.RemovalGraceMin,
+		&c.AnomalyThresholdPct, &c.AnomalyReason, &c.AnomalyDetectedAt,
+		&c.AuthType, &headers, &c.MaxFeedBytes, &c.RedirectPolicy, &c.CABundle, &mapping, &c.WebhookEnabled, &c.Priority,
+		&pinMethods, &c.PINLength,
+	)
+	if err != nil {
+		return err
+	}
+	c.EventRules, err = models.ParseEventRules(eventRules)
+	if err != nil {
+		return err
+	}
+	c.ReservationMapping, err = models.ParseReservationMapping(mapping)
+	if err != nil {
+		return err
+	}
+	c.PINMethods, err = models.ParsePINMethods(pinMethods)
+	if err != nil {
+		return err
+	}
+	if headers != nil {
+		return json.Unmarshal([]byte(*headers), &c.Headers)
+	}
+	return nil
+}
+
+// validateSource checks where a calendar's feed comes from. Upload calendars
+// ignore the URL; it is replaced with uploadURL. Home Assistant calendars
+// store the entity ID as their URL.
+func validateSource(req *CreateCalendarRequest) string {
+	if req.SourceType == "" {
+		req.SourceType = models.SourceICal
+	}
+	switch req.SourceType {
+	case models.SourceICal:
+		if req.URL == "" {
+			return "URL is required"
+		}
+		if err := calendar.ValidateFeedURL(req.URL); err != nil {
+			return "Invalid URL: " + err.Error()
+		}
+	case models.SourceCalDAV:
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "CalDAV URL must be an http or https URL"
+		}
+	case models.SourceHACalendar:
+		if err := calendar.ValidateHACalendarEntity(req.URL); err != nil {
+			return "Home Assistant calendar must be a calendar entity ID, like calendar.rental"
+		}
+	case models.SourceReservationAPI:
+		if req.URL == "" {
+			return "URL is required"
+		}
+		if err := calendar.ValidateFeedURL(req.URL); err != nil {
+			return "Invalid URL: " + err.Error()
+		}
+		if err := calendar.ValidateReservationMapping(req.ReservationMapping); err != nil {
+			return "Invalid reservation mapping: " + err.Error()
+		}
+	case models.SourceUpload:
+	default:
+		return "Source type must be one of: ical, upload, caldav, ha_calendar, reservation_api"
+	}
+	if req.SourceType != models.SourceReservationAPI {
+		req.ReservationMapping = nil
+	}
+	return ""
+}
+
+// uploadURL is the placeholder URL stored for an upload calendar.
+func uploadURL(id string) string {
+	return "upload://" + id
+}
+
+// validatePropertyOverrides normalizes empty overrides to nil (use the global
+// setting) and returns a validation message for invalid values.
+func validatePropertyOverrides(req *CreateCalendarRequest) string {
+	if req.Platform == "" {
+		req.Platform = models.PlatformGeneric
+	}
+	if !models.IsValidPlatform(req.Platform) {
+		return "Platform must be one of: generic, airbnb, vrbo, booking_com"
+	}
+
+	req.Timezone = nullIfEmpty(req.Timezone)
+	req.CheckinTime = nullIfEmpty(req.CheckinTime)
+	req.CheckoutTime = nullIfEmpty(req.CheckoutTime)
+
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "Local" {
+			return "Timezone must be an IANA timezone name, e.g. America/Denver"
+		}
+	}
+	if req.CheckinTime != nil {
+		if _, err := time.Parse("15:04", *req.CheckinTime); err != nil {
+			return "Check-in time must be in HH:MM format"
+		}
+	}
+	if req.CheckoutTime != nil {
+		if _, err := time.Parse("15:04", *req.CheckoutTime); err != nil {
+			return "Check-out time must be in HH:MM format"
+		}
+	}
+	if err := calendar.ValidateEventRules(req.EventRules); err != nil {
+		return "Invalid event rules: " + err.Error()
+	}
+	if err := pin.ValidatePipeline(req.PINMethods, req.PINLength); err != nil {
+		return "Invalid PIN generation settings: " + err.Error()
+	}
+
+	if req.RemovalGraceSyncs == nil {
+		v := models.DefaultRemovalGraceSyncs
+		req.RemovalGraceSyncs = &v
+	}
+	if req.RemovalGraceMin == nil {
+		v := models.DefaultRemovalGraceMin
+		req.RemovalGraceMin = &v
+	}
+	if *req.RemovalGraceSyncs < 0 || *req.RemovalGraceMin < 0 {
+		return "Removal grace cannot be negative"
+	}
+
+	if req.AnomalyThresholdPct == nil {
+		v := models.DefaultAnomalyThresholdPct
+		req.AnomalyThresholdPct = &v
+	}
+	if *req.AnomalyThresholdPct < 0 || *req.AnomalyThresholdPct > 100 {
+		return "Anomaly threshold must be between 0 and 100"
+	}
+	return validateFetchOptions(req)
+}
+
+// validateFetchOptions normalizes and checks a request's feed fetch options.
+func validateFetchOptions(req *CreateCalendarRequest) string {
+	if req.RedirectPolicy == "" {
+		req.RedirectPolicy = models.RedirectFollow
+	}
+	if !models.IsValidRedirectPolicy(req.RedirectPolicy) {
+		return "Redirect policy must be one of: follow, same_host, none"
+	}
+	if req.MaxFeedBytes < 0 {
+		return "Max feed bytes cannot be negative"
+	}
+
+	req.CABundle = nullIfEmpty(req.CABundle)
+	if req.CABundle != nil {
+		if _, err := calendar.CertPool(*req.CABundle); err != nil {
+			return "Invalid CA bundle: " + err.Error()
+		}
+	}
+
+	for name, value := range req.Headers {
+		if !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return "Invalid header: " + name
+		}
+		if credentialHeader(name) {
+			return "Header " + name + " carries a credential; use auth type header instead, which is stored encrypted and never returned"
+		}
+	}
+
+	if auth := req.Auth; auth != nil {
+		switch auth.Type {
+		case models.FeedAuthNone:
+		case models.FeedAuthBasic:
+			if auth.Username == "" {
+				return "Basic auth requires a username"
+			}
+		case models.FeedAuthHeader:
+			if !validHeaderName(auth.Name) || auth.Secret == "" || strings.ContainsAny(auth.Secret, "\r\n") {
+				return "Header auth requires a header name and value"
+			}
+		case models.FeedAuthQuery:
+			if auth.Name == "" || auth.Secret == "" {
+				return "Query auth requires a parameter name and token"
+			}
+		default:
+			return "Auth type must be one of: none, basic, header, query"
+		}
+	}
+	return ""
+}
+
+// validHeaderName reports whether name is a non-empty HTTP header token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// credentialHeaderNames are headers that carry credentials. Custom headers are
+// returned by the API, so credentials must be set through auth instead.
+// Migration 025 strips the same names from headers saved before this check.
+var credentialHeaderNames = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+	"api-key":             true,
+	"x-auth-token":        true,
+	"x-access-token":      true,
+}
+
+// credentialHeader reports whether a custom header name looks like it carries
+// a credential.
+func credentialHeader(name string) bool {
+	lower := strings.ToLower(name)
+	if credentialHeaderNames[lower] {
+		return true
+	}
+	for _, word := range []string{"token", "secret", "password", "apikey", "api-key", "api_key"} {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// nullIfEmpty maps a missing or blank string to nil.
+func nullIfEmpty(s *string) *string {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return nil
+	}
+	v := strings.TrimSpace(*s)
+	return &v
 }
 
 // ListCalendars returns all calendar subscriptions.
@@ -39,7 +361,7 @@ func ListCalendars(db *storage.DB) http.HandlerFunc {
 		ctx := r.Context()
 
 		rows, err := db.QueryContext(ctx, `
-			SELECT id, name, url, sync_interval_min, last_sync_at, sync_status, sync_error, enabled
+			SELECT `+calendarResponseColumns+`
 			FROM calendar_subscriptions ORDER BY name
 		`)
 		if err != nil {
@@ -51,7 +373,7 @@ func ListCalendars(db *storage.DB) http.HandlerFunc {
 		var calendars []CalendarResponse
 		for rows.Next() {
 			var c CalendarResponse
-			if err := rows.Scan(&c.ID, &c.Name, &c.URL, &c.SyncIntervalMin, &c.LastSyncAt, &c.SyncStatus, &c.SyncError, &c.Enabled); err != nil {
+			if err := scanCalendarResponse(rows, &c); err != nil {
 				middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to scan calendar")
 				return
 			}
@@ -76,8 +398,13 @@ func CreateCalendar(db *storage.DB, scheduler *calendar.Scheduler) http.HandlerF
 			return
 		}
 
-		if req.Name == "" || req.URL == "" {
-			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Name and URL are required")
+		if req.Name == "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Name is required")
+			return
+		}
+
+		if msg := validateSource(&req); msg != "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, msg)
 			return
 		}
 
@@ -85,13 +412,53 @@ func CreateCalendar(db *storage.DB, scheduler *calendar.Scheduler) http.HandlerF
 			req.SyncIntervalMin = 15
 		}
 
+		if msg := validatePropertyOverrides(&req); msg != "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, msg)
+			return
+		}
+
 		id := storage.GenerateID()
 		ctx := r.Context()
+		if req.SourceType == models.SourceUpload {
+			req.URL = uploadURL(id)
+		}
+
+		eventRules, err := models.EncodeEventRules(req.EventRules)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid event rules")
+			return
+		}
+		headers, err := storage.EncodeFetchHeaders(req.Headers)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid headers")
+			return
+		}
+		mapping, err := models.EncodeReservationMapping(req.ReservationMapping)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid reservation mapping")
+			return
+		}
+		pinMethods, err := models.EncodePINMethods(req.PINMethods)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid PIN methods")
+			return
+		}
+		authType, credentials, err := storage.EncryptFeedAuth(db, req.Auth)
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to store credentials")
+			return
+		}
 
-		_, err := db.ExecContext(ctx, `
-			INSERT INTO calendar_subscriptions (id, name, url, sync_interval_min, enabled)
-			VALUES (?, ?, ?, ?, ?)
-		`, id, req.Name, req.URL, req.SyncIntervalMin, req.Enabled)
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO calendar_subscriptions (id, name, url, source_type, sync_interval_min, enabled, platform, timezone, checkin_time, checkout_time, event_rules,
+				removal_grace_syncs, removal_grace_min, anomaly_threshold_pct,
+				auth_type, auth_credentials, fetch_headers, max_feed_bytes, redirect_policy, ca_bundle, reservation_mapping, priority,
+				pin_methods, pin_length)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, id, req.Name, req.URL, req.SourceType, req.SyncIntervalMin, req.Enabled, req.Platform, req.Timezone, req.CheckinTime, req.CheckoutTime, eventRules,
+			*req.RemovalGraceSyncs, *req.RemovalGraceMin, *req.AnomalyThresholdPct,
+			authType, credentials, headers, req.MaxFeedBytes, req.RedirectPolicy, req.CABundle, mapping, req.Priority,
+			pinMethods, req.PINLength)
 
 		if err != nil {
 			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to create calendar")
@@ -110,12 +477,30 @@ func CreateCalendar(db *storage.DB, scheduler *calendar.Scheduler) http.HandlerF
 		}
 
 		response := CalendarResponse{
-			ID:              id,
-			Name:            req.Name,
-			URL:             req.URL,
-			SyncIntervalMin: req.SyncIntervalMin,
-			SyncStatus:      "pending",
-			Enabled:         req.Enabled,
+			ID:                  id,
+			Name:                req.Name,
+			URL:                 req.URL,
+			SourceType:          req.SourceType,
+			SyncIntervalMin:     req.SyncIntervalMin,
+			SyncStatus:          "pending",
+			Enabled:             req.Enabled,
+			Platform:            req.Platform,
+			Timezone:            req.Timezone,
+			CheckinTime:         req.CheckinTime,
+			CheckoutTime:        req.CheckoutTime,
+			EventRules:          req.EventRules,
+			RemovalGraceSyncs:   *req.RemovalGraceSyncs,
+			RemovalGraceMin:     *req.RemovalGraceMin,
+			AnomalyThresholdPct: *req.AnomalyThresholdPct,
+			AuthType:            authType,
+			Headers:             req.Headers,
+			MaxFeedBytes:        req.MaxFeedBytes,
+			RedirectPolicy:      req.RedirectPolicy,
+			CABundle:            req.CABundle,
+			ReservationMapping:  req.ReservationMapping,
+			Priority:            req.Priority,
+			PINMethods:          req.PINMethods,
+			PINLength:           req.PINLength,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -131,10 +516,10 @@ func GetCalendar(db *storage.DB) http.HandlerFunc {
 		ctx := r.Context()
 
 		var c CalendarResponse
-		err := db.QueryRowContext(ctx, `
-			SELECT id, name, url, sync_interval_min, last_sync_at, sync_status, sync_error, enabled
+		err := scanCalendarResponse(db.QueryRowContext(ctx, `
+			SELECT `+calendarResponseColumns+`
 			FROM calendar_subscriptions WHERE id = ?
-		`, id).Scan(&c.ID, &c.Name, &c.URL, &c.SyncIntervalMin, &c.LastSyncAt, &c.SyncStatus, &c.SyncError, &c.Enabled)
+		`, id), &c)
 
 		if err != nil {
 			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
@@ -158,11 +543,62 @@ func UpdateCalendar(db *storage.DB, scheduler *calendar.Scheduler) http.HandlerF
 			return
 		}
 
+		if msg := validateSource(&req); msg != "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, msg)
+			return
+		}
+		if req.SourceType == models.SourceUpload {
+			req.URL = uploadURL(id)
+		}
+
+		switch {
+		case req.SyncIntervalMin == 0:
+			req.SyncIntervalMin = 15
+		case req.SyncIntervalMin < 5:
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Sync interval must be at least 5 minutes")
+			return
+		}
+
+		if msg := validatePropertyOverrides(&req); msg != "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, msg)
+			return
+		}
+
+		eventRules, err := models.EncodeEventRules(req.EventRules)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid event rules")
+			return
+		}
+		headers, err := storage.EncodeFetchHeaders(req.Headers)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid headers")
+			return
+		}
+		mapping, err := models.EncodeReservationMapping(req.ReservationMapping)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid reservation mapping")
+			return
+		}
+		pinMethods, err := models.EncodePINMethods(req.PINMethods)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid PIN methods")
+			return
+		}
+
 		result, err := db.ExecContext(ctx, `
 			UPDATE calendar_subscriptions SET
-				name = ?, url = ?, sync_interval_min = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+				name = ?, url = ?, source_type = ?, sync_interval_min = ?, enabled = ?, platform = ?,
+				timezone = ?, checkin_time = ?, checkout_time = ?, event_rules = ?,
+				removal_grace_syncs = ?, removal_grace_min = ?, anomaly_threshold_pct = ?,
+				fetch_headers = ?, max_feed_bytes = ?, redirect_policy = ?, ca_bundle = ?, reservation_mapping = ?, priority = ?,
+				pin_methods = ?, pin_length = ?,
+				feed_etag = NULL, feed_last_modified = NULL, feed_content_hash = NULL, caldav_sync_token = NULL,
+				updated_at = CURRENT_TIMESTAMP
 			WHERE id = ?
-		`, req.Name, req.URL, req.SyncIntervalMin, req.Enabled, id)
+		`, req.Name, req.URL, req.SourceType, req.SyncIntervalMin, req.Enabled, req.Platform, req.Timezone, req.CheckinTime, req.CheckoutTime, eventRules,
+			*req.RemovalGraceSyncs, *req.RemovalGraceMin, *req.AnomalyThresholdPct,
+			headers, req.MaxFeedBytes, req.RedirectPolicy, req.CABundle, mapping, req.Priority,
+			pinMethods, req.PINLength, id)
 
 		if err != nil {
 			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to update calendar")
@@ -175,15 +611,36 @@ func UpdateCalendar(db *storage.DB, scheduler *calendar.Scheduler) http.HandlerF
 			return
 		}
 
-		// Update the scheduler with the new calendar settings
+		// An uploaded feed is only kept while the calendar reads from uploads
+		if req.SourceType != models.SourceUpload {
+			if _, err := db.ExecContext(ctx, "DELETE FROM calendar_uploads WHERE calendar_id = ?", id); err != nil {
+				middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to remove uploaded feed")
+				return
+			}
+		}
+
+		// Credentials are only replaced when given
+		if req.Auth != nil {
+			authType, credentials, err := storage.EncryptFeedAuth(db, req.Auth)
+			if err == nil {
+				_, err = db.ExecContext(ctx, `
+					UPDATE calendar_subscriptions SET auth_type = ?, auth_credentials = ? WHERE id = ?
+				`, authType, credentials, id)
+			}
+			if err != nil {
+				middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to store credentials")
+				return
+			}
+		}
+
+		// Update the scheduler with the saved calendar settings
 		if scheduler != nil {
-			scheduler.ScheduleCalendar(models.CalendarSubscription{
-				ID:              id,
-				Name:            req.Name,
-				URL:             req.URL,
-				SyncIntervalMin: req.SyncIntervalMin,
-				Enabled:         req.Enabled,
-			})
+			cal, err := storage.NewCalendarRepository(db).GetByID(ctx, id)
+			if err != nil || cal == nil {
+				log.Printf("Failed to reload calendar %s for scheduling: %v", id, err)
+			} else {
+				scheduler.ScheduleCalendar(*cal)
+			}
 		}
 
 		w.WriteHeader(http.StatusNoContent)
@@ -230,49 +687,281 @@ func SyncCalendar(db *storage.DB, hub *websocket.Hub, syncService *calendar.Sync
 			return
 		}
 
+		// A dry run returns the planned changes without writing anything
+		if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+			if syncService == nil {
+				middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Calendar sync is not available")
+				return
+			}
+
+			plan, err := syncService.PreviewCalendar(r.Context(), id)
+			if err != nil {
+				middleware.WriteError(w, http.StatusBadGateway, middleware.ErrInternalError, "Failed to preview calendar sync: "+err.Error())
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(plan)
+			return
+		}
+
 		// Return immediately with syncing status
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "syncing"})
 
 		// Trigger sync in background
+		go syncInBackground(db, hub, syncService, id, calName)
+	}
+}
+
+// SyncAllCalendars syncs every enabled calendar in the background, streaming
+// calendar.sync_progress events over the WebSocket.
+func SyncAllCalendars(hub *websocket.Hub, syncService *calendar.SyncService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if syncService == nil {
+			middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Calendar sync is not available")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "syncing"})
+
 		go func() {
-			ctx := context.Background()
-
-			if syncService != nil {
-				// Use the sync service for full sync
-				result, err := syncService.SyncCalendar(ctx, id)
-				if err != nil {
-					if hub != nil {
-						broadcaster := websocket.NewEventBroadcaster(hub)
-						broadcaster.BroadcastCalendarSyncError(id, calName, err)
-					}
+			var broadcaster *websocket.EventBroadcaster
+			if hub != nil {
+				broadcaster = websocket.NewEventBroadcaster(hub)
+			}
+
+			_, err := syncService.SyncAllEnabled(context.Background(), func(p calendar.SyncProgress) {
+				if broadcaster == nil {
 					return
 				}
-
-				// Broadcast completion event
-				if hub != nil {
-					broadcaster := websocket.NewEventBroadcaster(hub)
-					broadcaster.BroadcastCalendarSyncCompleted(*result)
+				if p.Completed == 0 {
+					broadcaster.BroadcastCalendarSyncProgress(0, p.Total, nil)
+					return
 				}
-			} else {
-				// Fallback: just update status (no sync service available)
-				db.ExecContext(ctx, `
-					UPDATE calendar_subscriptions SET 
-						sync_status = 'success', 
-						last_sync_at = CURRENT_TIMESTAMP,
-						updated_at = CURRENT_TIMESTAMP
-					WHERE id = ?
-				`, id)
-
-				if hub != nil {
-					broadcaster := websocket.NewEventBroadcaster(hub)
-					broadcaster.BroadcastNotification("success", "Calendar Synced", "Calendar sync completed successfully")
+				if p.Result.Error != nil {
+					broadcaster.BroadcastCalendarSyncError(p.Result.CalendarID, p.Result.CalendarName, p.Result.Error)
+				} else {
+					broadcaster.BroadcastCalendarSyncCompleted(p.Result)
 				}
+				broadcaster.BroadcastCalendarSyncProgress(p.Completed, p.Total, &p.Result)
+			})
+			if err != nil {
+				log.Printf("Failed to sync all calendars: %v", err)
 			}
 		}()
 	}
 }
 
+// syncInBackground syncs a calendar and broadcasts the outcome. Without a
+// sync service the calendar is only marked as synced.
+func syncInBackground(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService, id, calName string) {
+	ctx := context.Background()
+
+	if syncService != nil {
+		// Use the sync service for full sync
+		result, err := syncService.SyncCalendar(ctx, id)
+		if err != nil {
+			if hub != nil {
+				broadcaster := websocket.NewEventBroadcaster(hub)
+				broadcaster.BroadcastCalendarSyncError(id, calName, err)
+			}
+			return
+		}
+
+		// Broadcast completion event
+		if hub != nil {
+			broadcaster := websocket.NewEventBroadcaster(hub)
+			broadcaster.BroadcastCalendarSyncCompleted(*result)
+		}
+	} else {
+		// Fallback: just update status (no sync service available)
+		db.ExecContext(ctx, `
+			UPDATE calendar_subscriptions SET 
+				sync_status = 'success', 
+				last_sync_at = CURRENT_TIMESTAMP,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, id)
+
+		if hub != nil {
+			broadcaster := websocket.NewEventBroadcaster(hub)
+			broadcaster.BroadcastNotification("success", "Calendar Synced", "Calendar sync completed successfully")
+		}
+	}
+}
+
+// UploadCalendarICS stores the ICS body of an upload calendar and syncs it.
+func UploadCalendarICS(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		ctx := r.Context()
+
+		var calName, sourceType string
+		var maxBytes int64
+		err := db.QueryRowContext(ctx, `
+			SELECT name, source_type, max_feed_bytes FROM calendar_subscriptions WHERE id = ?
+		`, id).Scan(&calName, &sourceType, &maxBytes)
+		if err != nil {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
+			return
+		}
+		if sourceType != models.SourceUpload {
+			middleware.WriteError(w, http.StatusConflict, middleware.ErrConflict, "Calendar is not an upload calendar")
+			return
+		}
+
+		if maxBytes <= 0 {
+			maxBytes = models.DefaultMaxFeedBytes
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			middleware.WriteError(w, http.StatusRequestEntityTooLarge, middleware.ErrValidation, "ICS file exceeds the calendar's size limit")
+			return
+		}
+
+		if _, err := calendar.NewParser().Parse(bytes.NewReader(body)); err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Invalid ICS file: "+err.Error())
+			return
+		}
+
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO calendar_uploads (calendar_id, body, size, uploaded_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(calendar_id) DO UPDATE SET body = excluded.body, size = excluded.size, uploaded_at = excluded.uploaded_at
+		`, id, body, len(body))
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to store ICS file")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "syncing"})
+
+		go syncInBackground(db, hub, syncService, id, calName)
+	}
+}
+
+// ConfirmCalendarAnomaly accepts a feed flagged as anomalous and re-syncs the
+// calendar without the anomaly guard, applying the PIN removals that were held.
+func ConfirmCalendarAnomaly(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var calName string
+		var anomalyReason *string
+		err := db.QueryRowContext(r.Context(), "SELECT name, anomaly_reason FROM calendar_subscriptions WHERE id = ?", id).Scan(&calName, &anomalyReason)
+		if err != nil {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
+			return
+		}
+
+		if anomalyReason == nil {
+			middleware.WriteError(w, http.StatusConflict, middleware.ErrConflict, "Calendar has no sync anomaly to confirm")
+			return
+		}
+
+		if syncService == nil {
+			middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Calendar sync is not available")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "syncing"})
+
+		go func() {
+			result, err := syncService.ConfirmAnomaly(context.Background(), id)
+			if hub == nil {
+				return
+			}
+			broadcaster := websocket.NewEventBroadcaster(hub)
+			if err != nil {
+				broadcaster.BroadcastCalendarSyncError(id, calName, err)
+				return
+			}
+			broadcaster.BroadcastCalendarSyncCompleted(*result)
+		}()
+	}
+}
+
+// PreviewCalendarRequest is an unsaved calendar to preview, with the locks it
+// would be assigned to.
+type PreviewCalendarRequest struct {
+	CreateCalendarRequest
+	LockIDs []string `json:"lock_ids"`
+}
+
+// PreviewCalendar fetches an unsaved calendar URL and returns the PINs a sync
+// would create, without saving the calendar or any PINs.
+func PreviewCalendar(db *storage.DB, syncService *calendar.SyncService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PreviewCalendarRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrBadRequest, "Invalid request body")
+			return
+		}
+
+		if msg := validateSource(&req.CreateCalendarRequest); msg != "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, msg)
+			return
+		}
+		if req.SourceType == models.SourceUpload || req.SourceType == models.SourceCalDAV {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Upload and CalDAV calendars cannot be previewed before saving")
+			return
+		}
+
+		if msg := validatePropertyOverrides(&req.CreateCalendarRequest); msg != "" {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, msg)
+			return
+		}
+
+		if syncService == nil {
+			middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Calendar sync is not available")
+			return
+		}
+
+		ctx := r.Context()
+		for _, lockID := range req.LockIDs {
+			var exists bool
+			db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM managed_locks WHERE id = ?)", lockID).Scan(&exists)
+			if !exists {
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Lock not found: "+lockID)
+				return
+			}
+		}
+
+		cal := &models.CalendarSubscription{
+			Name:                req.Name,
+			URL:                 req.URL,
+			SourceType:          req.SourceType,
+			Platform:            req.Platform,
+			Timezone:            req.Timezone,
+			CheckinTime:         req.CheckinTime,
+			CheckoutTime:        req.CheckoutTime,
+			EventRules:          req.EventRules,
+			RemovalGraceSyncs:   *req.RemovalGraceSyncs,
+			RemovalGraceMin:     *req.RemovalGraceMin,
+			AnomalyThresholdPct: *req.AnomalyThresholdPct,
+			Fetch:               req.fetchOptions(),
+			ReservationMapping:  req.ReservationMapping,
+			Priority:            req.Priority,
+			PINMethods:          req.PINMethods,
+			PINLength:           req.PINLength,
+		}
+
+		plan, err := syncService.PreviewSubscription(ctx, cal, req.LockIDs)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadGateway, middleware.ErrInternalError, "Failed to preview calendar: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plan)
+	}
+}
+
 // GetCalendarLocks returns locks assigned to a calendar.
 func GetCalendarLocks(db *storage.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -337,6 +1026,16 @@ func UpdateCalendarLocks(db *storage.DB) http.HandlerFunc {
 			}
 		}
 
+		// New locks need PINs even if the feed has not changed
+		_, err = db.ExecContext(ctx, `
+			UPDATE calendar_subscriptions SET feed_etag = NULL, feed_last_modified = NULL, feed_content_hash = NULL
+			WHERE id = ?
+		`, id)
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to update lock mappings")
+			return
+		}
+
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/guest-lock-manager/backend/internal/api/middleware"
+	"github.com/guest-lock-manager/backend/internal/calendar"
+	"github.com/guest-lock-manager/backend/internal/lock"
 	"github.com/guest-lock-manager/backend/internal/storage"
+	"github.com/guest-lock-manager/backend/internal/storage/models"
 	"github.com/guest-lock-manager/backend/internal/websocket"
 )
 
@@ -23,6 +29,81 @@ type GuestPinResponse struct {
 	ValidUntil            string  `json:"valid_until"`
 	Status                string  `json:"status"`
 	RegenerationEligible  bool    `json:"regeneration_eligible"`
+	ReservationCode       *string `json:"reservation_code,omitempty"`
+	GuestName             *string `json:"guest_name,omitempty"`
+	GuestPhoneLast4       *string `json:"guest_phone_last4,omitempty"`
+	ReservationURL        *string `json:"reservation_url,omitempty"`
+	MissingSince          *string `json:"missing_since,omitempty"`
+	MissingCount          int     `json:"missing_count,omitempty"`
+	// LinkedPinID is set when the stay is programmed by another calendar's PIN;
+	// LinkedEvents lists the other calendars' events linked to this PIN.
+	LinkedPinID  *string               `json:"linked_pin_id,omitempty"`
+	LinkedEvents []LinkedEventResponse `json:"linked_events,omitempty"`
+}
+
+// LinkedEventResponse is an event on another calendar for the same stay as a
+// guest PIN. Its PIN is linked rather than programmed.
+type LinkedEventResponse struct {
+	GuestPinID   string  `json:"guest_pin_id"`
+	CalendarID   string  `json:"calendar_id"`
+	CalendarName string  `json:"calendar_name"`
+	EventUID     string  `json:"event_uid"`
+	EventSummary *string `json:"event_summary,omitempty"`
+}
+
+// guestPinResponseColumns is the column list read by scanGuestPinResponse.
+const guestPinResponseColumns = `id, calendar_id, event_uid, event_summary, pin_code, generation_method,
+			       custom_pin, valid_from, valid_until, status, regeneration_eligible,
+			       reservation_code, guest_name, guest_phone_last4, reservation_url,
+			       missing_since, missing_count, linked_pin_id`
+
+// scanGuestPinResponse scans a row selected with guestPinResponseColumns.
+func scanGuestPinResponse(row interface{ Scan(...interface{}) error }, p *GuestPinResponse) error {
+	return row.Scan(&p.ID, &p.CalendarID, &p.EventUID, &p.EventSummary, &p.PinCode,
+		&p.GenerationMethod, &p.CustomPin, &p.ValidFrom, &p.ValidUntil, &p.Status, &p.RegenerationEligible,
+		&p.ReservationCode, &p.GuestName, &p.GuestPhoneLast4, &p.ReservationURL,
+		&p.MissingSince, &p.MissingCount, &p.LinkedPinID)
+}
+
+// loadLinkedEvents fills in LinkedEvents on pins. With a primaryID only the
+// events linked to that PIN are read.
+func loadLinkedEvents(ctx context.Context, db *storage.DB, pins []GuestPinResponse, primaryID string) error {
+	query := `
+		SELECT gp.linked_pin_id, gp.id, gp.calendar_id, c.name, gp.event_uid, gp.event_summary
+		FROM guest_pins gp
+		JOIN calendar_subscriptions c ON c.id = gp.calendar_id
+		WHERE gp.linked_pin_id IS NOT NULL
+	`
+	var args []any
+	if primaryID != "" {
+		query += " AND gp.linked_pin_id = ?"
+		args = append(args, primaryID)
+	}
+	query += " ORDER BY gp.created_at"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	linked := make(map[string][]LinkedEventResponse)
+	for rows.Next() {
+		var primary string
+		var e LinkedEventResponse
+		if err := rows.Scan(&primary, &e.GuestPinID, &e.CalendarID, &e.CalendarName, &e.EventUID, &e.EventSummary); err != nil {
+			return err
+		}
+		linked[primary] = append(linked[primary], e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range pins {
+		pins[i].LinkedEvents = linked[pins[i].ID]
+	}
+	return nil
 }
 
 // ListGuestPins returns all guest PINs with optional filtering.
@@ -33,8 +114,7 @@ func ListGuestPins(db *storage.DB) http.HandlerFunc {
 		status := r.URL.Query().Get("status")
 
 		query := `
-			SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-			       custom_pin, valid_from, valid_until, status, regeneration_eligible
+			SELECT ` + guestPinResponseColumns + `
 			FROM guest_pins WHERE 1=1
 		`
 		var args []any
@@ -60,8 +140,7 @@ func ListGuestPins(db *storage.DB) http.HandlerFunc {
 		var pins []GuestPinResponse
 		for rows.Next() {
 			var p GuestPinResponse
-			if err := rows.Scan(&p.ID, &p.CalendarID, &p.EventUID, &p.EventSummary, &p.PinCode,
-				&p.GenerationMethod, &p.CustomPin, &p.ValidFrom, &p.ValidUntil, &p.Status, &p.RegenerationEligible); err != nil {
+			if err := scanGuestPinResponse(rows, &p); err != nil {
 				middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to scan guest PIN")
 				return
 			}
@@ -72,6 +151,11 @@ func ListGuestPins(db *storage.DB) http.HandlerFunc {
 			pins = []GuestPinResponse{}
 		}
 
+		if err := loadLinkedEvents(ctx, db, pins, ""); err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to query linked events")
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(pins)
 	}
@@ -84,25 +168,31 @@ func GetGuestPin(db *storage.DB) http.HandlerFunc {
 		ctx := r.Context()
 
 		var p GuestPinResponse
-		err := db.QueryRowContext(ctx, `
-			SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-			       custom_pin, valid_from, valid_until, status, regeneration_eligible
+		err := scanGuestPinResponse(db.QueryRowContext(ctx, `
+			SELECT `+guestPinResponseColumns+`
 			FROM guest_pins WHERE id = ?
-		`, id).Scan(&p.ID, &p.CalendarID, &p.EventUID, &p.EventSummary, &p.PinCode,
-			&p.GenerationMethod, &p.CustomPin, &p.ValidFrom, &p.ValidUntil, &p.Status, &p.RegenerationEligible)
+		`, id), &p)
 
 		if err != nil {
 			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Guest PIN not found")
 			return
 		}
 
+		pins := []GuestPinResponse{p}
+		if err := loadLinkedEvents(ctx, db, pins, p.ID); err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to query linked events")
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(p)
+		json.NewEncoder(w).Encode(pins[0])
 	}
 }
 
-// UpdateGuestPin updates a guest PIN (e.g., set custom PIN or status).
-func UpdateGuestPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
+// UpdateGuestPin updates a guest PIN (e.g., set custom PIN or status). A
+// custom PIN whose code another PIN uses on the same lock is saved in conflict
+// status and a pin.conflict_detected event is sent for each conflict.
+func UpdateGuestPin(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
 		ctx := r.Context()
@@ -118,19 +208,40 @@ func UpdateGuestPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 
 		// If custom PIN is provided, update it
 		if req.CustomPin != nil {
-			_, err := db.ExecContext(ctx, `
-				UPDATE guest_pins SET
-					custom_pin = ?,
-					pin_code = ?,
-					generation_method = 'custom',
-					updated_at = CURRENT_TIMESTAMP
-				WHERE id = ?
-			`, req.CustomPin, req.CustomPin, id)
+			if syncService == nil {
+				middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Custom PINs are not available")
+				return
+			}
 
-			if err != nil {
+			var previousStatus string
+			db.QueryRowContext(ctx, "SELECT status FROM guest_pins WHERE id = ?", id).Scan(&previousStatus)
+
+			updated, conflicts, err := syncService.SetCustomPIN(ctx, id, *req.CustomPin)
+			switch {
+			case errors.Is(err, calendar.ErrPINNotFound):
+				middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Guest PIN not found")
+				return
+			case errors.Is(err, calendar.ErrInvalidPIN):
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, err.Error())
+				return
+			case err != nil:
 				middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to update guest PIN")
 				return
 			}
+
+			if hub != nil {
+				broadcaster := websocket.NewEventBroadcaster(hub)
+				if updated.Status != previousStatus {
+					summary := ""
+					if updated.EventSummary != nil {
+						summary = *updated.EventSummary
+					}
+					broadcaster.BroadcastPINStatusChanged(id, "guest", previousStatus, updated.Status, summary)
+				}
+				for _, c := range conflicts {
+					broadcaster.BroadcastPINConflictDetected(c)
+				}
+			}
 		}
 
 		// If status is provided, update it (for manual activation/deactivation)
@@ -162,12 +273,10 @@ func UpdateGuestPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 
 		// Return updated PIN
 		var p GuestPinResponse
-		err := db.QueryRowContext(ctx, `
-			SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-			       custom_pin, valid_from, valid_until, status, regeneration_eligible
+		err := scanGuestPinResponse(db.QueryRowContext(ctx, `
+			SELECT `+guestPinResponseColumns+`
 			FROM guest_pins WHERE id = ?
-		`, id).Scan(&p.ID, &p.CalendarID, &p.EventUID, &p.EventSummary, &p.PinCode,
-			&p.GenerationMethod, &p.CustomPin, &p.ValidFrom, &p.ValidUntil, &p.Status, &p.RegenerationEligible)
+		`, id), &p)
 
 		if err != nil {
 			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Guest PIN not found")
@@ -179,53 +288,63 @@ func UpdateGuestPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 	}
 }
 
-// RegenerateGuestPin regenerates a PIN using the next available method.
-func RegenerateGuestPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
+// RegenerateGuestPin regenerates a PIN using the next available method and
+// queues the new code on the PIN's lock slots.
+func RegenerateGuestPin(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService, lockManager *lock.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
 		ctx := r.Context()
 
-		// Check if regeneration is eligible
-		var eligible bool
-		err := db.QueryRowContext(ctx, `
-			SELECT regeneration_eligible FROM guest_pins WHERE id = ?
-		`, id).Scan(&eligible)
-
-		if err != nil {
-			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Guest PIN not found")
+		if syncService == nil {
+			middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "PIN regeneration is not available")
 			return
 		}
 
-		if !eligible {
-			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrBadRequest, "PIN is not eligible for regeneration")
+		regenerated, previousStatus, assignments, err := syncService.RegeneratePIN(ctx, id)
+		switch {
+		case errors.Is(err, calendar.ErrPINNotFound):
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Guest PIN not found")
+			return
+		case errors.Is(err, calendar.ErrPINNotRegenerable):
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrBadRequest, "PIN is not eligible for regeneration; it must start at least a day from now")
+			return
+		case errors.Is(err, calendar.ErrNoAlternativePIN):
+			middleware.WriteError(w, http.StatusConflict, middleware.ErrConflict, "No conflict-free PIN could be generated")
+			return
+		case err != nil:
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to regenerate PIN")
 			return
 		}
 
-		// TODO: Implement actual PIN regeneration logic
-		// For now, just mark as regenerated with a placeholder
-		newPin := "0000" // Placeholder - should use PIN generator
-
-		_, err = db.ExecContext(ctx, `
-			UPDATE guest_pins SET
-				pin_code = ?,
-				generation_method = 'date_based',
-				updated_at = CURRENT_TIMESTAMP
-			WHERE id = ?
-		`, newPin, id)
+		// Replace the old code in every slot the PIN holds
+		if lockManager != nil {
+			for _, a := range assignments {
+				if err := lockManager.SetPIN(ctx, a.LockID, regenerated.PINCode, a.SlotNumber, regenerated.ID); err != nil {
+					log.Printf("Failed to queue regenerated PIN for lock %s: %v", a.LockID, err)
+				}
+			}
+		}
 
-		if err != nil {
-			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to regenerate PIN")
-			return
+		if hub != nil {
+			broadcaster := websocket.NewEventBroadcaster(hub)
+			summary := ""
+			if regenerated.EventSummary != nil {
+				summary = *regenerated.EventSummary
+			}
+			broadcaster.BroadcastPINStatusChanged(regenerated.ID, "guest", previousStatus, regenerated.Status, summary)
+			for _, a := range assignments {
+				var lockName string
+				db.QueryRowContext(ctx, "SELECT name FROM managed_locks WHERE id = ?", a.LockID).Scan(&lockName)
+				broadcaster.BroadcastPINSyncStatusChanged(regenerated.ID, "guest", a.LockID, lockName, a.SyncStatus, models.LockSyncPending, a.SlotNumber)
+			}
 		}
 
 		// Return updated PIN
 		var p GuestPinResponse
-		db.QueryRowContext(ctx, `
-			SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-			       custom_pin, valid_from, valid_until, status, regeneration_eligible
+		scanGuestPinResponse(db.QueryRowContext(ctx, `
+			SELECT `+guestPinResponseColumns+`
 			FROM guest_pins WHERE id = ?
-		`, id).Scan(&p.ID, &p.CalendarID, &p.EventUID, &p.EventSummary, &p.PinCode,
-			&p.GenerationMethod, &p.CustomPin, &p.ValidFrom, &p.ValidUntil, &p.Status, &p.RegenerationEligible)
+		`, id), &p)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(p)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/guest-lock-manager/backend/internal/lock"
 	"github.com/guest-lock-manager/backend/internal/storage"
@@ -101,6 +102,17 @@ func Status(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 			PendingOperations:    pendingOps,
 		}
 
+		// Earliest scheduled calendar sync
+		var nextSyncAt time.Time
+		err := db.QueryRowContext(ctx, `
+			SELECT next_sync_at FROM calendar_subscriptions
+			WHERE enabled = 1 AND next_sync_at IS NOT NULL
+			ORDER BY next_sync_at LIMIT 1
+		`).Scan(&nextSyncAt)
+		if err == nil {
+			response.NextSyncAt = nextSyncAt.UTC().Format(time.RFC3339)
+		}
+
 		// Log detection result for visibility when the settings page checks status
 		log.Printf("Status check: Z-Wave JS UI available=%v url=%s, Zigbee2MQTT available=%v",
 			zwaveAvailable, lock.GetZWaveJSUIURL(), zigbeeAvailable)
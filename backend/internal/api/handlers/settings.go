@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/guest-lock-manager/backend/internal/api/middleware"
+	"github.com/guest-lock-manager/backend/internal/calendar"
 	"github.com/guest-lock-manager/backend/internal/lock"
+	"github.com/guest-lock-manager/backend/internal/pin"
 	"github.com/guest-lock-manager/backend/internal/storage"
 )
 
@@ -19,28 +25,27 @@ type SettingsResponse struct {
 	BatteryEfficientMode   string `json:"battery_efficient_mode"`
 	BatchWindowSeconds     string `json:"batch_window_seconds"`
 	ZWaveJSUIWSURL         string `json:"zwave_js_ui_ws_url"`
+	RecurrenceHorizonDays  string `json:"recurrence_horizon_days"`
+	Timezone               string `json:"timezone"`
+	SyncSnapshotRetention  string `json:"sync_snapshot_retention"`
+	PinConflictPolicy      string `json:"pin_conflict_policy"`
+	PinCodeReuseDays       string `json:"pin_code_reuse_days"`
+	PinRejectSequences     string `json:"pin_reject_sequences"`
+	PinRejectCommon        string `json:"pin_reject_common"`
+	PinRejectYears         string `json:"pin_reject_years"`
+	PinRejectPrefixes      string `json:"pin_reject_prefixes"`
+	PinMinDistance         string `json:"pin_min_distance"`
+	PinBlocklist           string `json:"pin_blocklist"`
 }
 
 // GetSettings returns all settings.
 func GetSettings(db *storage.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
-		rows, err := db.QueryContext(ctx, "SELECT key, value FROM settings")
+		settings, err := loadSettings(r.Context(), db)
 		if err != nil {
 			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to query settings")
 			return
 		}
-		defer rows.Close()
-
-		settings := make(map[string]string)
-		for rows.Next() {
-			var key, value string
-			if err := rows.Scan(&key, &value); err != nil {
-				continue
-			}
-			settings[key] = value
-		}
 
 		response := SettingsResponse{
 			DefaultSyncIntervalMin: settings["default_sync_interval_min"],
@@ -51,6 +56,17 @@ func GetSettings(db *storage.DB) http.HandlerFunc {
 			BatteryEfficientMode:   settings["battery_efficient_mode"],
 			BatchWindowSeconds:     settings["batch_window_seconds"],
 			ZWaveJSUIWSURL:         settings["zwave_js_ui_ws_url"],
+			RecurrenceHorizonDays:  settings["recurrence_horizon_days"],
+			Timezone:               settings["timezone"],
+			SyncSnapshotRetention:  settings["sync_snapshot_retention"],
+			PinConflictPolicy:      settings["pin_conflict_policy"],
+			PinCodeReuseDays:       settings["pin_code_reuse_days"],
+			PinRejectSequences:     settings[pin.SettingRejectSequences],
+			PinRejectCommon:        settings[pin.SettingRejectCommon],
+			PinRejectYears:         settings[pin.SettingRejectYears],
+			PinRejectPrefixes:      settings[pin.SettingRejectPrefixes],
+			PinMinDistance:         settings[pin.SettingMinDistance],
+			PinBlocklist:           settings[pin.SettingBlocklist],
 		}
 
 		// Provide defaults when not stored
@@ -63,8 +79,9 @@ func GetSettings(db *storage.DB) http.HandlerFunc {
 	}
 }
 
-// UpdateSettings updates settings.
-func UpdateSettings(db *storage.DB) http.HandlerFunc {
+// UpdateSettings updates settings. Settings the sync service uses take effect
+// on its next sync; syncService may be nil.
+func UpdateSettings(db *storage.DB, syncService *calendar.SyncService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -74,16 +91,96 @@ func UpdateSettings(db *storage.DB) http.HandlerFunc {
 			return
 		}
 
+		if req.Timezone != "" {
+			if _, err := time.LoadLocation(req.Timezone); err != nil || req.Timezone == "Local" {
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Timezone must be an IANA timezone name, e.g. America/Denver")
+				return
+			}
+		}
+
+		if req.RecurrenceHorizonDays != "" {
+			if n, err := strconv.Atoi(req.RecurrenceHorizonDays); err != nil || n <= 0 || n > calendar.MaxRecurrenceHorizonDays {
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation,
+					fmt.Sprintf("Recurrence horizon days must be a number from 1 to %d", calendar.MaxRecurrenceHorizonDays))
+				return
+			}
+		}
+
+		if req.SyncSnapshotRetention != "" {
+			if n, err := strconv.Atoi(req.SyncSnapshotRetention); err != nil || n < 0 {
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Sync snapshot retention must be a non-negative number")
+				return
+			}
+		}
+
+		if req.PinConflictPolicy != "" && !pin.IsValidConflictPolicy(req.PinConflictPolicy) {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "PIN conflict policy must be auto_resolve or flag")
+			return
+		}
+
+		if req.PinCodeReuseDays != "" {
+			if n, err := strconv.Atoi(req.PinCodeReuseDays); err != nil || n < 0 {
+				middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "PIN code reuse days must be a non-negative number")
+				return
+			}
+		}
+
+		policySettings := map[string]string{
+			pin.SettingRejectSequences: req.PinRejectSequences,
+			pin.SettingRejectCommon:    req.PinRejectCommon,
+			pin.SettingRejectYears:     req.PinRejectYears,
+			pin.SettingRejectPrefixes:  req.PinRejectPrefixes,
+			pin.SettingMinDistance:     req.PinMinDistance,
+			pin.SettingBlocklist:       req.PinBlocklist,
+		}
+
+		// PIN lengths and policy are checked as they will be stored, with the
+		// settings the request leaves out
+		stored, err := loadSettings(ctx, db)
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to query settings")
+			return
+		}
+		for key, value := range policySettings {
+			if value != "" {
+				stored[key] = value
+			}
+		}
+		if req.MinPinLength != "" {
+			stored[pin.SettingMinLength] = req.MinPinLength
+		}
+		if req.MaxPinLength != "" {
+			stored[pin.SettingMaxLength] = req.MaxPinLength
+		}
+		minPIN, maxPIN, err := pin.ParseLengths(stored[pin.SettingMinLength], stored[pin.SettingMaxLength])
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, err.Error())
+			return
+		}
+		pinPolicy, err := pin.ParsePolicy(stored)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, err.Error())
+			return
+		}
+
 		// Update each setting
 		settings := map[string]string{
 			"default_sync_interval_min": req.DefaultSyncIntervalMin,
-			"min_pin_length":            req.MinPinLength,
-			"max_pin_length":            req.MaxPinLength,
+			pin.SettingMinLength:        req.MinPinLength,
+			pin.SettingMaxLength:        req.MaxPinLength,
 			"checkin_time":              req.CheckinTime,
 			"checkout_time":             req.CheckoutTime,
 			"battery_efficient_mode":    req.BatteryEfficientMode,
 			"batch_window_seconds":      req.BatchWindowSeconds,
 			"zwave_js_ui_ws_url":        req.ZWaveJSUIWSURL,
+			"recurrence_horizon_days":   req.RecurrenceHorizonDays,
+			"timezone":                  req.Timezone,
+			"sync_snapshot_retention":   req.SyncSnapshotRetention,
+			"pin_conflict_policy":       req.PinConflictPolicy,
+			"pin_code_reuse_days":       req.PinCodeReuseDays,
+		}
+		for key, value := range policySettings {
+			settings[key] = value
 		}
 
 		for key, value := range settings {
@@ -101,6 +198,16 @@ func UpdateSettings(db *storage.DB) http.HandlerFunc {
 
 		// Update runtime config for immediate effect
 		lock.SetZWaveJSUIURL(req.ZWaveJSUIWSURL)
+		if syncService != nil {
+			syncService.SetPropertyDefaults(req.CheckinTime, req.CheckoutTime, req.Timezone)
+			syncService.SetPINSettings(minPIN, maxPIN, pinPolicy)
+			if req.PinConflictPolicy != "" {
+				syncService.SetConflictPolicy(req.PinConflictPolicy)
+			}
+			if days, err := strconv.Atoi(req.PinCodeReuseDays); err == nil {
+				syncService.SetCodeReuseDays(days)
+			}
+		}
 		// Reflect effective value back to the client
 		if req.ZWaveJSUIWSURL == "" {
 			req.ZWaveJSUIWSURL = lock.GetZWaveJSUIURL()
@@ -110,3 +217,22 @@ func UpdateSettings(db *storage.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(req)
 	}
 }
+
+// loadSettings reads every stored setting.
+func loadSettings(ctx context.Context, db *storage.DB) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT key, value FROM settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			continue
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/guest-lock-manager/backend/internal/api/middleware"
+	"github.com/guest-lock-manager/backend/internal/calendar"
+	"github.com/guest-lock-manager/backend/internal/pin"
 	"github.com/guest-lock-manager/backend/internal/storage"
 	"github.com/guest-lock-manager/backend/internal/websocket"
 )
@@ -90,7 +95,7 @@ func ListStaticPins(db *storage.DB) http.HandlerFunc {
 }
 
 // CreateStaticPin creates a new static PIN.
-func CreateStaticPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
+func CreateStaticPin(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -111,6 +116,10 @@ func CreateStaticPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 			middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, "Name and PIN code are required")
 			return
 		}
+		if err := validateStaticPinCode(ctx, db, syncService, req.PinCode, ""); err != nil {
+			writeStaticPinCodeError(w, err)
+			return
+		}
 		if req.SlotNumber <= 0 {
 			req.SlotNumber = 1
 		}
@@ -236,7 +245,7 @@ func GetStaticPin(db *storage.DB) http.HandlerFunc {
 }
 
 // UpdateStaticPin updates a static PIN.
-func UpdateStaticPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
+func UpdateStaticPin(db *storage.DB, hub *websocket.Hub, syncService *calendar.SyncService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
 		ctx := r.Context()
@@ -265,6 +274,13 @@ func UpdateStaticPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 			return
 		}
 
+		if req.PinCode != nil {
+			if err := validateStaticPinCode(ctx, db, syncService, *req.PinCode, id); err != nil {
+				writeStaticPinCodeError(w, err)
+				return
+			}
+		}
+
 		// Check for duplicate name if name is being updated (exclude current PIN)
 		if req.Name != nil && *req.Name != "" {
 			var existingCount int
@@ -386,3 +402,34 @@ func DeleteStaticPin(db *storage.DB, hub *websocket.Hub) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// validateStaticPinCode checks a static PIN code against the PIN policy and
+// the other PINs on the locks. Without a sync service only the code itself is
+// checked, against the stored PIN settings.
+func validateStaticPinCode(ctx context.Context, db *storage.DB, syncService *calendar.SyncService, code, excludeID string) error {
+	if syncService == nil {
+		settings, err := loadSettings(ctx, db)
+		if err != nil {
+			return err
+		}
+		minPIN, maxPIN, _ := pin.ParseLengths(settings[pin.SettingMinLength], settings[pin.SettingMaxLength])
+		policy, err := pin.ParsePolicy(settings)
+		if err != nil {
+			policy = pin.DefaultPolicy()
+		}
+		if err := pin.NewGenerator(minPIN, maxPIN).WithPolicy(policy).ValidatePIN(code); err != nil {
+			return fmt.Errorf("%w: %v", calendar.ErrInvalidPIN, err)
+		}
+		return nil
+	}
+	return syncService.ValidateStaticPIN(ctx, code, excludeID)
+}
+
+// writeStaticPinCodeError writes the response for a failed validateStaticPinCode.
+func writeStaticPinCodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, calendar.ErrInvalidPIN) {
+		middleware.WriteError(w, http.StatusBadRequest, middleware.ErrValidation, err.Error())
+		return
+	}
+	middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to validate PIN code")
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/guest-lock-manager/backend/internal/api/middleware"
+	"github.com/guest-lock-manager/backend/internal/calendar"
+	"github.com/guest-lock-manager/backend/internal/storage"
+)
+
+// WebhookSignatureHeader carries the hex HMAC-SHA256 of the request body,
+// keyed with the calendar's webhook secret. A "sha256=" prefix is accepted.
+const WebhookSignatureHeader = "X-Signature-256"
+
+// maxWebhookBodyBytes is the largest webhook body that is read and verified.
+const maxWebhookBodyBytes = 1 << 20
+
+// CalendarWebhookResponse is returned when a calendar's webhook secret is
+// created. The secret is only ever returned here.
+type CalendarWebhookResponse struct {
+	URL             string `json:"url"`
+	Secret          string `json:"secret"`
+	SignatureHeader string `json:"signature_header"`
+}
+
+// webhookPath is the path a channel manager calls for a calendar.
+func webhookPath(id string) string {
+	return "/api/hooks/calendars/" + id
+}
+
+// CreateCalendarWebhook enables a calendar's webhook with a new random
+// secret, replacing any previous one.
+func CreateCalendarWebhook(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		ctx := r.Context()
+
+		var exists bool
+		db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM calendar_subscriptions WHERE id = ?)", id).Scan(&exists)
+		if !exists {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
+			return
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to generate webhook secret")
+			return
+		}
+		secret := hex.EncodeToString(key)
+
+		box, err := db.Secrets()
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to store webhook secret")
+			return
+		}
+		sealed, err := box.Encrypt([]byte(secret))
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to store webhook secret")
+			return
+		}
+
+		_, err = db.ExecContext(ctx, `
+			UPDATE calendar_subscriptions SET webhook_secret = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, sealed, id)
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to store webhook secret")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CalendarWebhookResponse{
+			URL:             webhookPath(id),
+			Secret:          secret,
+			SignatureHeader: WebhookSignatureHeader,
+		})
+	}
+}
+
+// DeleteCalendarWebhook disables a calendar's webhook.
+func DeleteCalendarWebhook(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		result, err := db.ExecContext(r.Context(), `
+			UPDATE calendar_subscriptions SET webhook_secret = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, id)
+		if err != nil {
+			middleware.WriteError(w, http.StatusInternalServerError, middleware.ErrInternalError, "Failed to disable webhook")
+			return
+		}
+
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			middleware.WriteError(w, http.StatusNotFound, middleware.ErrNotFound, "Calendar not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CalendarWebhook is called by a channel manager when a reservation changes.
+// A correctly signed request queues a debounced sync of the calendar. Unknown
+// calendars, calendars without a webhook and bad signatures are all rejected
+// the same way, so the endpoint does not reveal which calendars exist.
+func CalendarWebhook(db *storage.DB, scheduler *calendar.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		if scheduler == nil {
+			middleware.WriteError(w, http.StatusServiceUnavailable, middleware.ErrInternalError, "Calendar sync is not available")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+		if err != nil {
+			middleware.WriteError(w, http.StatusRequestEntityTooLarge, middleware.ErrBadRequest, "Webhook body is too large")
+			return
+		}
+
+		var sealed *string
+		var enabled bool
+		db.QueryRowContext(r.Context(), "SELECT webhook_secret, enabled FROM calendar_subscriptions WHERE id = ?", id).Scan(&sealed, &enabled)
+		if sealed == nil || !validWebhookSignature(db, *sealed, body, r.Header.Get(WebhookSignatureHeader)) {
+			middleware.WriteError(w, http.StatusUnauthorized, middleware.ErrUnauthorized, "Invalid webhook signature")
+			return
+		}
+
+		if !enabled {
+			middleware.WriteError(w, http.StatusConflict, middleware.ErrConflict, "Calendar is disabled")
+			return
+		}
+
+		status := "queued"
+		if !scheduler.RequestSync(id) {
+			status = "already_queued"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": status})
+	}
+}
+
+// validWebhookSignature reports whether signature is the HMAC of body keyed
+// with the calendar's sealed webhook secret.
+func validWebhookSignature(db *storage.DB, sealed string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if err != nil || len(got) != sha256.Size {
+		return false
+	}
+
+	box, err := db.Secrets()
+	if err != nil {
+		log.Printf("Failed to load secret key for webhook: %v", err)
+		return false
+	}
+	secret, err := box.Decrypt(sealed)
+	if err != nil {
+		log.Printf("Failed to decrypt webhook secret: %v", err)
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/guest-lock-manager/backend/internal/api/handlers"
 	"github.com/guest-lock-manager/backend/internal/api/middleware"
 	"github.com/guest-lock-manager/backend/internal/calendar"
+	"github.com/guest-lock-manager/backend/internal/lock"
 	"github.com/guest-lock-manager/backend/internal/storage"
 	"github.com/guest-lock-manager/backend/internal/websocket"
 )
@@ -15,17 +16,19 @@ import (
 // NewRouter creates and configures the HTTP router with all API routes.
 // This is a convenience wrapper that creates a router without sync services.
 func NewRouter(db *storage.DB, hub *websocket.Hub, staticDir string) *mux.Router {
-	return NewRouterWithServices(db, hub, staticDir, nil, nil)
+	return NewRouterWithServices(db, hub, staticDir, nil, nil, nil)
 }
 
 // NewRouterWithServices creates and configures the HTTP router with all API routes
-// and injects the sync service and scheduler for calendar sync operations.
+// and injects the sync service and scheduler for calendar sync operations, and
+// the lock manager for PIN changes that must reach the locks at once.
 func NewRouterWithServices(
 	db *storage.DB,
 	hub *websocket.Hub,
 	staticDir string,
 	syncService *calendar.SyncService,
 	calendarScheduler *calendar.Scheduler,
+	lockManager *lock.Manager,
 ) *mux.Router {
 	r := mux.NewRouter()
 
@@ -46,12 +49,23 @@ func NewRouterWithServices(
 	// Calendar endpoints
 	api.HandleFunc("/calendars", handlers.ListCalendars(db)).Methods("GET")
 	api.HandleFunc("/calendars", handlers.CreateCalendar(db, calendarScheduler)).Methods("POST")
+	api.HandleFunc("/calendars/preview", handlers.PreviewCalendar(db, syncService)).Methods("POST")
+	api.HandleFunc("/calendars/sync", handlers.SyncAllCalendars(hub, syncService)).Methods("POST")
 	api.HandleFunc("/calendars/{id}", handlers.GetCalendar(db)).Methods("GET")
 	api.HandleFunc("/calendars/{id}", handlers.UpdateCalendar(db, calendarScheduler)).Methods("PUT")
 	api.HandleFunc("/calendars/{id}", handlers.DeleteCalendar(db, calendarScheduler)).Methods("DELETE")
 	api.HandleFunc("/calendars/{id}/sync", handlers.SyncCalendar(db, hub, syncService)).Methods("POST")
+	api.HandleFunc("/calendars/{id}/ics", handlers.UploadCalendarICS(db, hub, syncService)).Methods("PUT")
+	api.HandleFunc("/calendars/{id}/anomaly/confirm", handlers.ConfirmCalendarAnomaly(db, hub, syncService)).Methods("POST")
+	api.HandleFunc("/calendars/{id}/syncs", handlers.ListCalendarSyncs(db)).Methods("GET")
+	api.HandleFunc("/calendars/{id}/syncs/diff", handlers.DiffCalendarSyncs(db, syncService)).Methods("GET")
 	api.HandleFunc("/calendars/{id}/locks", handlers.GetCalendarLocks(db)).Methods("GET")
 	api.HandleFunc("/calendars/{id}/locks", handlers.UpdateCalendarLocks(db)).Methods("PUT")
+	api.HandleFunc("/calendars/{id}/webhook", handlers.CreateCalendarWebhook(db)).Methods("POST")
+	api.HandleFunc("/calendars/{id}/webhook", handlers.DeleteCalendarWebhook(db)).Methods("DELETE")
+
+	// Inbound webhooks from channel managers, verified by HMAC signature
+	api.HandleFunc("/hooks/calendars/{id}", handlers.CalendarWebhook(db, calendarScheduler)).Methods("POST")
 
 	// Lock endpoints
 	api.HandleFunc("/locks", handlers.ListLocks(db)).Methods("GET")
@@ -64,19 +78,19 @@ func NewRouterWithServices(
 	// Guest PIN endpoints
 	api.HandleFunc("/guest-pins", handlers.ListGuestPins(db)).Methods("GET")
 	api.HandleFunc("/guest-pins/{id}", handlers.GetGuestPin(db)).Methods("GET")
-	api.HandleFunc("/guest-pins/{id}", handlers.UpdateGuestPin(db, hub)).Methods("PATCH")
-	api.HandleFunc("/guest-pins/{id}/regenerate", handlers.RegenerateGuestPin(db, hub)).Methods("POST")
+	api.HandleFunc("/guest-pins/{id}", handlers.UpdateGuestPin(db, hub, syncService)).Methods("PATCH")
+	api.HandleFunc("/guest-pins/{id}/regenerate", handlers.RegenerateGuestPin(db, hub, syncService, lockManager)).Methods("POST")
 
 	// Static PIN endpoints
 	api.HandleFunc("/static-pins", handlers.ListStaticPins(db)).Methods("GET")
-	api.HandleFunc("/static-pins", handlers.CreateStaticPin(db, hub)).Methods("POST")
+	api.HandleFunc("/static-pins", handlers.CreateStaticPin(db, hub, syncService)).Methods("POST")
 	api.HandleFunc("/static-pins/{id}", handlers.GetStaticPin(db)).Methods("GET")
-	api.HandleFunc("/static-pins/{id}", handlers.UpdateStaticPin(db, hub)).Methods("PUT")
+	api.HandleFunc("/static-pins/{id}", handlers.UpdateStaticPin(db, hub, syncService)).Methods("PUT")
 	api.HandleFunc("/static-pins/{id}", handlers.DeleteStaticPin(db, hub)).Methods("DELETE")
 
 	// Settings endpoints
 	api.HandleFunc("/settings", handlers.GetSettings(db)).Methods("GET")
-	api.HandleFunc("/settings", handlers.UpdateSettings(db)).Methods("PUT")
+	api.HandleFunc("/settings", handlers.UpdateSettings(db, syncService)).Methods("PUT")
 
 	// Serve static frontend files
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir(staticDir)))
@@ -0,0 +1,200 @@
+package calendar
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// Skip reasons reported in CalendarSyncResult.SkippedByReason.
+const (
+	SkipReasonBlocked = "blocked" // Owner block or unavailable dates, not a guest stay
+)
+
+// SourceAdapter interprets events from one booking platform's calendar export.
+type SourceAdapter interface {
+	// Platform returns the platform identifier (see models.Platform*).
+	Platform() string
+
+	// Classify reports whether the event is a guest reservation. For
+	// reservations it fills the structured reservation fields on the event;
+	// otherwise it returns the skip reason.
+	Classify(event *models.CalendarEvent) (reservation bool, skipReason string)
+}
+
+// AdapterFor returns the adapter for a calendar's platform, falling back to
+// the generic adapter for unknown values.
+func AdapterFor(platform string) SourceAdapter {
+	switch platform {
+	case models.PlatformAirbnb:
+		return airbnbAdapter{}
+	case models.PlatformVRBO:
+		return vrboAdapter{}
+	case models.PlatformBookingCom:
+		return bookingComAdapter{}
+	default:
+		return genericAdapter{}
+	}
+}
+
+var (
+	phoneLast4Pattern     = regexp.MustCompile(`(?i)last 4 digits\)?:\s*(\d{4})`)
+	urlPattern            = regexp.MustCompile(`https?://[^\s<>"]+`)
+	airbnbCodePattern     = regexp.MustCompile(`\b(HM[A-Z0-9]{6,12})\b`)
+	trailingCodePattern   = regexp.MustCompile(`\s*\(([A-Z0-9]{6,})\)\s*$`)
+	digitPattern          = regexp.MustCompile(`\d`)
+	airbnbBlockSummaries  = []string{"airbnb (not available)", "not available", "blocked"}
+	vrboBlockSummaries    = []string{"blocked", "not available", "unavailable"}
+	bookingBlockSummaries = []string{"blocked", "not available", "unavailable"}
+)
+
+// genericAdapter treats every event as a reservation and extracts whatever
+// labelled fields are present in the description.
+type genericAdapter struct{}
+
+func (genericAdapter) Platform() string { return models.PlatformGeneric }
+
+func (genericAdapter) Classify(event *models.CalendarEvent) (bool, string) {
+	extractCommonFields(event)
+	return true, ""
+}
+
+// airbnbAdapter handles Airbnb exports: reservations are "Reserved" with a
+// reservation URL and phone last 4 in the description; blocked dates are
+// "Airbnb (Not available)".
+type airbnbAdapter struct{}
+
+func (airbnbAdapter) Platform() string { return models.PlatformAirbnb }
+
+func (airbnbAdapter) Classify(event *models.CalendarEvent) (bool, string) {
+	if summaryIn(event.Summary, airbnbBlockSummaries) {
+		return false, SkipReasonBlocked
+	}
+
+	extractCommonFields(event)
+
+	if event.ReservationCode == "" {
+		if m := airbnbCodePattern.FindStringSubmatch(event.ReservationURL + " " + event.Description); len(m) > 1 {
+			event.ReservationCode = m[1]
+		}
+	}
+
+	// Older exports use "Guest Name (HMXXXXXXXX)" instead of "Reserved"
+	if event.GuestName == "" && !strings.EqualFold(strings.TrimSpace(event.Summary), "reserved") {
+		name := strings.TrimSpace(trailingCodePattern.ReplaceAllString(event.Summary, ""))
+		if name != "" {
+			event.GuestName = name
+		}
+	}
+
+	return true, ""
+}
+
+// vrboAdapter handles VRBO exports: reservations are "Reserved - Guest Name"
+// and owner blocks are "Blocked".
+type vrboAdapter struct{}
+
+func (vrboAdapter) Platform() string { return models.PlatformVRBO }
+
+func (vrboAdapter) Classify(event *models.CalendarEvent) (bool, string) {
+	if summaryIn(event.Summary, vrboBlockSummaries) {
+		return false, SkipReasonBlocked
+	}
+
+	extractCommonFields(event)
+
+	if event.GuestName == "" {
+		summary := strings.TrimSpace(event.Summary)
+		if i := strings.Index(summary, " - "); i != -1 && strings.EqualFold(strings.TrimSpace(summary[:i]), "reserved") {
+			event.GuestName = strings.TrimSpace(summary[i+3:])
+		}
+	}
+
+	return true, ""
+}
+
+// bookingComAdapter handles Booking.com exports. Booking.com labels booked
+// nights "CLOSED - Not available", so only bare block summaries are skipped.
+type bookingComAdapter struct{}
+
+func (bookingComAdapter) Platform() string { return models.PlatformBookingCom }
+
+func (bookingComAdapter) Classify(event *models.CalendarEvent) (bool, string) {
+	if summaryIn(event.Summary, bookingBlockSummaries) {
+		return false, SkipReasonBlocked
+	}
+
+	extractCommonFields(event)
+	return true, ""
+}
+
+// extractCommonFields fills reservation fields from "Label: value" lines in
+// the description, leaving fields that are already set untouched.
+func extractCommonFields(event *models.CalendarEvent) {
+	desc := event.Description
+
+	if event.ReservationCode == "" {
+		event.ReservationCode = labelValue(desc, "reservation code", "reservation id", "reservation number",
+			"confirmation code", "confirmation number", "booking number", "booking id")
+	}
+
+	if event.GuestName == "" {
+		event.GuestName = labelValue(desc, "guest name", "guest", "name", "booker name")
+	}
+
+	if event.PhoneLast4 == "" {
+		if m := phoneLast4Pattern.FindStringSubmatch(desc); len(m) > 1 {
+			event.PhoneLast4 = m[1]
+		} else if phone := labelValue(desc, "phone", "phone number", "mobile", "telephone"); phone != "" {
+			event.PhoneLast4 = lastFourDigits(phone)
+		}
+	}
+
+	if event.ReservationURL == "" {
+		if url := labelValue(desc, "reservation url", "booking url"); urlPattern.MatchString(url) {
+			event.ReservationURL = urlPattern.FindString(url)
+		} else {
+			event.ReservationURL = urlPattern.FindString(desc)
+		}
+	}
+}
+
+// labelValue returns the value of the first "Label: value" line whose label
+// matches one of labels (case-insensitive).
+func labelValue(text string, labels ...string) string {
+	for _, line := range strings.Split(text, "\n") {
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			continue
+		}
+		label := strings.ToLower(strings.TrimSpace(line[:i]))
+		for _, want := range labels {
+			if label == want {
+				return strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return ""
+}
+
+// lastFourDigits returns the last four digits of a phone number, or an empty
+// string if the value is too short to be one.
+func lastFourDigits(phone string) string {
+	digits := strings.Join(digitPattern.FindAllString(phone, -1), "")
+	if len(digits) < 7 {
+		return ""
+	}
+	return digits[len(digits)-4:]
+}
+
+// summaryIn reports whether summary matches one of the given lower-case values.
+func summaryIn(summary string, values []string) bool {
+	s := strings.ToLower(strings.TrimSpace(summary))
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// minAnomalyBaseline is the smallest baseline a percentage drop is judged
+// against; below it a single cancellation would look like an outage.
+const minAnomalyBaseline = 2
+
+// AnomalyError is returned by SyncCalendar when a feed looks broken rather
+// than genuinely emptied. Creates and updates were applied; removals were held
+// until the anomaly is confirmed with ConfirmAnomaly.
+type AnomalyError struct {
+	CalendarID   string
+	Reason       string
+	HeldRemovals int
+}
+
+func (e *AnomalyError) Error() string {
+	return fmt.Sprintf("feed anomaly: %s; %d PIN removals held until confirmed", e.Reason, e.HeldRemovals)
+}
+
+// SyncErrorCode identifies anomalies in calendar.sync_error events.
+func (e *AnomalyError) SyncErrorCode() string {
+	return "sync_anomaly"
+}
+
+// checkAnomaly compares a plan against the calendar's last accepted sync. If
+// the feed has lost too much, the plan's removals are held and the reason is
+// recorded on the plan.
+func (s *SyncService) checkAnomaly(ctx context.Context, cal *models.CalendarSubscription, plan *SyncPlan) error {
+	if cal.ID == "" {
+		return nil
+	}
+
+	baseline, err := s.calendarRepo.GetSyncBaseline(ctx, cal.ID)
+	if err != nil {
+		return err
+	}
+
+	if reason := detectAnomaly(cal.AnomalyThresholdPct, baseline, plan, time.Now().UTC()); reason != "" {
+		plan.Anomaly = reason
+		plan.HeldRemovals = plan.holdRemovals()
+	}
+	return nil
+}
+
+// detectAnomaly returns why the plan's feed looks broken compared to baseline,
+// or "" if it looks normal. It checks for an empty feed, a drop in the total
+// event count, and upcoming reservations that vanished; stays that have ended
+// since the baseline or were cancelled in the feed are not counted as missing.
+func detectAnomaly(thresholdPct int, baseline *models.SyncBaseline, plan *SyncPlan, now time.Time) string {
+	if thresholdPct <= 0 || baseline == nil {
+		return ""
+	}
+
+	found := plan.Summary.EventsFound
+	if found == 0 && baseline.EventCount > 0 {
+		return fmt.Sprintf("feed returned no events; the last good sync had %d", baseline.EventCount)
+	}
+
+	if baseline.EventCount >= minAnomalyBaseline {
+		drop := (baseline.EventCount - found) * 100 / baseline.EventCount
+		if drop >= thresholdPct {
+			return fmt.Sprintf("event count fell from %d to %d (%d%%)", baseline.EventCount, found, drop)
+		}
+	}
+
+	upcoming, missing := 0, 0
+	for key, until := range baseline.Reservations {
+		if !until.After(now) {
+			continue
+		}
+		if _, ok := plan.cancelled[key]; ok {
+			continue // Explicit cancellations are not an outage
+		}
+		upcoming++
+		if _, ok := plan.reservations[key]; !ok {
+			missing++
+		}
+	}
+	if upcoming >= minAnomalyBaseline {
+		drop := missing * 100 / upcoming
+		if drop >= thresholdPct {
+			return fmt.Sprintf("%d of %d upcoming reservations disappeared (%d%%)", missing, upcoming, drop)
+		}
+	}
+
+	return ""
+}
+
+// holdRemovals drops the plan's expirations and missing-event tracking and
+// returns the number of expirations held.
+func (p *SyncPlan) holdRemovals() int {
+	held := len(p.Expire)
+	p.Expire = []PlannedPIN{}
+	p.PendingRemoval = []PlannedPIN{}
+	p.Summary.PINsRemoved = 0
+	p.Summary.PendingRemoval = nil
+	return held
+}
+
+// baseline returns the plan's feed as a baseline for later syncs.
+func (p *SyncPlan) baseline() models.SyncBaseline {
+	return models.SyncBaseline{
+		EventCount:   p.Summary.EventsFound,
+		Reservations: p.reservations,
+	}
+}
@@ -0,0 +1,90 @@
+package calendar
+
+import "time"
+
+// Adaptive sync cadence. A calendar's sync_interval_min is its normal cadence;
+// it is shortened ahead of check-ins and after syncs that changed PINs, and
+// lengthened on quiet days and after failed syncs.
+const (
+	minSyncInterval    = 5 * time.Minute // Fastest cadence
+	checkinSoonWindow  = 6 * time.Hour   // Sync at the fastest cadence this close to a check-in
+	checkinDayWindow   = 24 * time.Hour  // Sync twice as often this close to a check-in
+	recentChangeWindow = time.Hour       // Sync at the fastest cadence this long after PINs changed
+	quietWindow        = 72 * time.Hour  // No check-in this soon counts as quiet
+	quietFactor        = 3               // Quiet calendars sync this many times less often,
+	maxQuietInterval   = 2 * time.Hour   // but no less often than this unless their interval says so
+	maxRetryBackoff    = 6 * time.Hour   // Longest wait after repeated failures
+)
+
+// syncCadence is what the next sync of a calendar depends on.
+type syncCadence struct {
+	interval    time.Duration // Configured interval
+	nextCheckin *time.Time    // Start of the next upcoming stay, if any
+	lastChange  time.Time     // Last sync that created, updated or removed PINs
+	failures    int           // Consecutive failed syncs
+}
+
+// delay returns how long after now the calendar should next sync.
+func (c syncCadence) delay(now time.Time) time.Duration {
+	interval := c.interval
+	checkinIn := time.Duration(-1)
+	if c.nextCheckin != nil && c.nextCheckin.After(now) {
+		checkinIn = c.nextCheckin.Sub(now)
+	}
+
+	switch {
+	case checkinIn >= 0 && checkinIn <= checkinSoonWindow,
+		!c.lastChange.IsZero() && now.Sub(c.lastChange) < recentChangeWindow:
+		interval = minSyncInterval
+	case checkinIn >= 0 && checkinIn <= checkinDayWindow:
+		interval /= 2
+	case checkinIn < 0 || checkinIn > quietWindow:
+		quiet := interval * quietFactor
+		if quiet > maxQuietInterval {
+			quiet = maxQuietInterval
+		}
+		if quiet > interval {
+			interval = quiet
+		}
+	}
+	if interval < minSyncInterval {
+		interval = minSyncInterval
+	}
+
+	if c.failures > 0 {
+		// 5m, 10m, 20m, ... capped, and never slower than the normal cadence
+		// while a check-in is a day or less away
+		backoff := maxRetryBackoff
+		if c.failures < 16 {
+			backoff = minSyncInterval << (c.failures - 1)
+		}
+		limit := maxRetryBackoff
+		if checkinIn >= 0 && checkinIn <= checkinDayWindow {
+			limit = c.interval
+		}
+		if backoff > limit {
+			backoff = limit
+		}
+		if backoff < minSyncInterval {
+			backoff = minSyncInterval
+		}
+		return backoff
+	}
+
+	// Wake up when the next, faster window before a check-in begins
+	var untilFaster time.Duration
+	switch {
+	case checkinIn > checkinDayWindow:
+		untilFaster = checkinIn - checkinDayWindow
+	case checkinIn > checkinSoonWindow:
+		untilFaster = checkinIn - checkinSoonWindow
+	}
+	if untilFaster > 0 && interval > untilFaster {
+		interval = untilFaster
+		if interval < minSyncInterval {
+			interval = minSyncInterval
+		}
+	}
+
+	return interval
+}
@@ -0,0 +1,465 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// queryLookback is how far before now sources queried by time range reach,
+// so stays already in progress are included.
+const queryLookback = 31 * 24 * time.Hour
+
+// errSyncTokenInvalid is returned when the server no longer accepts a
+// sync-token and the collection has to be queried in full.
+var errSyncTokenInvalid = errors.New("caldav sync-token rejected")
+
+// fetchCalDAV returns the feed of a CalDAV calendar: its stored objects
+// updated through a sync-collection REPORT, or a time-range calendar-query
+// when it has no usable sync-token. The objects are joined into one feed so
+// the rest of the sync treats it like any other iCal feed. Unsaved calendars
+// are always queried in full and nothing is stored.
+func (s *SyncService) fetchCalDAV(ctx context.Context, cal *models.CalendarSubscription) (*Feed, error) {
+	client, err := s.parser.clientFor(cal.Fetch)
+	if err != nil {
+		return nil, fmt.Errorf("fetching calendar: %w", err)
+	}
+	dav := &caldavClient{client: client, url: cal.URL, opts: cal.Fetch}
+	persist := cal.ID != ""
+
+	token := ""
+	if persist {
+		if token, err = s.caldavRepo.GetSyncToken(ctx, cal.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	var objects []models.CalDAVObject
+	if token != "" {
+		changed, deleted, next, err := dav.syncCollection(token)
+		switch {
+		case err == nil:
+			if err := s.caldavRepo.SaveChanges(ctx, cal.ID, next, false, changed, deleted); err != nil {
+				return nil, err
+			}
+			if objects, err = s.caldavRepo.ListObjects(ctx, cal.ID); err != nil {
+				return nil, err
+			}
+		case errors.Is(err, errSyncTokenInvalid):
+			log.Printf("CalDAV sync-token for calendar %s rejected, querying in full", cal.ID)
+			token = ""
+		default:
+			return dav.feed(nil), err
+		}
+	}
+
+	if token == "" {
+		// Read the token before querying, so changes made in between are
+		// picked up by the next incremental sync
+		next, err := dav.syncToken()
+		if err != nil {
+			log.Printf("CalDAV sync-token unavailable for calendar %s: %v", cal.ID, err)
+		}
+
+		now := time.Now().UTC()
+		objects, err = dav.query(now.Add(-queryLookback), now.Add(s.parser.recurrenceHorizon))
+		if err != nil {
+			return dav.feed(nil), err
+		}
+		if persist {
+			if err := s.caldavRepo.SaveChanges(ctx, cal.ID, next, true, objects, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return dav.feed(objects), nil
+}
+
+// caldavClient makes the WebDAV requests of a CalDAV calendar.
+type caldavClient struct {
+	client     *http.Client
+	url        string
+	opts       models.FetchOptions
+	lastStatus int
+}
+
+// feed joins objects, ordered by href, into one iCalendar feed.
+func (c *caldavClient) feed(objects []models.CalDAVObject) *Feed {
+	sorted := make([]models.CalDAVObject, len(objects))
+	copy(sorted, objects)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Href < sorted[j].Href })
+
+	var body bytes.Buffer
+	for _, obj := range sorted {
+		body.Write(bytes.TrimSpace(obj.Data))
+		body.WriteString("\r\n")
+	}
+
+	feed := feedFromBody(body.Bytes())
+	feed.StatusCode = c.lastStatus
+	return feed
+}
+
+// syncToken returns the collection's current sync-token, or "" if the server
+// does not support sync-collection.
+func (c *caldavClient) syncToken() (string, error) {
+	ms, err := c.multistatus("PROPFIND", "0", `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:sync-token/></D:prop></D:propfind>`)
+	if err != nil {
+		return "", err
+	}
+	for _, resp := range ms.Responses {
+		for _, ps := range resp.Propstats {
+			if statusOK(ps.Status) && ps.Prop.SyncToken != "" {
+				return ps.Prop.SyncToken, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// query returns the collection's objects with events between start and end.
+func (c *caldavClient) query(start, end time.Time) ([]models.CalDAVObject, error) {
+	ms, err := c.multistatus("REPORT", "1", fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`, start.Format("20060102T150405Z"), end.Format("20060102T150405Z")))
+	if err != nil {
+		return nil, err
+	}
+
+	objects, _ := ms.objects()
+	return objects, nil
+}
+
+// syncCollection returns the objects changed and the hrefs deleted since
+// token, and the collection's new sync-token. Changed objects reported
+// without their data are fetched with a calendar-multiget.
+func (c *caldavClient) syncCollection(token string) ([]models.CalDAVObject, []string, string, error) {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(token))
+
+	ms, err := c.multistatus("REPORT", "", `<?xml version="1.0" encoding="utf-8"?>
+<D:sync-collection xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:sync-token>`+escaped.String()+`</D:sync-token>
+  <D:sync-level>1</D:sync-level>
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>
+</D:sync-collection>`)
+	var statusErr *caldavStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 {
+		// 403 or 409 with a valid-sync-token precondition, or no support at all
+		return nil, nil, "", errSyncTokenInvalid
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	changed, missing := ms.objects()
+	if len(missing) > 0 {
+		fetched, err := c.multiget(missing)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		changed = append(changed, fetched...)
+	}
+
+	return changed, ms.deleted(), ms.SyncToken, nil
+}
+
+// multiget fetches the objects at hrefs.
+func (c *caldavClient) multiget(hrefs []string) ([]models.CalDAVObject, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>`)
+	for _, href := range hrefs {
+		body.WriteString("\n  <D:href>")
+		xml.EscapeText(&body, []byte(href))
+		body.WriteString("</D:href>")
+	}
+	body.WriteString("\n</C:calendar-multiget>")
+
+	ms, err := c.multistatus("REPORT", "1", body.String())
+	if err != nil {
+		return nil, err
+	}
+
+	objects, _ := ms.objects()
+	return objects, nil
+}
+
+// caldavStatusError is a WebDAV request that did not return 207 Multi-Status.
+type caldavStatusError struct {
+	Method     string
+	StatusCode int
+}
+
+func (e *caldavStatusError) Error() string {
+	return fmt.Sprintf("caldav %s returned status %d", e.Method, e.StatusCode)
+}
+
+// multistatus sends a WebDAV request and decodes its 207 response.
+func (c *caldavClient) multistatus(method, depth, body string) (*davMultistatus, error) {
+	resp, err := c.do(method, depth, []byte(body))
+	if err != nil {
+		return nil, fmt.Errorf("fetching calendar: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.lastStatus = resp.StatusCode
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, &caldavStatusError{Method: method, StatusCode: resp.StatusCode}
+	}
+
+	data, err := readLimited(resp.Body, c.opts.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms davMultistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("decoding caldav %s response: %w", method, err)
+	}
+	return &ms, nil
+}
+
+// do sends a WebDAV request. Basic credentials are not sent up front but in
+// answer to the server's Basic or Digest challenge.
+func (c *caldavClient) do(method, depth string, body []byte) (*http.Response, error) {
+	opts := c.opts
+	challenged := opts.Auth != nil && opts.Auth.Type == models.FeedAuthBasic
+	if challenged {
+		opts.Auth = nil
+	}
+
+	send := func(authorize func(*http.Request) error) (*http.Response, error) {
+		req, err := newFeedRequest(method, c.url, body, opts)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+		if authorize != nil {
+			if err := authorize(req); err != nil {
+				return nil, err
+			}
+		}
+		return c.client.Do(req)
+	}
+
+	resp, err := send(nil)
+	if err != nil || !challenged || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenges := resp.Header.Values("WWW-Authenticate")
+	resp.Body.Close()
+	return send(func(req *http.Request) error {
+		return answerChallenge(req, c.opts.Auth, challenges)
+	})
+}
+
+// answerChallenge authorizes req with auth's username and password, using
+// Digest if the server offers it and Basic otherwise.
+func answerChallenge(req *http.Request, auth *models.FeedAuth, challenges []string) error {
+	for _, challenge := range challenges {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+		if strings.EqualFold(scheme, "Digest") {
+			header, err := digestAuthorization(req, auth, parseAuthParams(params))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", header)
+			return nil
+		}
+	}
+
+	req.SetBasicAuth(auth.Username, auth.Secret)
+	return nil
+}
+
+// digestAuthorization returns the Authorization header answering a Digest
+// challenge (RFC 7616) with qop "auth" or without qop.
+func digestAuthorization(req *http.Request, auth *models.FeedAuth, params map[string]string) (string, error) {
+	var newHash func() hash.Hash
+	algorithm := params["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	realm, nonce := params["realm"], params["nonce"]
+	uri := req.URL.RequestURI()
+	ha1 := h(auth.Username + ":" + realm + ":" + auth.Secret)
+	ha2 := h(req.Method + ":" + uri)
+
+	fields := []string{
+		fmt.Sprintf("username=%q", auth.Username),
+		fmt.Sprintf("realm=%q", realm),
+		fmt.Sprintf("nonce=%q", nonce),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if algorithm != "" {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+
+	qopAuth := false
+	for _, qop := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			qopAuth = true
+		}
+	}
+	if qopAuth {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("generating cnonce: %w", err)
+		}
+		cnonce, nc := hex.EncodeToString(buf), "00000001"
+		response := h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		fields = append(fields, "qop=auth", "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce), fmt.Sprintf("response=%q", response))
+	} else {
+		fields = append(fields, fmt.Sprintf("response=%q", h(ha1+":"+nonce+":"+ha2)))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf("opaque=%q", opaque))
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// parseAuthParams parses the comma separated name=value pairs of a
+// WWW-Authenticate challenge; values may be quoted.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimSpace(rest)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			rest = rest[min(i+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		params[name] = strings.TrimSpace(value)
+
+		_, s, _ = strings.Cut(rest, ",")
+		s = strings.TrimSpace(s)
+	}
+	return params
+}
+
+// davMultistatus is a WebDAV 207 Multi-Status response body.
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+	SyncToken string        `xml:"DAV: sync-token"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Status    string        `xml:"DAV: status"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ETag         string `xml:"DAV: getetag"`
+	SyncToken    string `xml:"DAV: sync-token"`
+	CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
+
+// objects returns the calendar objects in the response, and the hrefs of
+// objects reported without their calendar data.
+func (ms *davMultistatus) objects() ([]models.CalDAVObject, []string) {
+	var objects []models.CalDAVObject
+	var missing []string
+	for _, resp := range ms.Responses {
+		if resp.Status != "" && !statusOK(resp.Status) {
+			continue
+		}
+		for _, ps := range resp.Propstats {
+			if !statusOK(ps.Status) {
+				continue
+			}
+			if strings.TrimSpace(ps.Prop.CalendarData) == "" {
+				missing = append(missing, resp.Href)
+				continue
+			}
+			objects = append(objects, models.CalDAVObject{
+				Href: resp.Href,
+				ETag: ps.Prop.ETag,
+				Data: []byte(ps.Prop.CalendarData),
+			})
+		}
+	}
+	return objects, missing
+}
+
+// deleted returns the hrefs a sync-collection response reports as removed.
+func (ms *davMultistatus) deleted() []string {
+	var hrefs []string
+	for _, resp := range ms.Responses {
+		if strings.Contains(resp.Status, " 404") {
+			hrefs = append(hrefs, resp.Href)
+		}
+	}
+	return hrefs
+}
+
+// statusOK reports whether a WebDAV status line such as "HTTP/1.1 200 OK"
+// is a 2xx status.
+func statusOK(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && strings.HasPrefix(fields[1], "2")
+}
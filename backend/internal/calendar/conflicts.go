@@ -0,0 +1,213 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/pin"
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// ErrInvalidPIN is returned when a custom or static PIN does not meet the PIN
+// settings or the PIN policy.
+var ErrInvalidPIN = errors.New("invalid PIN")
+
+// DefaultCodeReuseDays is how long a code stays unavailable to drawn PINs on
+// a lock after a guest PIN using it there ends.
+const DefaultCodeReuseDays = 30
+
+// SetCustomPIN gives a guest PIN a code chosen by the owner. The code is
+// checked against the PINs on the same locks; a custom code is never replaced,
+// so if it conflicts the PIN is set to conflict status and not programmed
+// until the conflict is resolved. The code must have the PIN length of the
+// PIN's calendar. The conflicts found are returned.
+func (s *SyncService) SetCustomPIN(ctx context.Context, id, code string) (*models.GuestPIN, []models.PINConflict, error) {
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
+
+	p, err := s.guestPINRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p == nil {
+		return nil, nil, ErrPINNotFound
+	}
+
+	_, gen, err := s.pinGenerator(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := gen.ValidatePIN(code); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPIN, err)
+	}
+
+	lockIDs, err := s.programmedLocks(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	found, err := s.checker().CheckConflicts(ctx, code, lockIDs, p.ValidFrom, p.ValidUntil, p.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.PINCode = code
+	p.CustomPIN = &code
+	p.GenerationMethod = models.GenerationMethodCustom
+	switch {
+	case len(found) > 0:
+		p.Status = models.PINStatusConflict
+	case p.Status == models.PINStatusConflict:
+		p.Status = statusAt(p, time.Now().UTC())
+	}
+	if err := s.guestPINRepo.Update(ctx, p); err != nil {
+		return nil, nil, err
+	}
+	if p.Status != models.PINStatusConflict {
+		if err := s.guestPINRepo.QueueLockSync(ctx, p.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	conflicts := make([]models.PINConflict, 0, len(found))
+	for _, c := range found {
+		conflicts = append(conflicts, conflictRecord(p, c))
+	}
+	return p, conflicts, nil
+}
+
+// ValidateStaticPIN checks the code of a static PIN, other than the one with
+// excludeID, against the global PIN length settings and the PIN policy. Static PINs are programmed on every
+// managed lock, so the code must not clash with any other static PIN or with
+// any guest PIN that is programmed.
+func (s *SyncService) ValidateStaticPIN(ctx context.Context, code, excludeID string) error {
+	policy := s.checker().Policy()
+	if err := s.defaultGenerator().ValidatePIN(code); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPIN, err)
+	}
+
+	static, err := s.staticRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range static {
+		if other.ID == excludeID {
+			continue
+		}
+		if reason := policy.Clash(code, other.PINCode); reason != "" {
+			return fmt.Errorf("%w: %v (static PIN %q)", ErrInvalidPIN, policy.ClashError(reason), other.Name)
+		}
+	}
+
+	guests, err := s.guestPINRepo.ListProgrammed(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range guests {
+		if reason := policy.Clash(code, other.PINCode); reason != "" {
+			name := other.EventUID
+			if other.EventSummary != nil {
+				name = *other.EventSummary
+			}
+			return fmt.Errorf("%w: %v (guest PIN %q)", ErrInvalidPIN, policy.ClashError(reason), name)
+		}
+	}
+
+	return nil
+}
+
+// programmedLocks returns the locks a saved PIN holds a slot on. Linked PINs
+// hold none.
+func (s *SyncService) programmedLocks(ctx context.Context, p *models.GuestPIN) ([]string, error) {
+	assignments, err := s.guestPINRepo.GetLockAssignments(ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lockIDs := make([]string, 0, len(assignments))
+	for _, a := range assignments {
+		if a.SyncStatus != models.LockSyncRemoved {
+			lockIDs = append(lockIDs, a.LockID)
+		}
+	}
+	return lockIDs, nil
+}
+
+// takenCodes returns the codes a PIN drawn for lockIDs must not use: those
+// clashing under the policy with codes in use or recently used on the locks
+// by PINs other than excludeID, or with those of other PINs in plan, which may
+// be nil, on the same locks. It returns nil when gen draws no codes.
+func (s *SyncService) takenCodes(ctx context.Context, plan *SyncPlan, gen *pin.Generator, lockIDs []string, excludeID string) (func(code string) bool, error) {
+	if !gen.Draws() {
+		return nil, nil
+	}
+
+	s.settingsMu.RLock()
+	reuseDays := s.codeReuseDays
+	s.settingsMu.RUnlock()
+
+	since := time.Now().UTC().AddDate(0, 0, -reuseDays)
+	used := make(map[string]bool)
+	for _, lockID := range lockIDs {
+		codes, err := s.guestPINRepo.UsedCodes(ctx, lockID, since, excludeID)
+		if err != nil {
+			return nil, err
+		}
+		for code := range codes {
+			used[code] = true
+		}
+	}
+
+	policy := s.checker().Policy()
+	return func(code string) bool {
+		for other := range used {
+			if policy.Clash(code, other) != "" {
+				return true
+			}
+		}
+		if plan == nil {
+			return false
+		}
+		for _, list := range [][]PlannedPIN{plan.Create, plan.Update} {
+			for i := range list {
+				p := &list[i]
+				// New PINs in the plan have no ID yet, so "" excludes none of them
+				self := excludeID != "" && p.GuestPINID == excludeID
+				if !self && policy.Clash(code, p.PINCode) != "" && sharedLock(lockIDs, p.lockIDs()) != "" {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// statusAt returns the status a PIN leaving conflict status has at now.
+func statusAt(p *models.GuestPIN, now time.Time) string {
+	if p.IsActive(now) {
+		return models.PINStatusActive
+	}
+	return models.PINStatusPending
+}
+
+// conflictRecord describes a conflict of p for sync results and broadcasts.
+func conflictRecord(p *models.GuestPIN, c pin.Conflict) models.PINConflict {
+	summary := ""
+	if p.EventSummary != nil {
+		summary = *p.EventSummary
+	}
+
+	return models.PINConflict{
+		GuestPINID:         p.ID,
+		EventSummary:       summary,
+		PINCode:            c.PINCode,
+		LockID:             c.LockID,
+		ConflictingType:    c.ConflictingType,
+		Reason:             c.Reason,
+		ConflictingPINID:   c.ConflictingPIN,
+		ConflictingSummary: c.EventSummary,
+		OverlapStart:       c.OverlapStart,
+		OverlapEnd:         c.OverlapEnd,
+	}
+}
@@ -0,0 +1,166 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// Cross-calendar de-duplication. A unit listed on several platforms often has
+// each booking mirrored into the other platforms' feeds by a channel manager.
+// When those calendars share a lock, the same stay would otherwise take one
+// slot per calendar. PINs of other calendars that share a lock and cover the
+// same dates are grouped as one stay: the real reservation is programmed and
+// the others are linked to it.
+
+// stayMatcher finds the PINs of other calendars that are the same stay as a
+// planned PIN, and decides which of them is the real reservation.
+type stayMatcher struct {
+	s          *SyncService
+	cal        *models.CalendarSubscription
+	lockIDs    []string
+	location   *time.Location
+	priorities map[string]int // Calendar ID -> priority, cached for the plan
+}
+
+func (s *SyncService) newStayMatcher(cal *models.CalendarSubscription, lockIDs []string, times propertyTimes) *stayMatcher {
+	return &stayMatcher{
+		s:          s,
+		cal:        cal,
+		lockIDs:    lockIDs,
+		location:   times.location,
+		priorities: map[string]int{cal.ID: cal.Priority},
+	}
+}
+
+// match returns the PIN of another calendar that p should be linked to, or
+// nil if p is the real reservation. In that case mirrors lists the PINs of
+// other calendars that should be linked to p.
+func (m *stayMatcher) match(ctx context.Context, p *models.GuestPIN) (primary *models.GuestPIN, mirrors []models.GuestPIN, err error) {
+	candidates, err := m.s.guestPINRepo.FindStays(ctx, m.cal.ID, m.lockIDs, p.ValidFrom, p.ValidUntil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range candidates {
+		other := &candidates[i]
+		if !m.sameDates(p, other) {
+			continue
+		}
+		priority, err := m.priority(ctx, other.CalendarID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if outranks(priority, other, m.cal.Priority, p) {
+			if primary == nil {
+				primary = other
+			}
+			continue
+		}
+		mirrors = append(mirrors, *other)
+	}
+
+	if primary != nil {
+		return primary, nil, nil
+	}
+	return nil, mirrors, nil
+}
+
+// sameDates reports whether two PINs start and end on the same days at the
+// property. Calendars may apply different check-in and check-out times, so
+// only the dates are compared.
+func (m *stayMatcher) sameDates(a, b *models.GuestPIN) bool {
+	const day = "2006-01-02"
+	return a.ValidFrom.In(m.location).Format(day) == b.ValidFrom.In(m.location).Format(day) &&
+		a.ValidUntil.In(m.location).Format(day) == b.ValidUntil.In(m.location).Format(day)
+}
+
+func (m *stayMatcher) priority(ctx context.Context, calendarID string) (int, error) {
+	if p, ok := m.priorities[calendarID]; ok {
+		return p, nil
+	}
+	cal, err := m.s.calendarRepo.GetByID(ctx, calendarID)
+	if err != nil {
+		return 0, fmt.Errorf("loading calendar priority: %w", err)
+	}
+	p := 0
+	if cal != nil {
+		p = cal.Priority
+	}
+	m.priorities[calendarID] = p
+	return p, nil
+}
+
+// outranks reports whether a, from a calendar of priority aPriority, is the
+// real reservation rather than b. Higher calendar priority wins; then a PIN
+// with reservation details beats a bare block; then the PIN saved first
+// keeps its slots. A PIN not yet saved never wins a tie with a saved one.
+func outranks(aPriority int, a *models.GuestPIN, bPriority int, b *models.GuestPIN) bool {
+	if aPriority != bPriority {
+		return aPriority > bPriority
+	}
+	if hasReservationDetails(a) != hasReservationDetails(b) {
+		return hasReservationDetails(a)
+	}
+	if a.ID == "" || b.ID == "" {
+		return b.ID == ""
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+// hasReservationDetails reports whether a PIN came from an event describing
+// a guest rather than a bare "Reserved" or "Blocked" entry.
+func hasReservationDetails(p *models.GuestPIN) bool {
+	for _, v := range []*string{p.ReservationCode, p.GuestName, p.GuestPhoneLast4} {
+		if v != nil && *v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// takeOverSlots returns, per lock, the slot a PIN can inherit from the PINs
+// it is about to replace as the programmed PIN of a stay.
+func (s *SyncService) takeOverSlots(ctx context.Context, mirrors []models.GuestPIN) (map[string]int, error) {
+	slots := make(map[string]int)
+	for _, mirror := range mirrors {
+		assignments, err := s.guestPINRepo.GetLockAssignments(ctx, mirror.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range assignments {
+			if a.SyncStatus == models.LockSyncRemoved {
+				continue
+			}
+			if _, ok := slots[a.LockID]; !ok {
+				slots[a.LockID] = a.SlotNumber
+			}
+		}
+	}
+	return slots, nil
+}
+
+// recheckLinked makes the calendars of the PINs linked to primaryID process
+// their feeds in full on their next sync, after the primary expired or moved.
+// Their PINs are then promoted, or linked again if the stay still matches.
+func (s *SyncService) recheckLinked(ctx context.Context, primaryID string) {
+	linked, err := s.guestPINRepo.ListLinked(ctx, primaryID)
+	if err != nil {
+		log.Printf("Failed to list PINs linked to %s: %v", primaryID, err)
+		return
+	}
+
+	cleared := make(map[string]bool)
+	for _, p := range linked {
+		if cleared[p.CalendarID] {
+			continue
+		}
+		cleared[p.CalendarID] = true
+		if err := s.calendarRepo.ClearFeedState(ctx, p.CalendarID); err != nil {
+			log.Printf("Failed to clear feed state of calendar %s: %v", p.CalendarID, err)
+		}
+	}
+}
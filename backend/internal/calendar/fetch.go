@@ -0,0 +1,269 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// Feed is a downloaded calendar feed.
+type Feed struct {
+	StatusCode   int // 0 for feeds not fetched over HTTP
+	Body         []byte
+	NotModified  bool   // Server answered 304 to a conditional request
+	ETag         string // Validators to send on the next fetch
+	LastModified string
+	ContentHash  string // SHA-256 of Body, hex encoded
+	Format       string // models.FeedFormat*; empty means iCalendar
+}
+
+// fullSyncInterval is how long an unchanged feed may go without being
+// processed in full. The periodic full pass picks up PINs whose state depends
+// on the clock, such as missing events passing their removal grace.
+const fullSyncInterval = 24 * time.Hour
+
+// FeedValidators are the values of a previous fetch used to make a
+// conditional request. Empty fields are not sent.
+type FeedValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// Fetch downloads a calendar feed, or reads it for a file:// URL. A response
+// other than 200 OK is returned together with an error, so callers can still
+// record its status.
+func (p *Parser) Fetch(url string) (*Feed, error) {
+	return p.FetchConditional(url, models.FetchOptions{}, FeedValidators{})
+}
+
+// FetchConditional downloads a calendar feed using opts, sending
+// If-None-Match and If-Modified-Since from validators. A 304 response is
+// returned as a Feed with NotModified set and no body.
+func (p *Parser) FetchConditional(feedURL string, opts models.FetchOptions, validators FeedValidators) (*Feed, error) {
+	if u, err := url.Parse(feedURL); err == nil && u.Scheme == "file" {
+		return fetchFile(u, opts)
+	}
+
+	req, err := newFeedRequest(http.MethodGet, feedURL, nil, opts)
+	if err != nil {
+		return nil, fmt.Errorf("fetching calendar: %w", err)
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	client, err := p.clientFor(opts)
+	if err != nil {
+		return nil, fmt.Errorf("fetching calendar: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Report the configured URL, not one carrying a query token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = feedURL
+		}
+		return nil, fmt.Errorf("fetching calendar: %w", err)
+	}
+	defer resp.Body.Close()
+
+	feed := &Feed{
+		StatusCode:   resp.StatusCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		feed.NotModified = true
+		return feed, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return feed, fmt.Errorf("calendar returned status %d", resp.StatusCode)
+	}
+
+	body, err := readLimited(resp.Body, opts.MaxBytes)
+	if err != nil {
+		return feed, err
+	}
+	feed.Body = body
+	feed.ContentHash = contentHash(body)
+
+	return feed, nil
+}
+
+// decodeFeed returns the events of a feed body in the given format.
+func (p *Parser) decodeFeed(format string, body []byte) ([]models.CalendarEvent, error) {
+	switch format {
+	case "", models.FeedFormatICal:
+		return p.Parse(bytes.NewReader(body))
+	case models.FeedFormatHACalendar:
+		return decodeHACalendar(body)
+	case models.FeedFormatReservations:
+		return decodeReservations(body)
+	default:
+		return nil, fmt.Errorf("unknown feed format %q", format)
+	}
+}
+
+// feedFromBody returns a Feed for a body that was not fetched over HTTP.
+func feedFromBody(body []byte) *Feed {
+	return &Feed{Body: body, ContentHash: contentHash(body)}
+}
+
+// contentHash returns the hex encoded SHA-256 of a feed body.
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// fetchFeed returns the current feed of a calendar from its source.
+func (s *SyncService) fetchFeed(ctx context.Context, cal *models.CalendarSubscription, validators FeedValidators) (*Feed, error) {
+	switch cal.SourceType {
+	case models.SourceCalDAV:
+		return s.fetchCalDAV(ctx, cal)
+	case models.SourceHACalendar:
+		return s.fetchHACalendar(ctx, cal)
+	case models.SourceReservationAPI:
+		return s.fetchReservations(ctx, cal)
+	case models.SourceUpload:
+		if cal.ID == "" {
+			return nil, fmt.Errorf("upload calendars can only be previewed once saved")
+		}
+		body, err := s.calendarRepo.GetUpload(ctx, cal.ID)
+		if err != nil {
+			return nil, err
+		}
+		if body == nil {
+			return nil, fmt.Errorf("no ICS file has been uploaded for this calendar")
+		}
+		return feedFromBody(body), nil
+	}
+
+	return s.parser.FetchConditional(cal.URL, cal.Fetch, validators)
+}
+
+// newFeedRequest builds a request for a feed with its custom headers and
+// credentials.
+func newFeedRequest(method, feedURL string, body []byte, opts models.FetchOptions) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, feedURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if auth := opts.Auth; auth != nil {
+		switch auth.Type {
+		case models.FeedAuthBasic:
+			req.SetBasicAuth(auth.Username, auth.Secret)
+		case models.FeedAuthHeader:
+			req.Header.Set(auth.Name, auth.Secret)
+		case models.FeedAuthQuery:
+			q := req.URL.Query()
+			q.Set(auth.Name, auth.Secret)
+			req.URL.RawQuery = q.Encode()
+		}
+	}
+
+	return req, nil
+}
+
+// clientFor returns an HTTP client applying opts' redirect policy and CA
+// bundle. Feeds that need neither share the parser's client.
+func (p *Parser) clientFor(opts models.FetchOptions) (*http.Client, error) {
+	policy := opts.RedirectPolicy
+	headerAuth := opts.Auth != nil && opts.Auth.Type == models.FeedAuthHeader
+	if (policy == "" || policy == models.RedirectFollow) && !headerAuth && opts.CABundle == nil {
+		return p.httpClient, nil
+	}
+
+	client := *p.httpClient
+	switch policy {
+	case "", models.RedirectFollow:
+		if headerAuth {
+			// net/http only drops its own sensitive headers when leaving the host
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				if req.URL.Host != via[0].URL.Host {
+					req.Header.Del(opts.Auth.Name)
+				}
+				return nil
+			}
+		}
+	case models.RedirectNone:
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	case models.RedirectSameHost:
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			first := via[0].URL
+			if req.URL.Host != first.Host || req.URL.Scheme != first.Scheme {
+				return fmt.Errorf("redirect to %s://%s not allowed by the calendar's redirect policy", req.URL.Scheme, req.URL.Host)
+			}
+			return nil
+		}
+	}
+
+	if opts.CABundle != nil {
+		pool, err := CertPool(*opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.Transport = transport
+	}
+
+	return &client, nil
+}
+
+// CertPool returns the system roots plus the certificates in a PEM bundle.
+func CertPool(pemBundle string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(pemBundle)) {
+		return nil, errors.New("CA bundle contains no PEM certificates")
+	}
+	return pool, nil
+}
+
+// feedStateFresh reports whether cal's stored feed state may be used to skip
+// an unchanged feed at now.
+func feedStateFresh(cal *models.CalendarSubscription, now time.Time) bool {
+	return cal.FeedContentHash != nil && cal.FeedProcessedAt != nil &&
+		now.Sub(*cal.FeedProcessedAt) < fullSyncInterval
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
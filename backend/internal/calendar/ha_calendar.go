@@ -0,0 +1,106 @@
+package calendar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/lock"
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// haCalendarEntityPattern matches the entity ID of a Home Assistant calendar.
+var haCalendarEntityPattern = regexp.MustCompile(`^calendar\.[a-z0-9_]+$`)
+
+// ValidateHACalendarEntity checks the entity ID of an ha_calendar calendar.
+func ValidateHACalendarEntity(entityID string) error {
+	if !haCalendarEntityPattern.MatchString(entityID) {
+		return fmt.Errorf("%q is not a calendar entity ID", entityID)
+	}
+	return nil
+}
+
+// fetchHACalendar returns the events of a Home Assistant calendar entity
+// over the sync window, encoded as JSON so they can be snapshotted and
+// compared like any other feed.
+func (s *SyncService) fetchHACalendar(ctx context.Context, cal *models.CalendarSubscription) (*Feed, error) {
+	now := time.Now().UTC()
+	events, err := s.haClient.GetCalendarEvents(ctx, cal.URL, now.Add(-queryLookback), now.Add(s.parser.recurrenceHorizon))
+	if err != nil {
+		return nil, fmt.Errorf("fetching calendar entity %s: %w", cal.URL, err)
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return nil, fmt.Errorf("encoding calendar events: %w", err)
+	}
+
+	feed := feedFromBody(body)
+	feed.StatusCode = http.StatusOK
+	feed.Format = models.FeedFormatHACalendar
+	return feed, nil
+}
+
+// decodeHACalendar maps the JSON events of a Home Assistant calendar onto
+// CalendarEvents. Events without a UID are keyed by their summary and start,
+// so moving such an event replaces its PIN. Events whose times cannot be
+// read are logged and skipped.
+func decodeHACalendar(body []byte) ([]models.CalendarEvent, error) {
+	var haEvents []lock.CalendarEvent
+	if err := json.Unmarshal(body, &haEvents); err != nil {
+		return nil, fmt.Errorf("decoding calendar events: %w", err)
+	}
+
+	events := make([]models.CalendarEvent, 0, len(haEvents))
+	for _, e := range haEvents {
+		start, allDay, err := parseHACalendarTime(e.Start)
+		if err != nil {
+			log.Printf("Skipping calendar event %q: start: %v", e.Summary, err)
+			continue
+		}
+		end, _, err := parseHACalendarTime(e.End)
+		if err != nil {
+			log.Printf("Skipping calendar event %q: end: %v", e.Summary, err)
+			continue
+		}
+
+		uid := e.UID
+		if uid == "" {
+			sum := sha256.Sum256([]byte(e.Summary + "\x00" + start.Format(time.RFC3339)))
+			uid = "ha-" + hex.EncodeToString(sum[:8])
+		}
+
+		events = append(events, models.CalendarEvent{
+			UID:          uid,
+			RecurrenceID: e.RecurrenceID,
+			Summary:      e.Summary,
+			Description:  e.Description,
+			Location:     e.Location,
+			Start:        start,
+			End:          end,
+			AllDay:       allDay,
+		})
+	}
+
+	return events, nil
+}
+
+// parseHACalendarTime parses an event time, reporting whether it is a date.
+// Dates are returned as UTC midnight, like iCalendar DATE values.
+func parseHACalendarTime(t lock.CalendarTime) (time.Time, bool, error) {
+	if t.DateTime != "" {
+		parsed, err := time.Parse(time.RFC3339, t.DateTime)
+		return parsed.UTC(), false, err
+	}
+	if t.Date != "" {
+		parsed, err := time.Parse("2006-01-02", t.Date)
+		return parsed, true, err
+	}
+	return time.Time{}, false, fmt.Errorf("no date or dateTime")
+}
@@ -0,0 +1,207 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// DefaultSnapshotRetention is how many raw feed snapshots are kept per calendar.
+const DefaultSnapshotRetention = 10
+
+// ErrSnapshotNotFound is returned when a sync run or its snapshot does not exist.
+var ErrSnapshotNotFound = errors.New("sync snapshot not found")
+
+// recordRun stores the outcome of a sync run and prunes old snapshots.
+func (s *SyncService) recordRun(ctx context.Context, run *models.CalendarSyncRun, result *models.CalendarSyncResult, err error) {
+	run.FinishedAt = time.Now().UTC()
+	run.DurationMS = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	run.EventsFound = result.EventsFound
+	run.EventsSkipped = result.EventsSkipped
+	run.PINsCreated = result.PINsCreated
+	run.PINsUpdated = result.PINsUpdated
+	run.PINsRemoved = result.PINsRemoved
+	run.PendingRemoval = len(result.PendingRemoval)
+	run.Cancellations = result.Cancellations
+
+	var anomaly *AnomalyError
+	switch {
+	case errors.As(err, &anomaly):
+		run.Status = models.SyncRunAnomaly
+	case err != nil:
+		run.Status = models.SyncRunError
+	case result.Unchanged:
+		run.Status = models.SyncRunUnchanged
+	default:
+		run.Status = models.SyncRunSuccess
+	}
+	if err != nil {
+		msg := err.Error()
+		run.Error = &msg
+	}
+
+	if s.snapshotRetention <= 0 {
+		run.Snapshot = nil
+	}
+	if run.SnapshotFormat == "" {
+		run.SnapshotFormat = models.FeedFormatICal
+	}
+
+	if err := s.syncRunRepo.Create(ctx, run); err != nil {
+		log.Printf("Failed to record sync run for calendar %s: %v", run.CalendarID, err)
+		return
+	}
+	if err := s.syncRunRepo.PruneSnapshots(ctx, run.CalendarID, s.snapshotRetention); err != nil {
+		log.Printf("Failed to prune sync snapshots for calendar %s: %v", run.CalendarID, err)
+	}
+}
+
+// SnapshotDiff compares the events of two stored feed snapshots.
+type SnapshotDiff struct {
+	FromRunID     string                 `json:"from_run_id"`
+	FromStartedAt time.Time              `json:"from_started_at"`
+	ToRunID       string                 `json:"to_run_id"`
+	ToStartedAt   time.Time              `json:"to_started_at"`
+	Added         []models.CalendarEvent `json:"added"`
+	Removed       []models.CalendarEvent `json:"removed"`
+	Changed       []EventChange          `json:"changed"`
+	Unchanged     int                    `json:"unchanged"`
+}
+
+// EventChange is an event present in both snapshots with different fields.
+type EventChange struct {
+	Key    string               `json:"key"`
+	Fields []string             `json:"fields"`
+	Before models.CalendarEvent `json:"before"`
+	After  models.CalendarEvent `json:"after"`
+}
+
+// DiffSyncRuns compares the feed snapshots of two sync runs of a calendar.
+// An empty toRunID means the newest snapshot, and an empty fromRunID the one
+// stored before toRunID.
+func (s *SyncService) DiffSyncRuns(ctx context.Context, calendarID, fromRunID, toRunID string) (*SnapshotDiff, error) {
+	if fromRunID == "" || toRunID == "" {
+		ids, err := s.syncRunRepo.ListSnapshotRunIDs(ctx, calendarID)
+		if err != nil {
+			return nil, err
+		}
+		if toRunID == "" {
+			if len(ids) == 0 {
+				return nil, ErrSnapshotNotFound
+			}
+			toRunID = ids[0]
+		}
+		if fromRunID == "" {
+			for i, id := range ids {
+				if id == toRunID && i+1 < len(ids) {
+					fromRunID = ids[i+1]
+				}
+			}
+			if fromRunID == "" {
+				return nil, ErrSnapshotNotFound
+			}
+		}
+	}
+
+	from, fromEvents, err := s.snapshotEvents(ctx, calendarID, fromRunID)
+	if err != nil {
+		return nil, err
+	}
+	to, toEvents, err := s.snapshotEvents(ctx, calendarID, toRunID)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SnapshotDiff{
+		FromRunID:     from.ID,
+		FromStartedAt: from.StartedAt,
+		ToRunID:       to.ID,
+		ToStartedAt:   to.StartedAt,
+		Added:         []models.CalendarEvent{},
+		Removed:       []models.CalendarEvent{},
+		Changed:       []EventChange{},
+	}
+
+	for key, after := range toEvents {
+		before, ok := fromEvents[key]
+		if !ok {
+			diff.Added = append(diff.Added, after)
+			continue
+		}
+		if fields := changedEventFields(before, after); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, EventChange{Key: key, Fields: fields, Before: before, After: after})
+		} else {
+			diff.Unchanged++
+		}
+	}
+	for key, before := range fromEvents {
+		if _, ok := toEvents[key]; !ok {
+			diff.Removed = append(diff.Removed, before)
+		}
+	}
+
+	sortEvents(diff.Added)
+	sortEvents(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].After.Start.Before(diff.Changed[j].After.Start)
+	})
+
+	return diff, nil
+}
+
+// snapshotEvents loads a run's snapshot and parses it into events by key.
+func (s *SyncService) snapshotEvents(ctx context.Context, calendarID, runID string) (*models.CalendarSyncRun, map[string]models.CalendarEvent, error) {
+	run, err := s.syncRunRepo.GetByID(ctx, calendarID, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if run == nil || run.Snapshot == nil {
+		return nil, nil, ErrSnapshotNotFound
+	}
+
+	events, err := s.parser.decodeFeed(run.SnapshotFormat, run.Snapshot)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing snapshot %s: %w", runID, err)
+	}
+
+	byKey := make(map[string]models.CalendarEvent, len(events))
+	for _, e := range events {
+		byKey[e.Key()] = e
+	}
+	return run, byKey, nil
+}
+
+// changedEventFields lists the feed fields that differ between two versions of an event.
+func changedEventFields(a, b models.CalendarEvent) []string {
+	var fields []string
+	if a.Summary != b.Summary {
+		fields = append(fields, "summary")
+	}
+	if a.Description != b.Description {
+		fields = append(fields, "description")
+	}
+	if a.Location != b.Location {
+		fields = append(fields, "location")
+	}
+	if !a.Start.Equal(b.Start) {
+		fields = append(fields, "start")
+	}
+	if !a.End.Equal(b.End) {
+		fields = append(fields, "end")
+	}
+	if a.AllDay != b.AllDay {
+		fields = append(fields, "all_day")
+	}
+	return fields
+}
+
+func sortEvents(events []models.CalendarEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+}
@@ -3,154 +3,687 @@ package calendar
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/guest-lock-manager/backend/internal/storage/models"
 )
 
+// DefaultRecurrenceHorizonDays is how far ahead recurring events are expanded
+// when no horizon is configured.
+const DefaultRecurrenceHorizonDays = 180
+
+// MaxRecurrenceHorizonDays bounds the configurable recurrence horizon; PINs
+// are not generated for stays years ahead.
+const MaxRecurrenceHorizonDays = 1095
+
 // Parser parses iCal/ICS calendar feeds.
 type Parser struct {
-	httpClient *http.Client
+	httpClient        *http.Client
+	recurrenceHorizon time.Duration
 }
 
-// NewParser creates a new iCal parser.
+// NewParser creates a new iCal parser using the default recurrence horizon.
 func NewParser() *Parser {
+	return NewParserWithHorizon(DefaultRecurrenceHorizonDays)
+}
+
+// NewParserWithHorizon creates a new iCal parser that expands recurring events
+// up to horizonDays into the future.
+func NewParserWithHorizon(horizonDays int) *Parser {
+	if horizonDays <= 0 {
+		horizonDays = DefaultRecurrenceHorizonDays
+	}
+	if horizonDays > MaxRecurrenceHorizonDays {
+		horizonDays = MaxRecurrenceHorizonDays
+	}
 	return &Parser{
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		recurrenceHorizon: time.Duration(horizonDays) * 24 * time.Hour,
 	}
 }
 
 // FetchAndParse downloads and parses an iCal feed from a URL.
 func (p *Parser) FetchAndParse(url string) ([]models.CalendarEvent, error) {
-	resp, err := p.httpClient.Get(url)
+	feed, err := p.Fetch(url)
 	if err != nil {
-		return nil, fmt.Errorf("fetching calendar: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("calendar returned status %d", resp.StatusCode)
-	}
-
-	return p.Parse(resp.Body)
+	return p.Parse(bytes.NewReader(feed.Body))
 }
 
 // Parse reads and parses iCal data from a reader.
+// Events whose dates cannot be interpreted are logged and skipped rather than
+// being returned with zero times. Recurring events are expanded into one event
+// per instance up to the parser's recurrence horizon. Events of a METHOD:CANCEL
+// calendar are marked cancelled, and when an event appears more than once only
+// its latest revision is returned.
 func (p *Parser) Parse(r io.Reader) ([]models.CalendarEvent, error) {
+	roots, err := readComponents(r)
+	if err != nil {
+		return nil, err
+	}
+
+	windowEnd := time.Now().UTC().Add(p.recurrenceHorizon)
+
 	var events []models.CalendarEvent
-	var currentEvent *models.CalendarEvent
-	var currentField string
-	var multilineValue strings.Builder
+	found := false
+	for _, root := range roots {
+		if root.Name != "VCALENDAR" {
+			continue
+		}
+		found = true
+		first := len(events)
+
+		tz := newTimezoneResolver(root)
+
+		// Modified instances (RECURRENCE-ID) are indexed by UID and original
+		// start so they can replace the instance their master would generate.
+		var masters []*component
+		overrides := make(map[string]map[string]*component)
+		for _, child := range root.Children {
+			if child.Name != "VEVENT" {
+				continue
+			}
+
+			rid := child.prop("RECURRENCE-ID")
+			if rid == nil {
+				masters = append(masters, child)
+				continue
+			}
+
+			t, allDay, err := tz.parseDateTime(rid)
+			if err != nil {
+				log.Printf("Skipping calendar event %q: RECURRENCE-ID: %v", child.value("UID"), err)
+				continue
+			}
+			uid := child.value("UID")
+			if overrides[uid] == nil {
+				overrides[uid] = make(map[string]*component)
+			}
+			overrides[uid][recurrenceKey(t, allDay)] = child
+		}
 
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
+		for _, child := range masters {
+			expanded, err := p.buildSeries(child, tz, overrides[child.value("UID")], windowEnd)
+			if err != nil {
+				log.Printf("Skipping calendar event %q: %v", child.value("UID"), err)
+				continue
+			}
+			events = append(events, expanded...)
+		}
+
+		// Overrides not consumed by a master (the feed only carries the
+		// instance, or it moved an instance the rule no longer generates)
+		// are still real bookings.
+		events = append(events, p.orphanOverrides(overrides, tz)...)
 
-		// Handle line continuation (lines starting with space or tab)
-		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
-			if currentField != "" {
-				multilineValue.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, " "), "\t"))
+		// RFC 5546: METHOD:CANCEL cancels every event the object carries
+		if strings.EqualFold(root.value("METHOD"), "CANCEL") {
+			for i := first; i < len(events); i++ {
+				events[i].Status = models.EventStatusCancelled
 			}
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no VCALENDAR found in calendar data")
+	}
+
+	return latestRevisions(events), nil
+}
+
+// latestRevisions keeps one event per key: the one with the highest SEQUENCE,
+// then the latest LAST-MODIFIED. On a full tie a cancellation wins, otherwise
+// the later copy in the feed. Events keep the position of their first copy.
+func latestRevisions(events []models.CalendarEvent) []models.CalendarEvent {
+	index := make(map[string]int, len(events))
+	out := events[:0]
+	for _, event := range events {
+		i, ok := index[event.Key()]
+		if !ok {
+			index[event.Key()] = len(out)
+			out = append(out, event)
 			continue
 		}
+		if !olderRevision(event, out[i]) {
+			out[i] = event
+		}
+	}
+	return out
+}
+
+// olderRevision reports whether a is an older revision of an event than b.
+func olderRevision(a, b models.CalendarEvent) bool {
+	if a.Sequence != b.Sequence {
+		return a.Sequence < b.Sequence
+	}
+	if a.LastModified != nil && b.LastModified != nil && !a.LastModified.Equal(*b.LastModified) {
+		return a.LastModified.Before(*b.LastModified)
+	}
+	return b.IsCancelled() && !a.IsCancelled()
+}
+
+// buildSeries builds a VEVENT and, when it carries RRULE or RDATE, expands it
+// into its instances up to windowEnd. EXDATEs are removed and instances with a
+// matching override are replaced by it; consumed overrides are deleted from the map.
+func (p *Parser) buildSeries(c *component, tz *timezoneResolver, overrides map[string]*component, windowEnd time.Time) ([]models.CalendarEvent, error) {
+	master, err := p.buildEvent(c, tz)
+	if err != nil {
+		return nil, err
+	}
+
+	rrule := c.prop("RRULE")
+	rdates := c.props("RDATE")
+	if rrule == nil && len(rdates) == 0 {
+		return []models.CalendarEvent{master}, nil
+	}
+
+	localize := tz.localizer(c.prop("DTSTART"), master.AllDay)
+	startWall := inLocation(master.Start, time.UTC)
 
-		// Process previous multiline field
-		if currentField != "" && currentEvent != nil {
-			p.setEventField(currentEvent, currentField, multilineValue.String())
-			currentField = ""
-			multilineValue.Reset()
+	walls := []time.Time{startWall}
+	if rrule != nil {
+		rule, err := parseRecurrenceRule(rrule.Value, tz)
+		if err != nil {
+			return nil, fmt.Errorf("RRULE: %w", err)
 		}
+		walls = rule.expand(startWall, localize, windowEnd)
+	}
+
+	starts := make([]time.Time, 0, len(walls)+len(rdates))
+	for _, wall := range walls {
+		starts = append(starts, localize(wall))
+	}
+	for _, t := range parseDateList(rdates, tz) {
+		if !t.After(windowEnd) {
+			starts = append(starts, t)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	excluded := make(map[string]bool)
+	for _, t := range parseDateList(c.props("EXDATE"), tz) {
+		excluded[recurrenceKey(t, master.AllDay)] = true
+	}
+
+	seen := make(map[string]bool)
+	var events []models.CalendarEvent
+	for _, start := range starts {
+		key := recurrenceKey(start, master.AllDay)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
 
-		// Parse field:value
-		colonIdx := strings.Index(line, ":")
-		if colonIdx == -1 {
+		override := overrides[key]
+		delete(overrides, key)
+		if excluded[key] {
 			continue
 		}
 
-		field := line[:colonIdx]
-		value := line[colonIdx+1:]
+		if override != nil {
+			event, err := p.buildEvent(override, tz)
+			if err != nil {
+				log.Printf("Skipping calendar event %q instance %s: %v", master.UID, key, err)
+				continue
+			}
+			event.UID = master.UID
+			event.RecurrenceID = key
+			events = append(events, event)
+			continue
+		}
+
+		instance := master
+		instance.Start = start
+		if master.AllDay {
+			days := int(master.End.Sub(master.Start).Hours() / 24)
+			instance.End = start.AddDate(0, 0, days)
+		} else {
+			instance.End = start.Add(master.End.Sub(master.Start))
+		}
+		instance.RecurrenceID = key
+		events = append(events, instance)
+	}
+
+	return events, nil
+}
+
+// orphanOverrides builds events for RECURRENCE-ID instances that no master consumed.
+func (p *Parser) orphanOverrides(overrides map[string]map[string]*component, tz *timezoneResolver) []models.CalendarEvent {
+	uids := make([]string, 0, len(overrides))
+	for uid := range overrides {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	var events []models.CalendarEvent
+	for _, uid := range uids {
+		keys := make([]string, 0, len(overrides[uid]))
+		for key := range overrides[uid] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			event, err := p.buildEvent(overrides[uid][key], tz)
+			if err != nil {
+				log.Printf("Skipping calendar event %q instance %s: %v", uid, key, err)
+				continue
+			}
+			event.RecurrenceID = key
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// parseDateList parses the comma-separated values of RDATE/EXDATE properties.
+// PERIOD values contribute their start; unparseable entries are logged and ignored.
+func parseDateList(props []contentLine, tz *timezoneResolver) []time.Time {
+	var out []time.Time
+	for _, prop := range props {
+		valueType := prop.param("VALUE")
+		if strings.EqualFold(valueType, "PERIOD") {
+			valueType = ""
+		}
+		for _, v := range strings.Split(prop.Value, ",") {
+			v = strings.TrimSpace(v)
+			if i := strings.IndexByte(v, '/'); i != -1 {
+				v = v[:i]
+			}
+			if v == "" {
+				continue
+			}
+			t, _, err := tz.parseValue(v, valueType, prop.param("TZID"))
+			if err != nil {
+				log.Printf("Ignoring %s value %q: %v", prop.Name, v, err)
+				continue
+			}
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// buildEvent converts a VEVENT component into a CalendarEvent.
+func (p *Parser) buildEvent(c *component, tz *timezoneResolver) (models.CalendarEvent, error) {
+	event := models.CalendarEvent{
+		UID:         c.text("UID"),
+		Summary:     c.text("SUMMARY"),
+		Description: c.text("DESCRIPTION"),
+		Location:    c.text("LOCATION"),
+		Status:      strings.ToUpper(strings.TrimSpace(c.value("STATUS"))),
+	}
+
+	if v := strings.TrimSpace(c.value("SEQUENCE")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			event.Sequence = n
+		}
+	}
+	if lm := c.prop("LAST-MODIFIED"); lm != nil {
+		if t, _, err := tz.parseDateTime(lm); err == nil {
+			t = t.UTC()
+			event.LastModified = &t
+		}
+	}
+
+	dtstart := c.prop("DTSTART")
+	if dtstart == nil {
+		return event, errors.New("missing DTSTART")
+	}
+	start, allDay, err := tz.parseDateTime(dtstart)
+	if err != nil {
+		return event, fmt.Errorf("DTSTART: %w", err)
+	}
+	event.Start = start
+	event.AllDay = allDay
+
+	switch {
+	case c.prop("DTEND") != nil:
+		end, _, err := tz.parseDateTime(c.prop("DTEND"))
+		if err != nil {
+			return event, fmt.Errorf("DTEND: %w", err)
+		}
+		event.End = end
+	case c.prop("DURATION") != nil:
+		d, err := parseDuration(c.prop("DURATION").Value)
+		if err != nil {
+			return event, fmt.Errorf("DURATION: %w", err)
+		}
+		event.End = d.addTo(start)
+	case allDay:
+		// RFC 5545 3.6.1: a date-only event without DTEND lasts one day
+		event.End = start.AddDate(0, 0, 1)
+	default:
+		event.End = start
+	}
+
+	if !event.End.After(event.Start) {
+		return event, fmt.Errorf("end %s is not after start %s", event.End.Format(time.RFC3339), event.Start.Format(time.RFC3339))
+	}
+
+	if event.UID == "" {
+		event.UID = syntheticUID(event)
+	}
+
+	return event, nil
+}
+
+// syntheticUID derives a stable UID for events that omit one, so they can
+// still be matched across syncs.
+func syntheticUID(event models.CalendarEvent) string {
+	sum := sha1.Sum([]byte(event.Start.UTC().Format(time.RFC3339) + "|" + event.End.UTC().Format(time.RFC3339) + "|" + event.Summary))
+	return "generated-" + hex.EncodeToString(sum[:8])
+}
+
+// contentLine is a single unfolded iCal property: NAME;PARAM=VALUE:value
+type contentLine struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// param returns a property parameter, or an empty string if absent.
+func (l *contentLine) param(name string) string {
+	if l == nil || l.Params == nil {
+		return ""
+	}
+	return l.Params[name]
+}
+
+// component is a parsed BEGIN/END block with its properties and nested blocks.
+type component struct {
+	Name       string
+	Properties []contentLine
+	Children   []*component
+}
+
+// prop returns the first property with the given name, or nil.
+func (c *component) prop(name string) *contentLine {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
 
-		// Handle property parameters (e.g., DTSTART;VALUE=DATE:20231215)
-		if semicolonIdx := strings.Index(field, ";"); semicolonIdx != -1 {
-			field = field[:semicolonIdx]
+// props returns all properties with the given name.
+func (c *component) props(name string) []contentLine {
+	var out []contentLine
+	for _, p := range c.Properties {
+		if p.Name == name {
+			out = append(out, p)
 		}
+	}
+	return out
+}
+
+// value returns the raw value of the first property with the given name.
+func (c *component) value(name string) string {
+	if p := c.prop(name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// text returns the unescaped TEXT value of the first property with the given name.
+func (c *component) text(name string) string {
+	return unescapeText(c.value(name))
+}
+
+// readComponents reads unfolded content lines and assembles them into a component tree.
+func readComponents(r io.Reader) ([]*component, error) {
+	var roots []*component
+	var stack []*component
 
-		switch field {
+	err := unfoldLines(r, func(raw string) error {
+		line, ok := parseContentLine(raw)
+		if !ok {
+			return nil
+		}
+
+		switch line.Name {
 		case "BEGIN":
-			if value == "VEVENT" {
-				currentEvent = &models.CalendarEvent{}
+			comp := &component{Name: strings.ToUpper(line.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, comp)
+			} else {
+				roots = append(roots, comp)
 			}
+			stack = append(stack, comp)
 		case "END":
-			if value == "VEVENT" && currentEvent != nil {
-				// Only include events with valid dates
-				if !currentEvent.Start.IsZero() && !currentEvent.End.IsZero() {
-					events = append(events, *currentEvent)
+			name := strings.ToUpper(line.Value)
+			if len(stack) == 0 || stack[len(stack)-1].Name != name {
+				return fmt.Errorf("unexpected END:%s", line.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) > 0 {
+				current := stack[len(stack)-1]
+				current.Properties = append(current.Properties, line)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading calendar: %w", err)
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("reading calendar: unterminated %s", stack[len(stack)-1].Name)
+	}
+
+	return roots, nil
+}
+
+// unfoldLines reads physical lines of any length and joins RFC 5545 folded
+// continuations (lines beginning with a space or tab) before calling fn.
+func unfoldLines(r io.Reader, fn func(line string) error) error {
+	reader := bufio.NewReader(r)
+	var current strings.Builder
+	pending := false
+
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		line := strings.TrimRight(raw, "\r\n")
+		if len(raw) > 0 {
+			if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+				current.WriteString(line[1:])
+			} else {
+				if pending {
+					if ferr := fn(current.String()); ferr != nil {
+						return ferr
+					}
 				}
-				currentEvent = nil
+				current.Reset()
+				current.WriteString(line)
+				pending = line != ""
 			}
-		case "UID", "SUMMARY", "DESCRIPTION", "LOCATION", "DTSTART", "DTEND":
-			if currentEvent != nil {
-				currentField = field
-				multilineValue.WriteString(value)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if pending {
+		return fn(current.String())
+	}
+	return nil
+}
+
+// parseContentLine splits a content line into name, parameters and value.
+// Parameter values may be quoted and contain ':' or ';'.
+func parseContentLine(line string) (contentLine, bool) {
+	var cl contentLine
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return cl, false
+	}
+	cl.Name = strings.ToUpper(line[:i])
+
+	for i < len(line) && line[i] == ';' {
+		i++
+		eq := strings.IndexByte(line[i:], '=')
+		if eq == -1 {
+			return cl, false
+		}
+		key := strings.ToUpper(line[i : i+eq])
+		i += eq + 1
+
+		var val strings.Builder
+		inQuotes := false
+		for ; i < len(line); i++ {
+			ch := line[i]
+			if ch == '"' {
+				inQuotes = !inQuotes
+				continue
 			}
+			if !inQuotes && (ch == ';' || ch == ':') {
+				break
+			}
+			val.WriteByte(ch)
+		}
+
+		if cl.Params == nil {
+			cl.Params = make(map[string]string)
 		}
+		cl.Params[key] = val.String()
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("reading calendar: %w", err)
+	if i >= len(line) || line[i] != ':' {
+		return cl, false
 	}
+	cl.Value = line[i+1:]
 
-	return events, nil
+	return cl, true
 }
 
-// setEventField sets a field on a CalendarEvent.
-func (p *Parser) setEventField(event *models.CalendarEvent, field, value string) {
-	// Unescape common iCal escape sequences
-	value = strings.ReplaceAll(value, "\\n", "\n")
-	value = strings.ReplaceAll(value, "\\,", ",")
-	value = strings.ReplaceAll(value, "\\;", ";")
-	value = strings.ReplaceAll(value, "\\\\", "\\")
+// unescapeText decodes RFC 5545 TEXT escapes in a single pass so that
+// sequences like "\\n" are not double-decoded.
+func unescapeText(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
 
-	switch field {
-	case "UID":
-		event.UID = value
-	case "SUMMARY":
-		event.Summary = value
-	case "DESCRIPTION":
-		event.Description = value
-	case "LOCATION":
-		event.Location = value
-	case "DTSTART":
-		event.Start = p.parseDateTime(value)
-	case "DTEND":
-		event.End = p.parseDateTime(value)
+	var b strings.Builder
+	b.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if ch != '\\' || i == len(value)-1 {
+			b.WriteByte(ch)
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		case ',', ';', '\\':
+			b.WriteByte(value[i])
+		default:
+			// Unknown escape: keep it verbatim
+			b.WriteByte('\\')
+			b.WriteByte(value[i])
+		}
 	}
+	return b.String()
+}
+
+// icalDuration is a parsed RFC 5545 DURATION value.
+type icalDuration struct {
+	negative bool
+	days     int
+	clock    time.Duration
 }
 
-// parseDateTime parses an iCal date/time value.
-func (p *Parser) parseDateTime(value string) time.Time {
-	// Common iCal date formats
-	formats := []string{
-		"20060102T150405Z",     // UTC datetime
-		"20060102T150405",      // Local datetime
-		"20060102",             // Date only
-		"2006-01-02T15:04:05Z", // ISO 8601 with dashes
-		"2006-01-02",           // ISO 8601 date
+// addTo applies the duration to t, adding nominal days in t's location so
+// that a one-day duration spans a DST change correctly.
+func (d icalDuration) addTo(t time.Time) time.Time {
+	if d.negative {
+		return t.AddDate(0, 0, -d.days).Add(-d.clock)
+	}
+	return t.AddDate(0, 0, d.days).Add(d.clock)
+}
+
+// parseDuration parses values such as "P1D", "PT2H30M", "P2W" or "-PT15M".
+func parseDuration(value string) (icalDuration, error) {
+	var d icalDuration
+	s := strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(s, "-"):
+		d.negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	if !strings.HasPrefix(s, "P") || len(s) < 2 {
+		return d, fmt.Errorf("invalid duration %q", value)
 	}
+	s = s[1:]
+
+	inTime := false
+	num := 0
+	haveNum := false
+	for _, ch := range s {
+		switch {
+		case ch >= '0' && ch <= '9':
+			num = num*10 + int(ch-'0')
+			haveNum = true
+			continue
+		case ch == 'T':
+			inTime = true
+			continue
+		}
 
-	for _, format := range formats {
-		if t, err := time.Parse(format, value); err == nil {
-			return t
+		if !haveNum {
+			return d, fmt.Errorf("invalid duration %q", value)
+		}
+		switch {
+		case ch == 'W' && !inTime:
+			d.days += num * 7
+		case ch == 'D' && !inTime:
+			d.days += num
+		case ch == 'H' && inTime:
+			d.clock += time.Duration(num) * time.Hour
+		case ch == 'M' && inTime:
+			d.clock += time.Duration(num) * time.Minute
+		case ch == 'S' && inTime:
+			d.clock += time.Duration(num) * time.Second
+		default:
+			return d, fmt.Errorf("invalid duration %q", value)
 		}
+		num = 0
+		haveNum = false
 	}
 
-	return time.Time{}
+	if haveNum {
+		return d, fmt.Errorf("invalid duration %q", value)
+	}
+
+	return d, nil
 }
 
 // FilterFutureEvents returns only events that haven't ended yet.
@@ -175,6 +708,3 @@ func FilterByDateRange(events []models.CalendarEvent, start, end time.Time) []mo
 	}
 	return filtered
 }
-
-
-
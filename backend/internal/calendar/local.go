@@ -0,0 +1,136 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// DefaultLocalCalendarDir is where file:// calendar feeds are read from.
+const DefaultLocalCalendarDir = "/data/calendars"
+
+var localCalendarDir atomic.Value
+
+// SetLocalCalendarDir overrides the directory file:// feeds must live in.
+// Empty values reset to the default.
+func SetLocalCalendarDir(dir string) {
+	if dir == "" {
+		dir = DefaultLocalCalendarDir
+	}
+	localCalendarDir.Store(filepath.Clean(dir))
+}
+
+// GetLocalCalendarDir returns the directory file:// feeds must live in.
+func GetLocalCalendarDir() string {
+	if v := localCalendarDir.Load(); v != nil {
+		return v.(string)
+	}
+	return DefaultLocalCalendarDir
+}
+
+// ValidateFeedURL checks the URL of an iCal calendar: an http or https URL,
+// or a file:// path inside the local calendar directory.
+func ValidateFeedURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return errors.New("URL has no host")
+		}
+		return nil
+	case "file":
+		_, err := localFeedPath(u, false)
+		return err
+	default:
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+}
+
+// localFeedPath returns the path of a file:// URL, rejecting paths outside
+// the local calendar directory. With resolve set, symlinks are followed
+// before the check, so the file must exist.
+func localFeedPath(u *url.URL, resolve bool) (string, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("file URL must not name a host")
+	}
+	if !filepath.IsAbs(u.Path) {
+		return "", fmt.Errorf("file URL must have an absolute path")
+	}
+
+	dir := GetLocalCalendarDir()
+	path := filepath.Clean(u.Path)
+	if resolve {
+		var err error
+		if dir, err = filepath.EvalSymlinks(dir); err != nil {
+			return "", fmt.Errorf("reading calendar directory: %w", err)
+		}
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return "", fmt.Errorf("reading calendar file: %w", err)
+		}
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("calendar files must be inside %s", GetLocalCalendarDir())
+	}
+	return path, nil
+}
+
+// fetchFile reads a file:// feed. Files have no status code; their
+// modification time is reported as Last-Modified.
+func fetchFile(u *url.URL, opts models.FetchOptions) (*Feed, error) {
+	path, err := localFeedPath(u, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading calendar file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("reading calendar file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("calendar file %s is not a regular file", path)
+	}
+
+	body, err := readLimited(f, opts.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := feedFromBody(body)
+	feed.LastModified = info.ModTime().UTC().Format(http.TimeFormat)
+	return feed, nil
+}
+
+// readLimited reads r, failing if it holds more than limit bytes. A limit of
+// 0 or less uses DefaultMaxFeedBytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = models.DefaultMaxFeedBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading calendar: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("calendar feed exceeds the %d byte limit", limit)
+	}
+	return body, nil
+}
@@ -0,0 +1,861 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/pin"
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// SyncPlan describes the changes one calendar sync makes to guest PINs.
+// Building a plan only reads from the database; applyPlan writes it.
+type SyncPlan struct {
+	CalendarID     string                    `json:"calendar_id,omitempty"`
+	Summary        models.CalendarSyncResult `json:"summary"`
+	Create         []PlannedPIN              `json:"create"`
+	Update         []PlannedPIN              `json:"update"`
+	Expire         []PlannedPIN              `json:"expire"`
+	Cancel         []PlannedPIN              `json:"cancel"`                  // Events cancelled in the feed; expired without grace
+	PendingRemoval []PlannedPIN              `json:"pending_removal"`         // Missing from the feed, still in grace
+	Link           []PlannedPIN              `json:"link"`                    // Other calendars' PINs of the same stays, linked to this one's
+	Anomaly        string                    `json:"anomaly,omitempty"`       // Why removals are held
+	HeldRemovals   int                       `json:"held_removals,omitempty"` // Expirations held by the anomaly
+
+	reservations map[string]time.Time            // Event key -> PIN end for every reservation in the feed
+	cancelled    map[string]models.CalendarEvent // Event key -> cancelled event in the feed
+	returned     []string                        // PINs whose missing events are back in the feed
+	conflicted   bool                            // PINs are left in conflict status
+}
+
+// PlannedPIN is a guest PIN as it will look after the sync.
+type PlannedPIN struct {
+	GuestPINID       string            `json:"guest_pin_id,omitempty"`
+	EventUID         string            `json:"event_uid"`
+	EventSummary     string            `json:"event_summary,omitempty"`
+	PINCode          string            `json:"pin_code"`
+	GenerationMethod string            `json:"generation_method"`
+	ValidFrom        time.Time         `json:"valid_from"`
+	ValidUntil       time.Time         `json:"valid_until"`
+	Status           string            `json:"status"`
+	MissingSince     *time.Time        `json:"missing_since,omitempty"`
+	MissingCount     int               `json:"missing_count,omitempty"`
+	Changes          []string          `json:"changes,omitempty"` // Fields changed by an update
+	Locks            []PlannedLockSlot `json:"locks"`
+	Conflicts        []pin.Conflict    `json:"conflicts,omitempty"`
+	LinkedPINID      string            `json:"linked_pin_id,omitempty"`     // Same stay on another calendar; not programmed
+	ReplacedPINCode  string            `json:"replaced_pin_code,omitempty"` // Conflicting code replaced by PINCode
+
+	guestPIN *models.GuestPIN // Row written by applyPlan
+	linkTo   *models.GuestPIN // PIN a Link entry is linked to; may not be saved yet
+}
+
+// PlannedLockSlot is the lock slot a planned PIN is assigned to. Error is set
+// when no slot could be allocated; the PIN is then not assigned to that lock.
+type PlannedLockSlot struct {
+	LockID     string `json:"lock_id"`
+	LockName   string `json:"lock_name,omitempty"`
+	SlotNumber int    `json:"slot_number,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Fields reported in PlannedPIN.Changes.
+const (
+	changeDates              = "dates"
+	changePINCode            = "pin_code"
+	changeReservationDetails = "reservation_details"
+	changeLink               = "link"   // Linked to, or promoted from, another calendar's PIN of the stay
+	changeStatus             = "status" // Set to, or cleared from, conflict status
+)
+
+// Skip reasons for events that are not applied to a PIN.
+const (
+	SkipReasonCancelled = "cancelled" // Cancelled event with no live PIN
+	SkipReasonStale     = "stale"     // Older revision than the one the PIN was built from
+	SkipReasonNoPIN     = "no_pin"    // None of the calendar's PIN methods produced a code
+)
+
+// buildPlan filters events and works out the PINs to create, update and expire
+// for cal. A calendar that has not been saved has no existing PINs.
+func (s *SyncService) buildPlan(ctx context.Context, cal *models.CalendarSubscription, events []models.CalendarEvent, lockIDs []string) (*SyncPlan, error) {
+	plan := &SyncPlan{
+		CalendarID: cal.ID,
+		Summary: models.CalendarSyncResult{
+			CalendarID:   cal.ID,
+			CalendarName: cal.Name,
+			EventsFound:  len(events),
+			SyncedAt:     time.Now().UTC(),
+		},
+		Create:         []PlannedPIN{},
+		Update:         []PlannedPIN{},
+		Expire:         []PlannedPIN{},
+		Cancel:         []PlannedPIN{},
+		PendingRemoval: []PlannedPIN{},
+		Link:           []PlannedPIN{},
+		reservations:   make(map[string]time.Time),
+		cancelled:      make(map[string]models.CalendarEvent),
+	}
+	summary := &plan.Summary
+
+	// Filter to future events only
+	now := time.Now().UTC()
+	events = FilterFutureEvents(events, now)
+
+	times := s.propertyTimesFor(cal)
+
+	filter, err := compileEventRules(cal.EventRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event rules: %w", err)
+	}
+	for _, reason := range filter.reasons() {
+		summary.TrackSkipReason(reason)
+	}
+	gen, err := s.generatorFor(cal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PIN generation settings: %w", err)
+	}
+
+	// Keep only guest reservations: cancelled events are set aside, the
+	// platform adapter drops blocks, then the calendar's include/exclude rules
+	// apply. Skips are counted per reason.
+	adapter := AdapterFor(cal.Platform)
+	reservations := events[:0]
+	for _, event := range events {
+		if event.IsCancelled() {
+			plan.cancelled[event.Key()] = event
+			continue
+		}
+		ok, reason := adapter.Classify(&event)
+		if ok {
+			ok, reason = filter.evaluate(event, times.location)
+		}
+		if !ok {
+			summary.Skip(reason)
+			continue
+		}
+		reservations = append(reservations, event)
+		plan.reservations[event.Key()] = times.applyCheckoutTime(event.End, event.AllDay)
+	}
+	events = reservations
+
+	alloc := newSlotAllocator(s.guestPINRepo, s.lockRepo)
+	stays := s.newStayMatcher(cal, lockIDs, times)
+
+	// Expirations are planned first so their PINs are not reported as conflicts
+	skipConflicts, err := s.planExpirations(ctx, plan, alloc, cal, events)
+	if err != nil {
+		return nil, err
+	}
+	cancelledPINs := make(map[string]bool)
+	for _, p := range plan.Cancel {
+		cancelledPINs[p.EventUID] = true
+	}
+	for key := range plan.cancelled {
+		if !cancelledPINs[key] {
+			summary.Skip(SkipReasonCancelled)
+		}
+	}
+
+	for _, event := range events {
+		if err := s.planEvent(ctx, plan, alloc, stays, gen, cal.ID, event, lockIDs, times); err != nil {
+			log.Printf("Error planning event %s: %v", event.Key(), err)
+		}
+	}
+
+	if err := s.planConflicts(ctx, plan, skipConflicts); err != nil {
+		return nil, err
+	}
+
+	summary.PINsCreated = len(plan.Create)
+	summary.PINsUpdated = len(plan.Update)
+	summary.PINsRemoved = len(plan.Expire)
+	summary.Cancellations = len(plan.Cancel)
+	summary.PINsLinked = len(plan.Link)
+	for _, p := range plan.Create {
+		if p.LinkedPINID != "" {
+			summary.PINsLinked++
+		}
+	}
+	for _, p := range plan.Update {
+		if p.LinkedPINID != "" && p.hasChange(changeLink) {
+			summary.PINsLinked++
+		}
+	}
+	for _, list := range [][]PlannedPIN{plan.Create, plan.Update} {
+		for _, p := range list {
+			if p.ReplacedPINCode != "" {
+				summary.ConflictsResolved++
+			}
+		}
+	}
+	for _, p := range plan.PendingRemoval {
+		summary.PendingRemoval = append(summary.PendingRemoval, models.PendingRemoval{
+			GuestPINID:   p.GuestPINID,
+			EventUID:     p.EventUID,
+			EventSummary: p.EventSummary,
+			Status:       p.Status,
+			MissingSince: *p.MissingSince,
+			MissingCount: p.MissingCount,
+		})
+	}
+
+	return plan, nil
+}
+
+// planEvent adds the create or update, if any, that event needs. A PIN
+// whose stay another calendar's PIN already covers is linked to it instead of
+// being given lock slots.
+func (s *SyncService) planEvent(ctx context.Context, plan *SyncPlan, alloc *slotAllocator, stays *stayMatcher, gen *pin.Generator, calendarID string, event models.CalendarEvent, lockIDs []string, times propertyTimes) error {
+	var existing *models.GuestPIN
+	if calendarID != "" {
+		var err error
+		existing, err = s.guestPINRepo.GetByEventUID(ctx, calendarID, event.Key())
+		if err != nil {
+			return fmt.Errorf("checking existing PIN: %w", err)
+		}
+	}
+	if existing != nil && existing.IsStale(event) {
+		plan.Summary.Skip(SkipReasonStale)
+		return nil
+	}
+
+	// Calculate validity window with check-in/check-out times
+	validFrom := times.applyCheckinTime(event.Start, event.AllDay)
+	validUntil := times.applyCheckoutTime(event.End, event.AllDay)
+
+	if existing != nil {
+		datesChanged := !existing.ValidFrom.Equal(validFrom) || !existing.ValidUntil.Equal(validUntil)
+		detailsChanged := applyReservationDetails(existing, event)
+		existing.ValidFrom = validFrom
+		existing.ValidUntil = validUntil
+
+		primary, mirrors, err := stays.match(ctx, existing)
+		if err != nil {
+			return fmt.Errorf("matching stays: %w", err)
+		}
+		plan.link(existing, mirrors)
+
+		linkChanged := false
+		switch {
+		case primary != nil && (existing.LinkedPINID == nil || *existing.LinkedPINID != primary.ID):
+			existing.LinkedPINID = &primary.ID
+			linkChanged = true
+		case primary == nil && existing.IsLinked():
+			// The PIN it mirrored is gone, so this one is programmed again
+			existing.LinkedPINID = nil
+			linkChanged = true
+		}
+
+		// A PIN in conflict status is planned either way so its code is checked again
+		if !datesChanged && !detailsChanged && !linkChanged && existing.Status != models.PINStatusConflict {
+			return nil
+		}
+
+		var changes []string
+		if datesChanged {
+			changes = append(changes, changeDates)
+		}
+		if detailsChanged {
+			changes = append(changes, changeReservationDetails)
+		}
+		if linkChanged {
+			changes = append(changes, changeLink)
+		}
+
+		existing.EventSummary = &event.Summary
+		existing.SetEventRevision(event)
+
+		// Regenerate PIN if its code is tied to the stay and dates changed
+		if datesChanged && pin.DependsOnStay(existing.GenerationMethod) {
+			taken, err := s.takenCodes(ctx, plan, gen, lockIDs, existing.ID)
+			if err != nil {
+				return err
+			}
+			result := gen.GenerateFromEvent(event, "", taken)
+			if result.Success && result.PINCode != existing.PINCode {
+				existing.PINCode = result.PINCode
+				existing.GenerationMethod = result.Method
+				changes = append(changes, changePINCode)
+			}
+		}
+
+		var locks []PlannedLockSlot
+		switch {
+		case existing.IsLinked():
+			locks = []PlannedLockSlot{}
+		case linkChanged:
+			locks, err = s.allocateLocks(ctx, alloc, lockIDs, validFrom, validUntil, mirrors)
+		default:
+			locks, err = s.assignedLocks(ctx, alloc, existing.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		planned := plannedFromPIN(existing, locks)
+		planned.Changes = changes
+		plan.Update = append(plan.Update, planned)
+		return nil
+	}
+
+	// Generate new PIN
+	taken, err := s.takenCodes(ctx, plan, gen, lockIDs, "")
+	if err != nil {
+		return err
+	}
+	result := gen.GenerateFromEvent(event, "", taken)
+	if !result.Success {
+		plan.Summary.Skip(SkipReasonNoPIN)
+		return nil
+	}
+
+	guestPIN := &models.GuestPIN{
+		CalendarID:           calendarID,
+		EventUID:             event.Key(),
+		EventSummary:         &event.Summary,
+		PINCode:              result.PINCode,
+		GenerationMethod:     result.Method,
+		ValidFrom:            validFrom,
+		ValidUntil:           validUntil,
+		Status:               models.PINStatusPending,
+		RegenerationEligible: true,
+	}
+	applyReservationDetails(guestPIN, event)
+	guestPIN.SetEventRevision(event)
+
+	// Check if PIN should be active now
+	if guestPIN.IsActive(time.Now().UTC()) {
+		guestPIN.Status = models.PINStatusActive
+	}
+
+	primary, mirrors, err := stays.match(ctx, guestPIN)
+	if err != nil {
+		return fmt.Errorf("matching stays: %w", err)
+	}
+	if primary != nil {
+		guestPIN.LinkedPINID = &primary.ID
+		plan.Create = append(plan.Create, plannedFromPIN(guestPIN, []PlannedLockSlot{}))
+		return nil
+	}
+
+	locks, err := s.allocateLocks(ctx, alloc, lockIDs, validFrom, validUntil, mirrors)
+	if err != nil {
+		return err
+	}
+
+	plan.Create = append(plan.Create, plannedFromPIN(guestPIN, locks))
+	plan.link(guestPIN, mirrors)
+	return nil
+}
+
+// allocateLocks picks a slot on each lock for a PIN valid from validFrom to
+// validUntil. Where one of replaced, the PINs it takes over a stay from,
+// holds a slot on the lock, that slot is reused.
+func (s *SyncService) allocateLocks(ctx context.Context, alloc *slotAllocator, lockIDs []string, validFrom, validUntil time.Time, replaced []models.GuestPIN) ([]PlannedLockSlot, error) {
+	inherited, err := s.takeOverSlots(ctx, replaced)
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]PlannedLockSlot, 0, len(lockIDs))
+	for _, lockID := range lockIDs {
+		slot := PlannedLockSlot{LockID: lockID}
+		if l, err := alloc.lock(ctx, lockID); err == nil {
+			slot.LockName = l.Name
+		}
+		if n, ok := inherited[lockID]; ok {
+			slot.SlotNumber = n
+		} else if n, err := alloc.allocate(ctx, lockID, validFrom, validUntil); err != nil {
+			slot.Error = err.Error()
+		} else {
+			slot.SlotNumber = n
+		}
+		locks = append(locks, slot)
+	}
+
+	return locks, nil
+}
+
+// link plans linking mirrors, PINs of other calendars, to primary. A PIN is
+// linked at most once per plan.
+func (plan *SyncPlan) link(primary *models.GuestPIN, mirrors []models.GuestPIN) {
+	for i := range mirrors {
+		mirror := &mirrors[i]
+		planned := false
+		for _, l := range plan.Link {
+			if l.GuestPINID == mirror.ID {
+				planned = true
+				break
+			}
+		}
+		if planned {
+			continue
+		}
+
+		p := plannedFromPIN(mirror, []PlannedLockSlot{})
+		p.LinkedPINID = primary.ID
+		p.linkTo = primary
+		plan.Link = append(plan.Link, p)
+	}
+}
+
+// planExpirations handles live PINs whose events are no longer in the calendar.
+// Each miss is recorded on the PIN; the PIN is expired once removalDue says the
+// grace period is over. PINs of cancelled events are expired at once, unless
+// the cancellation is older than the revision the PIN was built from. It
+// returns the IDs of the PINs being expired.
+func (s *SyncService) planExpirations(ctx context.Context, plan *SyncPlan, alloc *slotAllocator, cal *models.CalendarSubscription, currentEvents []models.CalendarEvent) (map[string]bool, error) {
+	expiring := make(map[string]bool)
+	if cal.ID == "" {
+		return expiring, nil
+	}
+
+	pins, err := s.guestPINRepo.ListByCalendar(ctx, cal.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing PINs: %w", err)
+	}
+
+	// Build set of current event keys (UID plus recurrence ID for recurring instances)
+	currentUIDs := make(map[string]bool)
+	for _, e := range currentEvents {
+		currentUIDs[e.Key()] = true
+	}
+
+	now := time.Now().UTC()
+	for i := range pins {
+		p := &pins[i]
+		if currentUIDs[p.EventUID] {
+			if p.MissingSince != nil || p.MissingCount > 0 {
+				plan.returned = append(plan.returned, p.ID)
+			}
+			continue
+		}
+		if p.Status == models.PINStatusExpired {
+			continue
+		}
+
+		event, cancelled := plan.cancelled[p.EventUID]
+		if cancelled && p.IsStale(event) {
+			// The PIN was rebooked after this cancellation; keep it
+			plan.Summary.Skip(SkipReasonStale)
+			delete(plan.cancelled, p.EventUID)
+			plan.reservations[p.EventUID] = p.ValidUntil
+			continue
+		}
+
+		locks, err := s.assignedLocks(ctx, alloc, p.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if cancelled {
+			p.Status = models.PINStatusExpired
+			plan.Cancel = append(plan.Cancel, plannedFromPIN(p, locks))
+			expiring[p.ID] = true
+			continue
+		}
+
+		since := now
+		if p.MissingSince != nil {
+			since = *p.MissingSince
+		}
+		count := p.MissingCount + 1
+
+		if !removalDue(cal, p, since, count, now) {
+			p.MissingSince = &since
+			p.MissingCount = count
+			plan.PendingRemoval = append(plan.PendingRemoval, plannedFromPIN(p, locks))
+			continue
+		}
+
+		p.Status = models.PINStatusExpired
+		plan.Expire = append(plan.Expire, plannedFromPIN(p, locks))
+		expiring[p.ID] = true
+	}
+
+	return expiring, nil
+}
+
+// removalDue reports whether the PIN of an event missing for count syncs since
+// since has outlived the calendar's removal grace. A PIN that is not yet in use
+// goes once either limit is reached; a PIN whose stay is under way must reach
+// both, so one bad fetch never locks out a guest mid-stay. A limit of 0 is
+// disabled, and with both disabled PINs expire on the first miss.
+func removalDue(cal *models.CalendarSubscription, p *models.GuestPIN, since time.Time, count int, now time.Time) bool {
+	syncsSet := cal.RemovalGraceSyncs > 0
+	minutesSet := cal.RemovalGraceMin > 0
+	if !syncsSet && !minutesSet {
+		return true
+	}
+
+	syncsMet := syncsSet && count >= cal.RemovalGraceSyncs
+	minutesMet := minutesSet && now.Sub(since) >= time.Duration(cal.RemovalGraceMin)*time.Minute
+
+	if p.IsActive(now) {
+		return (syncsMet || !syncsSet) && (minutesMet || !minutesSet)
+	}
+	return syncsMet || minutesMet
+}
+
+// planConflicts checks every planned create and update that will be programmed
+// for a code another PIN uses on one of its locks while both are valid. Saved
+// and static PINs the plan leaves alone come from the database; PINs the plan
+// creates or moves are compared here. Under the auto_resolve policy generated
+// codes are replaced with an alternative; otherwise, or if none is free, the
+// PIN is set to conflict status. A PIN in conflict status whose code is free
+// again gets its status back.
+func (s *SyncService) planConflicts(ctx context.Context, plan *SyncPlan, skip map[string]bool) error {
+	var planned []*PlannedPIN
+	for i := range plan.Create {
+		if plan.Create[i].LinkedPINID == "" {
+			planned = append(planned, &plan.Create[i])
+		}
+	}
+	for i := range plan.Update {
+		skip[plan.Update[i].GuestPINID] = true
+		if plan.Update[i].LinkedPINID == "" {
+			planned = append(planned, &plan.Update[i])
+		}
+	}
+	// Linked PINs are not programmed, so they cannot conflict
+	for _, l := range plan.Link {
+		skip[l.GuestPINID] = true
+	}
+
+	s.settingsMu.RLock()
+	autoResolve := s.conflictPolicy == pin.ConflictPolicyAutoResolve
+	s.settingsMu.RUnlock()
+
+	now := time.Now().UTC()
+	for _, p := range planned {
+		lockIDs := p.lockIDs()
+		conflicts, err := s.plannedConflicts(ctx, p, p.PINCode, lockIDs, planned, skip)
+		if err != nil {
+			return err
+		}
+		p.Conflicts = conflicts
+
+		if len(conflicts) > 0 && autoResolve &&
+			p.GenerationMethod != models.GenerationMethodCustom {
+			taken := func(code string) bool {
+				found, _ := s.plannedConflicts(ctx, p, code, lockIDs, planned, nil)
+				return len(found) > 0
+			}
+			code, err := s.checker().FindAlternativePIN(ctx, p.PINCode, lockIDs, p.ValidFrom, p.ValidUntil, p.GuestPINID, taken, maxAlternativeAttempts)
+			if err != nil {
+				log.Printf("No alternative to conflicting PIN for event %s: %v", p.EventUID, err)
+			} else {
+				p.ReplacedPINCode = p.PINCode
+				p.PINCode = code
+				p.guestPIN.PINCode = code
+				if p.GuestPINID != "" && !p.hasChange(changePINCode) {
+					p.Changes = append(p.Changes, changePINCode)
+				}
+				conflicts = nil
+			}
+		}
+
+		switch {
+		case len(conflicts) > 0 && p.Status != models.PINStatusConflict:
+			p.setStatus(models.PINStatusConflict)
+		case len(conflicts) == 0 && p.Status == models.PINStatusConflict:
+			p.setStatus(statusAt(p.guestPIN, now))
+		}
+		if p.Status == models.PINStatusConflict {
+			plan.conflicted = true
+		}
+	}
+
+	// PINs kept in conflict status are only planned to be rechecked
+	updates := plan.Update[:0]
+	for _, p := range plan.Update {
+		if len(p.Changes) > 0 {
+			updates = append(updates, p)
+		}
+	}
+	plan.Update = updates
+
+	return nil
+}
+
+// plannedConflicts returns the conflicts p would have with code on its locks:
+// those with saved PINs not in skip, found in the database, and those with
+// the other PINs of the plan. With a nil skip, only the plan is checked.
+func (s *SyncService) plannedConflicts(ctx context.Context, p *PlannedPIN, code string, lockIDs []string, planned []*PlannedPIN, skip map[string]bool) ([]pin.Conflict, error) {
+	var conflicts []pin.Conflict
+	if skip != nil {
+		found, err := s.checker().CheckConflicts(ctx, code, lockIDs, p.ValidFrom, p.ValidUntil, p.GuestPINID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range found {
+			if c.ConflictingType == pin.ConflictTypeStatic || !skip[c.ConflictingPIN] {
+				conflicts = append(conflicts, c)
+			}
+		}
+	}
+
+	policy := s.checker().Policy()
+	for _, other := range planned {
+		if other == p || other.Status == models.PINStatusConflict {
+			continue
+		}
+		reason := policy.Clash(code, other.PINCode)
+		if reason == "" {
+			continue
+		}
+		if !other.ValidFrom.Before(p.ValidUntil) || !other.ValidUntil.After(p.ValidFrom) {
+			continue
+		}
+		lockID := sharedLock(lockIDs, other.lockIDs())
+		if lockID == "" {
+			continue
+		}
+		overlapStart, overlapEnd := p.ValidFrom, p.ValidUntil
+		if other.ValidFrom.After(overlapStart) {
+			overlapStart = other.ValidFrom
+		}
+		if other.ValidUntil.Before(overlapEnd) {
+			overlapEnd = other.ValidUntil
+		}
+		conflicts = append(conflicts, pin.Conflict{
+			PINCode:          code,
+			LockID:           lockID,
+			ConflictingType:  pin.ConflictTypeGuest,
+			ConflictingPIN:   other.GuestPINID,
+			Reason:           reason,
+			ConflictingEvent: other.EventUID,
+			EventSummary:     other.EventSummary,
+			OverlapStart:     overlapStart,
+			OverlapEnd:       overlapEnd,
+		})
+	}
+
+	return conflicts, nil
+}
+
+// sharedLock returns a lock in both a and b, or "" if there is none.
+func sharedLock(a, b []string) string {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return x
+			}
+		}
+	}
+	return ""
+}
+
+// lockIDs returns the locks a planned PIN is assigned a slot on.
+func (p *PlannedPIN) lockIDs() []string {
+	ids := make([]string, 0, len(p.Locks))
+	for _, l := range p.Locks {
+		if l.Error == "" {
+			ids = append(ids, l.LockID)
+		}
+	}
+	return ids
+}
+
+// setStatus changes the status a planned PIN will be saved with.
+func (p *PlannedPIN) setStatus(status string) {
+	p.Status = status
+	p.guestPIN.Status = status
+	if p.GuestPINID != "" && !p.hasChange(changeStatus) {
+		p.Changes = append(p.Changes, changeStatus)
+	}
+}
+
+// assignedLocks returns the current lock slots of a saved PIN.
+func (s *SyncService) assignedLocks(ctx context.Context, alloc *slotAllocator, guestPINID string) ([]PlannedLockSlot, error) {
+	assignments, err := s.guestPINRepo.GetLockAssignments(ctx, guestPINID)
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]PlannedLockSlot, 0, len(assignments))
+	for _, a := range assignments {
+		if a.SyncStatus == models.LockSyncRemoved {
+			continue
+		}
+		slot := PlannedLockSlot{LockID: a.LockID, SlotNumber: a.SlotNumber}
+		if l, err := alloc.lock(ctx, a.LockID); err == nil {
+			slot.LockName = l.Name
+		}
+		locks = append(locks, slot)
+	}
+
+	return locks, nil
+}
+
+func plannedFromPIN(p *models.GuestPIN, locks []PlannedLockSlot) PlannedPIN {
+	summary := ""
+	if p.EventSummary != nil {
+		summary = *p.EventSummary
+	}
+	linked := ""
+	if p.LinkedPINID != nil {
+		linked = *p.LinkedPINID
+	}
+
+	return PlannedPIN{
+		GuestPINID:       p.ID,
+		EventUID:         p.EventUID,
+		EventSummary:     summary,
+		PINCode:          p.PINCode,
+		GenerationMethod: p.GenerationMethod,
+		ValidFrom:        p.ValidFrom,
+		ValidUntil:       p.ValidUntil,
+		Status:           p.Status,
+		MissingSince:     p.MissingSince,
+		MissingCount:     p.MissingCount,
+		Locks:            locks,
+		LinkedPINID:      linked,
+		guestPIN:         p,
+	}
+}
+
+// hasChange reports whether an update changes field.
+func (p *PlannedPIN) hasChange(field string) bool {
+	for _, c := range p.Changes {
+		if c == field {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPlan writes a plan and counts the PINs actually changed into result.
+// Failures are logged and skipped so one bad row does not abort the sync.
+func (s *SyncService) applyPlan(ctx context.Context, plan *SyncPlan, result *models.CalendarSyncResult) {
+	var saved []PlannedPIN
+	for _, p := range plan.Create {
+		if err := s.guestPINRepo.Create(ctx, p.guestPIN); err != nil {
+			log.Printf("Error creating PIN for event %s: %v", p.EventUID, err)
+			continue
+		}
+		saved = append(saved, p)
+		result.PINsCreated++
+		if p.guestPIN.IsLinked() {
+			result.PINsLinked++
+		}
+
+		for _, l := range p.Locks {
+			if l.Error != "" {
+				log.Printf("Not assigning PIN %s to lock %s: %s", p.guestPIN.ID, l.LockID, l.Error)
+				continue
+			}
+			if err := s.guestPINRepo.AssignToLock(ctx, p.guestPIN.ID, l.LockID, l.SlotNumber); err != nil {
+				log.Printf("Failed to assign PIN to lock %s: %v", l.LockID, err)
+			}
+		}
+	}
+
+	for _, p := range plan.Update {
+		if err := s.guestPINRepo.Update(ctx, p.guestPIN); err != nil {
+			log.Printf("Error updating PIN %s: %v", p.GuestPINID, err)
+			continue
+		}
+		saved = append(saved, p)
+		result.PINsUpdated++
+		if p.hasChange(changeDates) {
+			s.recheckLinked(ctx, p.GuestPINID)
+		}
+		// New codes, and PINs out of conflict, are programmed again
+		if (p.hasChange(changePINCode) || p.hasChange(changeStatus)) &&
+			p.guestPIN.Status != models.PINStatusConflict && !p.guestPIN.IsLinked() {
+			if err := s.guestPINRepo.QueueLockSync(ctx, p.GuestPINID); err != nil {
+				log.Printf("Failed to queue lock sync for PIN %s: %v", p.GuestPINID, err)
+			}
+		}
+
+		if !p.hasChange(changeLink) {
+			continue
+		}
+		if p.guestPIN.IsLinked() {
+			if err := s.guestPINRepo.Link(ctx, p.GuestPINID, *p.guestPIN.LinkedPINID); err != nil {
+				log.Printf("Failed to link PIN %s: %v", p.GuestPINID, err)
+				continue
+			}
+			result.PINsLinked++
+			continue
+		}
+		for _, l := range p.Locks {
+			if l.Error != "" {
+				log.Printf("Not assigning PIN %s to lock %s: %s", p.GuestPINID, l.LockID, l.Error)
+				continue
+			}
+			if err := s.guestPINRepo.AssignToLock(ctx, p.GuestPINID, l.LockID, l.SlotNumber); err != nil {
+				log.Printf("Failed to assign PIN to lock %s: %v", l.LockID, err)
+			}
+		}
+	}
+
+	// Conflicts are reported once the PINs on both sides are saved
+	createdIDs := make(map[string]string) // Event key -> ID of a PIN created by the plan
+	for _, p := range plan.Create {
+		createdIDs[p.EventUID] = p.guestPIN.ID
+	}
+	for _, p := range saved {
+		if p.ReplacedPINCode != "" {
+			result.ConflictsResolved++
+		}
+		if p.guestPIN.Status != models.PINStatusConflict {
+			continue
+		}
+		for _, c := range p.Conflicts {
+			if c.ConflictingPIN == "" {
+				c.ConflictingPIN = createdIDs[c.ConflictingEvent]
+			}
+			result.Conflicts = append(result.Conflicts, conflictRecord(p.guestPIN, c))
+		}
+	}
+
+	// Links go last, once the PINs they point at are saved
+	for _, p := range plan.Link {
+		if p.linkTo.ID == "" {
+			continue
+		}
+		if err := s.guestPINRepo.Link(ctx, p.GuestPINID, p.linkTo.ID); err != nil {
+			log.Printf("Failed to link PIN %s: %v", p.GuestPINID, err)
+			continue
+		}
+		result.PINsLinked++
+	}
+
+	for _, p := range plan.Expire {
+		if err := s.guestPINRepo.UpdateStatus(ctx, p.GuestPINID, models.PINStatusExpired); err != nil {
+			log.Printf("Failed to expire PIN %s: %v", p.GuestPINID, err)
+			continue
+		}
+		result.PINsRemoved++
+		s.recheckLinked(ctx, p.GuestPINID)
+	}
+
+	// Cancelled stays also have their codes cleared from the locks
+	for _, p := range plan.Cancel {
+		if err := s.guestPINRepo.UpdateStatus(ctx, p.GuestPINID, models.PINStatusExpired); err != nil {
+			log.Printf("Failed to expire cancelled PIN %s: %v", p.GuestPINID, err)
+			continue
+		}
+		if err := s.guestPINRepo.QueueLockSync(ctx, p.GuestPINID); err != nil {
+			log.Printf("Failed to clear cancelled PIN %s from locks: %v", p.GuestPINID, err)
+		}
+		result.Cancellations++
+		s.recheckLinked(ctx, p.GuestPINID)
+	}
+
+	for _, p := range plan.PendingRemoval {
+		if err := s.guestPINRepo.UpdateMissing(ctx, p.GuestPINID, p.MissingSince, p.MissingCount); err != nil {
+			log.Printf("Failed to record missing event for PIN %s: %v", p.GuestPINID, err)
+		}
+	}
+
+	for _, id := range plan.returned {
+		if err := s.guestPINRepo.UpdateMissing(ctx, id, nil, 0); err != nil {
+			log.Printf("Failed to clear missing event for PIN %s: %v", id, err)
+		}
+	}
+}
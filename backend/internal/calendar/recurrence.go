@@ -0,0 +1,308 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRecurrencePeriods bounds rule expansion so a malformed or very old
+// unbounded series cannot stall a sync.
+const maxRecurrencePeriods = 10000
+
+// weekdayNum is a BYDAY entry such as "MO" (every Monday) or "-1SU" (last Sunday).
+type weekdayNum struct {
+	ordinal int
+	weekday time.Weekday
+}
+
+// recurrenceRule is a parsed RRULE.
+// Supported parts: FREQ (DAILY/WEEKLY/MONTHLY/YEARLY), INTERVAL, COUNT, UNTIL,
+// BYDAY, BYMONTHDAY, BYMONTH and WKST.
+type recurrenceRule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []time.Month
+	weekStart  time.Weekday
+}
+
+// parseRecurrenceRule parses an RRULE value. UNTIL is interpreted with the
+// same resolver as the event so date-only and floating values line up with DTSTART.
+func parseRecurrenceRule(value string, tz *timezoneResolver) (*recurrenceRule, error) {
+	parts := parseRuleParts(value)
+
+	rule := &recurrenceRule{
+		freq:      parts["FREQ"],
+		interval:  1,
+		weekStart: time.Monday,
+	}
+
+	switch rule.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	case "":
+		return nil, fmt.Errorf("RRULE missing FREQ")
+	default:
+		return nil, fmt.Errorf("unsupported RRULE frequency %q", rule.freq)
+	}
+
+	if v, ok := parts["INTERVAL"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid RRULE INTERVAL %q", v)
+		}
+		rule.interval = n
+	}
+
+	if v, ok := parts["COUNT"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid RRULE COUNT %q", v)
+		}
+		rule.count = n
+	}
+
+	if v, ok := parts["UNTIL"]; ok {
+		t, _, err := tz.parseValue(v, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE UNTIL: %w", err)
+		}
+		rule.until = t
+	}
+
+	if v, ok := parts["BYDAY"]; ok {
+		for _, entry := range strings.Split(v, ",") {
+			ordinal, weekday, ok := parseByDay(entry)
+			if !ok {
+				return nil, fmt.Errorf("invalid RRULE BYDAY %q", entry)
+			}
+			rule.byDay = append(rule.byDay, weekdayNum{ordinal: ordinal, weekday: weekday})
+		}
+	}
+
+	if v, ok := parts["BYMONTHDAY"]; ok {
+		for _, entry := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(entry)
+			if err != nil || n == 0 || n < -31 || n > 31 {
+				return nil, fmt.Errorf("invalid RRULE BYMONTHDAY %q", entry)
+			}
+			rule.byMonthDay = append(rule.byMonthDay, n)
+		}
+	}
+
+	if v, ok := parts["BYMONTH"]; ok {
+		for _, entry := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(entry)
+			if err != nil || n < 1 || n > 12 {
+				return nil, fmt.Errorf("invalid RRULE BYMONTH %q", entry)
+			}
+			rule.byMonth = append(rule.byMonth, time.Month(n))
+		}
+	}
+
+	if v, ok := parts["WKST"]; ok {
+		if wd, ok := parseWeekday(v); ok {
+			rule.weekStart = wd
+		}
+	}
+
+	return rule, nil
+}
+
+// expand returns the wall-clock start times of the series that begin no later
+// than windowEnd. startWall carries DTSTART's wall-clock fields; localize turns
+// wall-clock fields into an instant in DTSTART's zone (so UNTIL can be compared
+// and DST is handled per occurrence).
+func (r *recurrenceRule) expand(startWall time.Time, localize func(time.Time) time.Time, windowEnd time.Time) []time.Time {
+	var out []time.Time
+	emitted := 0
+
+	emit := func(wall time.Time) bool {
+		instant := localize(wall)
+		if !r.until.IsZero() && instant.After(r.until) {
+			return false
+		}
+		if instant.After(windowEnd) {
+			return false
+		}
+		if r.count > 0 && emitted >= r.count {
+			return false
+		}
+		out = append(out, wall)
+		emitted++
+		return true
+	}
+
+	// DTSTART is always the first instance of the series
+	if !emit(startWall) {
+		return out
+	}
+
+	for period := 0; period < maxRecurrencePeriods; period++ {
+		candidates := r.periodCandidates(startWall, period)
+		for _, wall := range candidates {
+			if !wall.After(startWall) {
+				continue
+			}
+			if !emit(wall) {
+				return out
+			}
+		}
+	}
+
+	return out
+}
+
+// periodCandidates returns the sorted candidate wall-clock times for the nth period of the rule.
+func (r *recurrenceRule) periodCandidates(start time.Time, n int) []time.Time {
+	clock := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+	}
+
+	var days []time.Time
+	switch r.freq {
+	case "DAILY":
+		day := start.AddDate(0, 0, n*r.interval)
+		if r.matchesMonth(day.Month()) && r.matchesMonthDay(day) && r.matchesWeekday(day.Weekday()) {
+			days = append(days, day)
+		}
+
+	case "WEEKLY":
+		offset := (int(start.Weekday()) - int(r.weekStart) + 7) % 7
+		weekStart := start.AddDate(0, 0, -offset+n*7*r.interval)
+		if len(r.byDay) == 0 {
+			days = append(days, weekStart.AddDate(0, 0, offset))
+			break
+		}
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			if r.matchesWeekday(day.Weekday()) && r.matchesMonth(day.Month()) {
+				days = append(days, day)
+			}
+		}
+
+	case "MONTHLY":
+		first := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, n*r.interval, 0)
+		if !r.matchesMonth(first.Month()) {
+			break
+		}
+		for _, d := range r.daysInMonth(first.Year(), first.Month(), start.Day()) {
+			days = append(days, clock(first.Year(), first.Month(), d))
+		}
+
+	case "YEARLY":
+		year := start.Year() + n*r.interval
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []time.Month{start.Month()}
+		}
+		for _, m := range months {
+			for _, d := range r.daysInMonth(year, m, start.Day()) {
+				days = append(days, clock(year, m, d))
+			}
+		}
+	}
+
+	out := make([]time.Time, 0, len(days))
+	for _, d := range days {
+		out = append(out, clock(d.Year(), d.Month(), d.Day()))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+// daysInMonth applies BYMONTHDAY/BYDAY within a single month. Without either,
+// the DTSTART day is used and months lacking that day are skipped.
+func (r *recurrenceRule) daysInMonth(year int, month time.Month, defaultDay int) []int {
+	total := daysIn(year, month)
+	seen := make(map[int]bool)
+	var days []int
+
+	add := func(d int) {
+		if d >= 1 && d <= total && !seen[d] {
+			seen[d] = true
+			days = append(days, d)
+		}
+	}
+
+	switch {
+	case len(r.byMonthDay) > 0:
+		for _, md := range r.byMonthDay {
+			d := md
+			if md < 0 {
+				d = total + md + 1
+			}
+			if len(r.byDay) == 0 || r.matchesWeekday(time.Date(year, month, d, 0, 0, 0, 0, time.UTC).Weekday()) {
+				add(d)
+			}
+		}
+	case len(r.byDay) > 0:
+		for _, wd := range r.byDay {
+			if wd.ordinal != 0 {
+				add(nthWeekdayOfMonth(year, month, wd.weekday, wd.ordinal))
+				continue
+			}
+			for d := 1; d <= total; d++ {
+				if time.Date(year, month, d, 0, 0, 0, 0, time.UTC).Weekday() == wd.weekday {
+					add(d)
+				}
+			}
+		}
+	default:
+		add(defaultDay)
+	}
+
+	sort.Ints(days)
+	return days
+}
+
+func (r *recurrenceRule) matchesMonth(m time.Month) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, bm := range r.byMonth {
+		if bm == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recurrenceRule) matchesMonthDay(t time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	total := daysIn(t.Year(), t.Month())
+	for _, md := range r.byMonthDay {
+		if md == t.Day() || (md < 0 && total+md+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recurrenceRule) matchesWeekday(wd time.Weekday) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, d := range r.byDay {
+		if d.weekday == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// recurrenceKey formats an occurrence start for use as a RECURRENCE-ID key:
+// a UTC date-time, or a plain date for all-day series.
+func recurrenceKey(t time.Time, allDay bool) string {
+	if allDay {
+		return t.Format("20060102")
+	}
+	return t.UTC().Format("20060102T150405Z")
+}
@@ -0,0 +1,162 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/pin"
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// Errors returned by RegeneratePIN.
+var (
+	ErrPINNotFound       = errors.New("guest PIN not found")
+	ErrPINNotRegenerable = errors.New("guest PIN is not eligible for regeneration")
+	ErrNoAlternativePIN  = errors.New("no conflict-free PIN found")
+)
+
+// maxAlternativeAttempts bounds the search for a conflict-free variation once
+// every generation method has been tried.
+const maxAlternativeAttempts = 20
+
+// RegeneratePIN replaces a guest PIN's code with one from the next method in
+// its calendar's generation pipeline after its current one, skipping codes
+// that are unchanged or conflict with another PIN. Once the pipeline is
+// exhausted, variations of the last code are tried. The PIN's lock assignments are marked for syncing again;
+// the PIN's status and its assignments' states before regeneration are
+// returned so callers can program the new code and report the change.
+func (s *SyncService) RegeneratePIN(ctx context.Context, id string) (*models.GuestPIN, string, []models.GuestPINLock, error) {
+	// Syncs allocate slots and reassign codes under the same lock
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
+
+	p, err := s.guestPINRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if p == nil {
+		return nil, "", nil, ErrPINNotFound
+	}
+	if !p.CanRegenerate(time.Now().UTC()) {
+		return nil, "", nil, ErrPINNotRegenerable
+	}
+	previousStatus := p.Status
+
+	assignments, err := s.guestPINRepo.GetLockAssignments(ctx, p.ID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	live := assignments[:0]
+	lockIDs := make([]string, 0, len(assignments))
+	for _, a := range assignments {
+		if a.SyncStatus != models.LockSyncRemoved {
+			live = append(live, a)
+			lockIDs = append(lockIDs, a.LockID)
+		}
+	}
+
+	cal, gen, err := s.pinGenerator(ctx, p)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	event := s.storedEvent(ctx, p, cal)
+	current := func(code string) bool { return code == p.PINCode }
+	used, err := s.takenCodes(ctx, nil, gen, lockIDs, p.ID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	taken := func(code string) bool { return current(code) || (used != nil && used(code)) }
+
+	method := p.GenerationMethod
+	last := p.PINCode
+	var code string
+	for {
+		result := gen.RegeneratePIN(event, method, taken)
+		if !result.Success {
+			// No method fits the stored event; vary the last code tried
+			result = pin.GenerationResult{PINCode: last, Method: method}
+		}
+		if !current(result.PINCode) {
+			conflict, err := s.checker().HasConflict(ctx, result.PINCode, lockIDs, p.ValidFrom, p.ValidUntil, p.ID)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			if !conflict {
+				code, method = result.PINCode, result.Method
+				break
+			}
+		}
+		if result.Method == method {
+			// The pipeline is exhausted
+			code, err = s.checker().FindAlternativePIN(ctx, result.PINCode, lockIDs, p.ValidFrom, p.ValidUntil, p.ID, current, maxAlternativeAttempts)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("%w: %v", ErrNoAlternativePIN, err)
+			}
+			break
+		}
+		method, last = result.Method, result.PINCode
+	}
+
+	p.PINCode = code
+	p.GenerationMethod = method
+	p.CustomPIN = nil
+	if p.Status == models.PINStatusConflict {
+		// The new code is conflict-free, so the PIN is programmed again
+		p.Status = statusAt(p, time.Now().UTC())
+	}
+	if err := s.guestPINRepo.Update(ctx, p); err != nil {
+		return nil, "", nil, err
+	}
+	if err := s.guestPINRepo.QueueLockSync(ctx, p.ID); err != nil {
+		return nil, "", nil, err
+	}
+
+	log.Printf("Regenerated PIN %s using %s", p.ID, p.GenerationMethod)
+	return p, previousStatus, live, nil
+}
+
+// storedEvent rebuilds the calendar event a PIN was generated from. The
+// event is read from the calendar's latest feed snapshot when it is still
+// there; fields the snapshot lacks, or the whole event once snapshots are
+// pruned, come from what the PIN stored. cal is the PIN's calendar, or nil
+// if it could not be found.
+func (s *SyncService) storedEvent(ctx context.Context, p *models.GuestPIN, cal *models.CalendarSubscription) models.CalendarEvent {
+	var event models.CalendarEvent
+	if runIDs, err := s.syncRunRepo.ListSnapshotRunIDs(ctx, p.CalendarID); err != nil {
+		log.Printf("Failed to list snapshots of calendar %s: %v", p.CalendarID, err)
+	} else if len(runIDs) > 0 {
+		if _, events, err := s.snapshotEvents(ctx, p.CalendarID, runIDs[0]); err != nil {
+			log.Printf("Failed to read latest snapshot of calendar %s: %v", p.CalendarID, err)
+		} else {
+			event = events[p.EventUID]
+		}
+	}
+
+	if event.UID == "" {
+		event.UID = p.EventUID
+		location := s.defaultPropertyTimes().location
+		if cal != nil {
+			location = s.propertyTimesFor(cal).location
+		}
+		event.Start = p.ValidFrom.In(location)
+		event.End = p.ValidUntil.In(location)
+	}
+	if event.Summary == "" && p.EventSummary != nil {
+		event.Summary = *p.EventSummary
+	}
+	if event.PhoneLast4 == "" && p.GuestPhoneLast4 != nil {
+		event.PhoneLast4 = *p.GuestPhoneLast4
+	}
+	if event.ReservationCode == "" && p.ReservationCode != nil {
+		event.ReservationCode = *p.ReservationCode
+	}
+	if event.GuestName == "" && p.GuestName != nil {
+		event.GuestName = *p.GuestName
+	}
+
+	return event
+}
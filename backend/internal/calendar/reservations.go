@@ -0,0 +1,252 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// ReservationSource reads structured reservations from a channel manager.
+// Unlike iCal feeds, reservations carry the guest's phone number and an
+// explicit status.
+type ReservationSource interface {
+	// Reservations returns the reservations overlapping start to end.
+	Reservations(ctx context.Context, start, end time.Time) ([]models.Reservation, error)
+}
+
+// jsonReservationSource reads reservations from a JSON-over-HTTP API,
+// locating their fields with a ReservationMapping. The feed's fetch options
+// apply, so API tokens can be sent as header or query credentials.
+type jsonReservationSource struct {
+	parser  *Parser
+	url     string
+	opts    models.FetchOptions
+	mapping models.ReservationMapping
+}
+
+// NewJSONReservationSource returns a ReservationSource for the JSON API at
+// apiURL. http, https and file:// URLs are supported, like calendar feeds.
+func (p *Parser) NewJSONReservationSource(apiURL string, opts models.FetchOptions, mapping models.ReservationMapping) ReservationSource {
+	return &jsonReservationSource{parser: p, url: apiURL, opts: opts, mapping: mapping}
+}
+
+// ValidateReservationMapping checks that a mapping locates the fields every
+// reservation needs.
+func ValidateReservationMapping(mapping *models.ReservationMapping) error {
+	if mapping == nil {
+		return errors.New("a field mapping is required")
+	}
+	if mapping.ID == "" || mapping.CheckIn == "" || mapping.CheckOut == "" {
+		return errors.New("id, check_in and check_out paths are required")
+	}
+	if mapping.UnitID != "" && mapping.Unit == "" {
+		return errors.New("unit_id requires a unit path")
+	}
+	if (mapping.StartParam == "") != (mapping.EndParam == "") {
+		return errors.New("start_param and end_param must be set together")
+	}
+	return nil
+}
+
+func (s *jsonReservationSource) Reservations(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	apiURL := s.url
+	if s.mapping.StartParam != "" {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing reservation API URL: %w", err)
+		}
+		q := u.Query()
+		q.Set(s.mapping.StartParam, start.Format("2006-01-02"))
+		q.Set(s.mapping.EndParam, end.Format("2006-01-02"))
+		u.RawQuery = q.Encode()
+		apiURL = u.String()
+	}
+
+	feed, err := s.parser.FetchConditional(apiURL, s.opts, FeedValidators{})
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(feed.Body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding reservations: %w", err)
+	}
+
+	items, ok := lookupPath(doc, s.mapping.ItemsPath).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no reservation array at %q", s.mapping.ItemsPath)
+	}
+
+	cancelled := s.mapping.CancelledStatuses
+	if len(cancelled) == 0 {
+		cancelled = models.DefaultCancelledStatuses
+	}
+
+	reservations := make([]models.Reservation, 0, len(items))
+	for i, item := range items {
+		res, err := s.reservation(item)
+		if err != nil {
+			log.Printf("Skipping reservation %d from %s: %v", i, s.mapping.ItemsPath, err)
+			continue
+		}
+		if s.mapping.UnitID != "" && res.Unit != s.mapping.UnitID {
+			continue
+		}
+		for _, status := range cancelled {
+			if strings.EqualFold(res.Status, status) {
+				res.Cancelled = true
+			}
+		}
+		reservations = append(reservations, res)
+	}
+
+	return reservations, nil
+}
+
+// reservation reads one reservation object using the mapping.
+func (s *jsonReservationSource) reservation(item interface{}) (models.Reservation, error) {
+	res := models.Reservation{
+		ID:        pathString(item, s.mapping.ID),
+		Status:    pathString(item, s.mapping.Status),
+		GuestName: pathString(item, s.mapping.GuestName),
+		Phone:     pathString(item, s.mapping.Phone),
+		Unit:      pathString(item, s.mapping.Unit),
+	}
+	if res.ID == "" {
+		return res, fmt.Errorf("no id at %q", s.mapping.ID)
+	}
+
+	var err error
+	var checkoutAllDay bool
+	if res.CheckIn, res.AllDay, err = parseReservationTime(pathString(item, s.mapping.CheckIn)); err != nil {
+		return res, fmt.Errorf("check-in: %w", err)
+	}
+	if res.CheckOut, checkoutAllDay, err = parseReservationTime(pathString(item, s.mapping.CheckOut)); err != nil {
+		return res, fmt.Errorf("check-out: %w", err)
+	}
+	res.AllDay = res.AllDay && checkoutAllDay
+
+	return res, nil
+}
+
+// parseReservationTime parses an RFC 3339 date-time or a YYYY-MM-DD date,
+// reporting whether it was a date. Dates are returned as UTC midnight.
+func parseReservationTime(value string) (time.Time, bool, error) {
+	if value == "" {
+		return time.Time{}, false, errors.New("missing")
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is not an RFC 3339 time or a date", value)
+	}
+	return t.UTC(), false, nil
+}
+
+// lookupPath follows a dot separated path of object keys from v.
+func lookupPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[key]
+	}
+	return v
+}
+
+// pathString returns the string or number at path, or "" if there is none.
+func pathString(v interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	switch value := lookupPath(v, path).(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case json.Number:
+		return value.String()
+	default:
+		return ""
+	}
+}
+
+// fetchReservations returns the reservations of a reservation API calendar
+// over the sync window, encoded as JSON so they can be snapshotted and
+// compared like any other feed.
+func (s *SyncService) fetchReservations(ctx context.Context, cal *models.CalendarSubscription) (*Feed, error) {
+	if err := ValidateReservationMapping(cal.ReservationMapping); err != nil {
+		return nil, fmt.Errorf("reservation API: %w", err)
+	}
+
+	source := s.parser.NewJSONReservationSource(cal.URL, cal.Fetch, *cal.ReservationMapping)
+	now := time.Now().UTC()
+	reservations, err := source.Reservations(ctx, now.Add(-queryLookback), now.Add(s.parser.recurrenceHorizon))
+	if err != nil {
+		return nil, err
+	}
+
+	// Order by ID so an unchanged API response hashes the same
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].ID < reservations[j].ID })
+	body, err := json.Marshal(reservations)
+	if err != nil {
+		return nil, fmt.Errorf("encoding reservations: %w", err)
+	}
+
+	feed := feedFromBody(body)
+	feed.StatusCode = http.StatusOK
+	feed.Format = models.FeedFormatReservations
+	return feed, nil
+}
+
+// decodeReservations maps reservations onto CalendarEvents. The reservation
+// fields are filled in directly, so platform adapters keep them. Cancelled
+// reservations become cancelled events, so their PINs are expired at once.
+func decodeReservations(body []byte) ([]models.CalendarEvent, error) {
+	var reservations []models.Reservation
+	if err := json.Unmarshal(body, &reservations); err != nil {
+		return nil, fmt.Errorf("decoding reservations: %w", err)
+	}
+
+	events := make([]models.CalendarEvent, 0, len(reservations))
+	for _, res := range reservations {
+		summary := res.GuestName
+		if summary == "" {
+			summary = "Reservation " + res.ID
+		}
+		status := models.EventStatusConfirmed
+		if res.Cancelled {
+			status = models.EventStatusCancelled
+		}
+		events = append(events, models.CalendarEvent{
+			UID:             res.ID,
+			Status:          status,
+			Summary:         summary,
+			Location:        res.Unit,
+			Start:           res.CheckIn,
+			End:             res.CheckOut,
+			AllDay:          res.AllDay,
+			ReservationCode: res.ID,
+			GuestName:       res.GuestName,
+			PhoneLast4:      lastFourDigits(res.Phone),
+		})
+	}
+
+	return events, nil
+}
@@ -0,0 +1,197 @@
+package calendar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// Skip reasons for stay-length limits and unmatched include rules.
+const (
+	SkipReasonNoIncludeMatch = "no_include_match"
+	SkipReasonMinStay        = "min_stay"
+	SkipReasonMaxStay        = "max_stay"
+)
+
+// eventFilter is a compiled models.EventRules.
+type eventFilter struct {
+	include       []compiledRule
+	exclude       []compiledRule
+	minStayNights int
+	maxStayNights int
+}
+
+// compiledRule is an EventRule with its pattern prepared for matching.
+type compiledRule struct {
+	key      string // Skip reason reported for this rule
+	field    string
+	contains string
+	regex    *regexp.Regexp
+}
+
+// ValidateEventRules checks that a rule set can be compiled.
+func ValidateEventRules(rules *models.EventRules) error {
+	_, err := compileEventRules(rules)
+	return err
+}
+
+// compileEventRules compiles a rule set. A nil or empty set yields a filter
+// that accepts every event.
+func compileEventRules(rules *models.EventRules) (*eventFilter, error) {
+	f := &eventFilter{}
+	if rules == nil {
+		return f, nil
+	}
+
+	if rules.MinStayNights < 0 || rules.MaxStayNights < 0 {
+		return nil, fmt.Errorf("stay lengths cannot be negative")
+	}
+	if rules.MaxStayNights > 0 && rules.MinStayNights > rules.MaxStayNights {
+		return nil, fmt.Errorf("min_stay_nights (%d) is greater than max_stay_nights (%d)", rules.MinStayNights, rules.MaxStayNights)
+	}
+	f.minStayNights = rules.MinStayNights
+	f.maxStayNights = rules.MaxStayNights
+
+	for i, r := range rules.Include {
+		c, err := compileRule("include", r)
+		if err != nil {
+			return nil, fmt.Errorf("include rule %d: %w", i+1, err)
+		}
+		f.include = append(f.include, c)
+	}
+	for i, r := range rules.Exclude {
+		c, err := compileRule("exclude", r)
+		if err != nil {
+			return nil, fmt.Errorf("exclude rule %d: %w", i+1, err)
+		}
+		f.exclude = append(f.exclude, c)
+	}
+
+	return f, nil
+}
+
+func compileRule(kind string, r models.EventRule) (compiledRule, error) {
+	c := compiledRule{field: strings.ToLower(r.Field)}
+
+	switch c.field {
+	case models.RuleFieldSummary, models.RuleFieldDescription, models.RuleFieldLocation:
+	default:
+		return c, fmt.Errorf("field must be summary, description or location, got %q", r.Field)
+	}
+
+	if r.Pattern == "" {
+		return c, fmt.Errorf("pattern is required")
+	}
+
+	switch strings.ToLower(r.Match) {
+	case models.RuleMatchContains, "":
+		c.contains = strings.ToLower(r.Pattern)
+	case models.RuleMatchRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid regex %q: %w", r.Pattern, err)
+		}
+		c.regex = re
+	default:
+		return c, fmt.Errorf("match must be contains or regex, got %q", r.Match)
+	}
+
+	name := r.Name
+	if name == "" {
+		match := r.Match
+		if match == "" {
+			match = models.RuleMatchContains
+		}
+		name = fmt.Sprintf("%s %s %q", c.field, match, r.Pattern)
+	}
+	c.key = kind + ": " + name
+
+	return c, nil
+}
+
+// matches reports whether the rule's field of event matches its pattern.
+func (c compiledRule) matches(event models.CalendarEvent) bool {
+	var value string
+	switch c.field {
+	case models.RuleFieldSummary:
+		value = event.Summary
+	case models.RuleFieldDescription:
+		value = event.Description
+	case models.RuleFieldLocation:
+		value = event.Location
+	}
+
+	if c.regex != nil {
+		return c.regex.MatchString(value)
+	}
+	return strings.Contains(strings.ToLower(value), c.contains)
+}
+
+// reasons returns every skip reason this filter can report, so sync results
+// list each rule even when it skipped nothing.
+func (f *eventFilter) reasons() []string {
+	var out []string
+	for _, c := range f.exclude {
+		out = append(out, c.key)
+	}
+	if len(f.include) > 0 {
+		out = append(out, SkipReasonNoIncludeMatch)
+	}
+	if f.minStayNights > 0 {
+		out = append(out, SkipReasonMinStay)
+	}
+	if f.maxStayNights > 0 {
+		out = append(out, SkipReasonMaxStay)
+	}
+	return out
+}
+
+// evaluate reports whether event passes the filter, or the reason it was skipped.
+// Exclude rules are checked first so an explicit exclusion always wins.
+func (f *eventFilter) evaluate(event models.CalendarEvent, loc *time.Location) (bool, string) {
+	for _, c := range f.exclude {
+		if c.matches(event) {
+			return false, c.key
+		}
+	}
+
+	if len(f.include) > 0 {
+		matched := false
+		for _, c := range f.include {
+			if c.matches(event) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, SkipReasonNoIncludeMatch
+		}
+	}
+
+	if f.minStayNights > 0 || f.maxStayNights > 0 {
+		nights := stayNights(event, loc)
+		if f.minStayNights > 0 && nights < f.minStayNights {
+			return false, SkipReasonMinStay
+		}
+		if f.maxStayNights > 0 && nights > f.maxStayNights {
+			return false, SkipReasonMaxStay
+		}
+	}
+
+	return true, ""
+}
+
+// stayNights counts the nights between an event's start and end dates in the
+// property's timezone (all-day dates are used as-is).
+func stayNights(event models.CalendarEvent, loc *time.Location) int {
+	start, end := event.Start, event.End
+	if !event.AllDay {
+		start, end = start.In(loc), end.In(loc)
+	}
+	startDate := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int(endDate.Sub(startDate).Hours() / 24)
+}
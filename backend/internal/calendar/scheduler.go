@@ -2,6 +2,7 @@ package calendar
 
 import (
 	"context"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -12,21 +13,47 @@ import (
 	"github.com/guest-lock-manager/backend/internal/websocket"
 )
 
-// Scheduler manages periodic calendar sync jobs.
+// Scheduler manages periodic calendar sync jobs. Each calendar has its own
+// timer, reset after every sync to a time that adapts to its upcoming
+// check-ins, recent changes and failures (see syncCadence).
 type Scheduler struct {
 	cron         *cron.Cron
 	syncService  *SyncService
 	calendarRepo *storage.CalendarRepository
 	broadcaster  *websocket.EventBroadcaster
-	
+
+	// Cancelled by Stop, ending lookups made for scheduling
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	// Track jobs per calendar
-	jobs   map[string]cron.EntryID
-	jobsMu sync.RWMutex
-	
+	jobs    map[string]*calendarJob
+	jobsMu  sync.RWMutex
+	stopped bool
+	running sync.WaitGroup // Syncs started by job and debounce timers
+
 	// Default sync interval if calendar doesn't specify
 	defaultInterval time.Duration
+
+	// Syncs requested by webhooks, waiting out the debounce window
+	pending   map[string]*time.Timer
+	pendingMu sync.Mutex
+}
+
+// calendarJob is the sync schedule of one calendar.
+type calendarJob struct {
+	name       string
+	interval   time.Duration
+	timer      *time.Timer
+	next       time.Time
+	failures   int       // Consecutive failed syncs
+	lastChange time.Time // Last sync that changed PINs
 }
 
+// syncDebounce is how long a requested sync waits for further requests, so a
+// burst of webhook calls for one booking change runs a single sync.
+const syncDebounce = 10 * time.Second
+
 // NewScheduler creates a new calendar sync scheduler.
 func NewScheduler(
 	syncService *SyncService,
@@ -43,12 +70,17 @@ func NewScheduler(
 		broadcaster = websocket.NewEventBroadcaster(hub)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Scheduler{
+		ctx:             ctx,
+		cancel:          cancel,
 		cron:            cron.New(cron.WithSeconds()),
 		syncService:     syncService,
 		calendarRepo:    calendarRepo,
 		broadcaster:     broadcaster,
-		jobs:            make(map[string]cron.EntryID),
+		jobs:            make(map[string]*calendarJob),
+		pending:         make(map[string]*time.Timer),
 		defaultInterval: time.Duration(defaultIntervalMin) * time.Minute,
 	}
 }
@@ -89,45 +121,157 @@ func (s *Scheduler) Stop() {
 	log.Println("Stopping calendar sync scheduler...")
 	ctx := s.cron.Stop()
 	<-ctx.Done()
+
+	s.jobsMu.Lock()
+	s.stopped = true
+	for _, job := range s.jobs {
+		job.timer.Stop()
+	}
+	s.jobsMu.Unlock()
+
+	s.pendingMu.Lock()
+	for calID, timer := range s.pending {
+		timer.Stop()
+		delete(s.pending, calID)
+	}
+	s.pendingMu.Unlock()
+
+	s.cancel()
+	s.running.Wait()
+
 	log.Println("Calendar scheduler stopped")
 }
 
-// ScheduleCalendar adds or updates a calendar's sync schedule.
+// ScheduleCalendar adds or updates a calendar's sync schedule. A new calendar
+// is due its interval after its last sync, or right away if it never synced.
+// An existing schedule keeps its next sync unless the interval changed.
 func (s *Scheduler) ScheduleCalendar(cal models.CalendarSubscription) {
 	if !cal.Enabled {
 		s.UnscheduleCalendar(cal.ID)
 		return
 	}
 
-	s.jobsMu.Lock()
-	defer s.jobsMu.Unlock()
-
-	// Remove existing job if any
-	if existingID, exists := s.jobs[cal.ID]; exists {
-		s.cron.Remove(existingID)
-		delete(s.jobs, cal.ID)
-	}
-
 	// Calculate interval
 	interval := time.Duration(cal.SyncIntervalMin) * time.Minute
 	if interval < time.Minute {
 		interval = s.defaultInterval
 	}
 
-	// Create cron expression: run every N minutes
-	spec := minutesToCronSpec(cal.SyncIntervalMin)
+	now := time.Now().UTC()
+	s.jobsMu.Lock()
+	job, exists := s.jobs[cal.ID]
+	if exists {
+		job.name = cal.Name
+		if job.interval == interval {
+			s.jobsMu.Unlock()
+			return
+		}
+		job.interval = interval
+	}
+	cadence := syncCadence{interval: interval}
+	if exists {
+		cadence.failures, cadence.lastChange = job.failures, job.lastChange
+	}
+	s.jobsMu.Unlock()
 
-	entryID, err := s.cron.AddFunc(spec, func() {
-		s.syncCalendar(cal.ID, cal.Name)
+	cadence.nextCheckin = s.nextCheckin(s.ctx, cal.ID, now)
+	next := now.Add(cadence.delay(now))
+	if !exists && cal.LastSyncAt != nil {
+		next = cal.LastSyncAt.Add(cadence.delay(*cal.LastSyncAt))
+	}
+	if !exists && cal.LastSyncAt == nil {
+		next = now
+	}
+
+	s.jobsMu.Lock()
+	if s.stopped {
+		s.jobsMu.Unlock()
+		return
+	}
+	if current, ok := s.jobs[cal.ID]; ok {
+		job = current
+		if job.next.Before(next) {
+			next = job.next
+		}
+		job.timer.Stop()
+	} else {
+		job = &calendarJob{name: cal.Name, interval: interval}
+		s.jobs[cal.ID] = job
+	}
+	s.setNext(cal.ID, job, next)
+	s.jobsMu.Unlock()
+
+	log.Printf("Scheduled calendar %s (%s) every %d minutes, next sync at %s", cal.ID, cal.Name, cal.SyncIntervalMin, next.Format(time.RFC3339))
+}
+
+// setNext arms a job's timer for next and records it. The caller holds jobsMu.
+func (s *Scheduler) setNext(calendarID string, job *calendarJob, next time.Time) {
+	job.next = next
+	job.timer = time.AfterFunc(time.Until(next), func() {
+		s.runJob(calendarID)
 	})
+	if err := s.calendarRepo.SetNextSyncAt(context.Background(), calendarID, &next); err != nil {
+		log.Printf("Failed to record next sync of calendar %s: %v", calendarID, err)
+	}
+}
 
-	if err != nil {
-		log.Printf("Failed to schedule calendar %s: %v", cal.ID, err)
+// runJob syncs a calendar when its timer fires.
+func (s *Scheduler) runJob(calendarID string) {
+	s.jobsMu.Lock()
+	job, exists := s.jobs[calendarID]
+	if !exists || s.stopped {
+		s.jobsMu.Unlock()
 		return
 	}
+	name := job.name
+	s.running.Add(1)
+	s.jobsMu.Unlock()
+	defer s.running.Done()
+
+	s.syncCalendar(calendarID, name)
+}
 
-	s.jobs[cal.ID] = entryID
-	log.Printf("Scheduled calendar %s (%s) every %d minutes", cal.ID, cal.Name, cal.SyncIntervalMin)
+// nextCheckin returns the next check-in of a calendar, logging failures.
+func (s *Scheduler) nextCheckin(ctx context.Context, calendarID string, now time.Time) *time.Time {
+	next, err := s.syncService.NextCheckin(ctx, calendarID, now)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Failed to find next check-in of calendar %s: %v", calendarID, err)
+	}
+	return next
+}
+
+// reschedule sets a calendar's next sync after a sync finished and returns
+// it, or nil if the calendar is not scheduled.
+func (s *Scheduler) reschedule(calendarID string, result *models.CalendarSyncResult, syncErr error) *time.Time {
+	now := time.Now().UTC()
+	nextCheckin := s.nextCheckin(s.ctx, calendarID, now)
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	job, exists := s.jobs[calendarID]
+	if !exists || s.stopped {
+		return nil
+	}
+
+	// An anomaly holds removals but the feed was fetched and other changes
+	// applied, so it keeps the normal cadence rather than backing off
+	var anomaly *AnomalyError
+	if syncErr != nil && !errors.As(syncErr, &anomaly) {
+		job.failures++
+	} else {
+		job.failures = 0
+	}
+	if result != nil && result.PINsCreated+result.PINsUpdated+result.PINsRemoved+result.PINsLinked+result.Cancellations > 0 {
+		job.lastChange = now
+	}
+
+	cadence := syncCadence{interval: job.interval, nextCheckin: nextCheckin, lastChange: job.lastChange, failures: job.failures}
+	job.timer.Stop()
+	s.setNext(calendarID, job, now.Add(cadence.delay(now)))
+
+	next := job.next
+	return &next
 }
 
 // UnscheduleCalendar removes a calendar from the sync schedule.
@@ -135,9 +279,12 @@ func (s *Scheduler) UnscheduleCalendar(calendarID string) {
 	s.jobsMu.Lock()
 	defer s.jobsMu.Unlock()
 
-	if entryID, exists := s.jobs[calendarID]; exists {
-		s.cron.Remove(entryID)
+	if job, exists := s.jobs[calendarID]; exists {
+		job.timer.Stop()
 		delete(s.jobs, calendarID)
+		if err := s.calendarRepo.SetNextSyncAt(context.Background(), calendarID, nil); err != nil {
+			log.Printf("Failed to clear next sync of calendar %s: %v", calendarID, err)
+		}
 		log.Printf("Unscheduled calendar %s", calendarID)
 	}
 }
@@ -155,16 +302,68 @@ func (s *Scheduler) TriggerSync(calendarID string) {
 	}()
 }
 
-// syncCalendar performs the actual sync operation.
+// RequestSync schedules a sync of a calendar after a short debounce window,
+// instead of waiting for its next interval. Requests made while one is
+// pending join it. It returns false if a sync was already pending or the
+// scheduler is stopped.
+func (s *Scheduler) RequestSync(calendarID string) bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	if _, exists := s.pending[calendarID]; exists {
+		return false
+	}
+	s.jobsMu.RLock()
+	stopped := s.stopped
+	s.jobsMu.RUnlock()
+	if stopped {
+		return false
+	}
+
+	s.pending[calendarID] = time.AfterFunc(syncDebounce, func() {
+		s.pendingMu.Lock()
+		delete(s.pending, calendarID)
+		s.pendingMu.Unlock()
+
+		// Stop waits for syncs that started before it
+		s.jobsMu.Lock()
+		if s.stopped {
+			s.jobsMu.Unlock()
+			return
+		}
+		s.running.Add(1)
+		s.jobsMu.Unlock()
+		defer s.running.Done()
+
+		cal, err := s.calendarRepo.GetByID(s.ctx, calendarID)
+		if err != nil || cal == nil {
+			log.Printf("Calendar not found for requested sync: %s", calendarID)
+			return
+		}
+		if !cal.Enabled {
+			log.Printf("Skipping requested sync of disabled calendar %s", calendarID)
+			return
+		}
+		s.syncCalendar(cal.ID, cal.Name)
+	})
+	return true
+}
+
+// syncCalendar performs the actual sync operation and schedules the next one.
 func (s *Scheduler) syncCalendar(calendarID, calendarName string) {
 	ctx := context.Background()
 	log.Printf("Syncing calendar: %s (%s)", calendarID, calendarName)
 
 	result, err := s.syncService.SyncCalendar(ctx, calendarID)
+	next := s.reschedule(calendarID, result, err)
 	if err != nil {
 		log.Printf("Calendar sync failed for %s: %v", calendarID, err)
 		if s.broadcaster != nil {
-			s.broadcaster.BroadcastCalendarSyncError(calendarID, calendarName, err)
+			var retryAt time.Time
+			if next != nil {
+				retryAt = *next
+			}
+			s.broadcaster.BroadcastCalendarSyncRetrying(calendarID, calendarName, err, retryAt)
 		}
 		return
 	}
@@ -172,6 +371,7 @@ func (s *Scheduler) syncCalendar(calendarID, calendarName string) {
 	log.Printf("Calendar sync completed for %s: %d events, %d PINs created, %d updated, %d removed",
 		calendarID, result.EventsFound, result.PINsCreated, result.PINsUpdated, result.PINsRemoved)
 
+	result.NextSyncAt = next
 	if s.broadcaster != nil {
 		s.broadcaster.BroadcastCalendarSyncCompleted(*result)
 	}
@@ -202,27 +402,19 @@ func (s *Scheduler) refreshSchedules(ctx context.Context) {
 
 	// Remove jobs for calendars that no longer exist or are disabled
 	s.jobsMu.Lock()
-	for calID := range s.jobs {
+	for calID, job := range s.jobs {
 		if !currentIDs[calID] {
-			s.cron.Remove(s.jobs[calID])
+			job.timer.Stop()
 			delete(s.jobs, calID)
+			if err := s.calendarRepo.SetNextSyncAt(ctx, calID, nil); err != nil {
+				log.Printf("Failed to clear next sync of calendar %s: %v", calID, err)
+			}
 			log.Printf("Removed schedule for calendar %s (no longer enabled)", calID)
 		}
 	}
 	s.jobsMu.Unlock()
 }
 
-// minutesToCronSpec converts minutes to a cron spec.
-func minutesToCronSpec(minutes int) string {
-	if minutes <= 0 {
-		minutes = 15
-	}
-
-	// Use @every syntax for the interval
-	duration := time.Duration(minutes) * time.Minute
-	return "@every " + duration.String()
-}
-
 // GetScheduledCalendars returns a list of currently scheduled calendar IDs.
 func (s *Scheduler) GetScheduledCalendars() []string {
 	s.jobsMu.RLock()
@@ -240,12 +432,9 @@ func (s *Scheduler) GetNextRun(calendarID string) *time.Time {
 	s.jobsMu.RLock()
 	defer s.jobsMu.RUnlock()
 
-	if entryID, exists := s.jobs[calendarID]; exists {
-		entry := s.cron.Entry(entryID)
-		if !entry.Next.IsZero() {
-			return &entry.Next
-		}
+	if job, exists := s.jobs[calendarID]; exists {
+		next := job.next
+		return &next
 	}
 	return nil
 }
-
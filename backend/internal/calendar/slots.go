@@ -0,0 +1,83 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/guest-lock-manager/backend/internal/storage"
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// slotAllocator picks guest slots on locks for one sync plan. Guest PINs use
+// the range after the static slots (StaticSlots+1 .. StaticSlots+GuestSlots);
+// a slot is free when no static PIN uses it and no overlapping guest PIN holds it.
+type slotAllocator struct {
+	guestPINRepo *storage.GuestPINRepository
+	lockRepo     *storage.LockRepository
+
+	locks    map[string]*models.ManagedLock
+	reserved map[string][]slotReservation // Allocations made earlier in the same plan
+}
+
+type slotReservation struct {
+	slot       int
+	validFrom  time.Time
+	validUntil time.Time
+}
+
+func newSlotAllocator(guestPINRepo *storage.GuestPINRepository, lockRepo *storage.LockRepository) *slotAllocator {
+	return &slotAllocator{
+		guestPINRepo: guestPINRepo,
+		lockRepo:     lockRepo,
+		locks:        make(map[string]*models.ManagedLock),
+		reserved:     make(map[string][]slotReservation),
+	}
+}
+
+// lock returns the lock with the given ID, cached for the life of the allocator.
+func (a *slotAllocator) lock(ctx context.Context, lockID string) (*models.ManagedLock, error) {
+	if l, ok := a.locks[lockID]; ok {
+		return l, nil
+	}
+	l, err := a.lockRepo.GetByID(ctx, lockID)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return nil, fmt.Errorf("lock not found: %s", lockID)
+	}
+	a.locks[lockID] = l
+	return l, nil
+}
+
+// allocate returns the lowest free guest slot on a lock for the given window.
+func (a *slotAllocator) allocate(ctx context.Context, lockID string, validFrom, validUntil time.Time) (int, error) {
+	l, err := a.lock(ctx, lockID)
+	if err != nil {
+		return 0, err
+	}
+	if l.GuestSlots <= 0 {
+		return 0, fmt.Errorf("lock %s has no guest slots", l.Name)
+	}
+
+	occupied, err := a.guestPINRepo.OccupiedSlots(ctx, lockID, validFrom, validUntil)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range a.reserved[lockID] {
+		if r.validFrom.Before(validUntil) && r.validUntil.After(validFrom) {
+			occupied[r.slot] = true
+		}
+	}
+
+	first := l.StaticSlots + 1
+	for slot := first; slot < first+l.GuestSlots; slot++ {
+		if !occupied[slot] {
+			a.reserved[lockID] = append(a.reserved[lockID], slotReservation{slot: slot, validFrom: validFrom, validUntil: validUntil})
+			return slot, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free guest slot on lock %s for %s to %s", l.Name, validFrom.Format(time.RFC3339), validUntil.Format(time.RFC3339))
+}
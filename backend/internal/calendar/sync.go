@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/guest-lock-manager/backend/internal/lock"
 	"github.com/guest-lock-manager/backend/internal/pin"
 	"github.com/guest-lock-manager/backend/internal/storage"
 	"github.com/guest-lock-manager/backend/internal/storage/models"
@@ -17,10 +19,28 @@ type SyncService struct {
 	calendarRepo *storage.CalendarRepository
 	guestPINRepo *storage.GuestPINRepository
 	lockRepo     *storage.LockRepository
+	staticRepo   *storage.StaticPINRepository
+	syncRunRepo  *storage.SyncRunRepository
+	caldavRepo   *storage.CalDAVRepository
+	haClient     *lock.HAClient
 	parser       *Parser
+	// Guards the global settings below, which can change while syncs run
+	settingsMu   sync.RWMutex
 	generator    *pin.Generator
+	conflicts    *pin.ConflictChecker
 	checkinTime  string // Format: "15:04"
 	checkoutTime string
+	location     *time.Location // Default property timezone
+
+	snapshotRetention int    // Raw feed snapshots kept per calendar
+	conflictPolicy    string // What syncs do with generated PINs that conflict
+	codeReuseDays     int    // Days before a drawn PIN may reuse a code a lock had
+
+	// Syncs in progress by calendar ID; overlapping requests share one run
+	flightsMu sync.Mutex
+	flights   map[string]*syncCall
+	// Serializes planning and applying, which allocate slots on shared locks
+	planMu sync.Mutex
 }
 
 // NewSyncService creates a new calendar sync service.
@@ -30,22 +50,116 @@ func NewSyncService(
 	guestPINRepo *storage.GuestPINRepository,
 	lockRepo *storage.LockRepository,
 	checkinTime, checkoutTime string,
+	timezone string,
 	minPIN, maxPIN int,
+	recurrenceHorizonDays int,
+	snapshotRetention int,
+	conflictPolicy string,
+	codeReuseDays int,
+	policy pin.Policy,
 ) *SyncService {
-	return &SyncService{
+	location := time.Local
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			location = loc
+		} else {
+			log.Printf("Invalid timezone setting %q, using server local time: %v", timezone, err)
+		}
+	}
+
+	if !pin.IsValidConflictPolicy(conflictPolicy) {
+		conflictPolicy = pin.ConflictPolicyAutoResolve
+	}
+	if codeReuseDays < 0 {
+		codeReuseDays = DefaultCodeReuseDays
+	}
+
+	s := &SyncService{
 		db:           db,
 		calendarRepo: calendarRepo,
 		guestPINRepo: guestPINRepo,
 		lockRepo:     lockRepo,
-		parser:       NewParser(),
-		generator:    pin.NewGenerator(minPIN, maxPIN),
+		staticRepo:   storage.NewStaticPINRepository(db),
+		syncRunRepo:  storage.NewSyncRunRepository(db),
+		caldavRepo:   storage.NewCalDAVRepository(db),
+		haClient:     lock.NewHAClient(lock.DefaultConfig()),
+		parser:       NewParserWithHorizon(recurrenceHorizonDays),
 		checkinTime:  checkinTime,
 		checkoutTime: checkoutTime,
+		location:     location,
+
+		snapshotRetention: snapshotRetention,
+		conflictPolicy:    conflictPolicy,
+		codeReuseDays:     codeReuseDays,
+		flights:           make(map[string]*syncCall),
 	}
+	s.SetPINSettings(minPIN, maxPIN, policy)
+	return s
 }
 
-// SyncCalendar synchronizes a single calendar and returns the result.
+// SyncCalendar synchronizes a single calendar and returns the result. If the
+// feed looks broken, removals are held and an *AnomalyError is returned.
 func (s *SyncService) SyncCalendar(ctx context.Context, calendarID string) (*models.CalendarSyncResult, error) {
+	return s.syncCalendar(ctx, calendarID, false)
+}
+
+// ConfirmAnomaly syncs a calendar without the anomaly guard, applying any held
+// removals and accepting the current feed as the new baseline.
+func (s *SyncService) ConfirmAnomaly(ctx context.Context, calendarID string) (*models.CalendarSyncResult, error) {
+	return s.syncCalendar(ctx, calendarID, true)
+}
+
+// syncCalendar runs a sync of a calendar, or joins the one already running.
+// A confirmed sync must not reuse a guarded run, so it waits for that run to
+// finish and then starts its own.
+func (s *SyncService) syncCalendar(ctx context.Context, calendarID string, confirmed bool) (*models.CalendarSyncResult, error) {
+	for {
+		s.flightsMu.Lock()
+		call, running := s.flights[calendarID]
+		if !running {
+			call = &syncCall{done: make(chan struct{})}
+			s.flights[calendarID] = call
+			s.flightsMu.Unlock()
+
+			call.result, call.err = s.runCalendarSync(ctx, calendarID, confirmed)
+
+			s.flightsMu.Lock()
+			delete(s.flights, calendarID)
+			s.flightsMu.Unlock()
+			close(call.done)
+			return call.result, call.err
+		}
+		s.flightsMu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !confirmed {
+			return call.shared()
+		}
+	}
+}
+
+// syncCall is a sync run that overlapping requests wait on.
+type syncCall struct {
+	done   chan struct{}
+	result *models.CalendarSyncResult
+	err    error
+}
+
+// shared returns a copy of the run's result for a request that joined it.
+func (c *syncCall) shared() (*models.CalendarSyncResult, error) {
+	if c.result == nil {
+		return nil, c.err
+	}
+	result := *c.result
+	return &result, c.err
+}
+
+// runCalendarSync syncs a calendar and records the run.
+func (s *SyncService) runCalendarSync(ctx context.Context, calendarID string, confirmed bool) (*models.CalendarSyncResult, error) {
 	// Get calendar details
 	calendar, err := s.calendarRepo.GetByID(ctx, calendarID)
 	if err != nil {
@@ -61,25 +175,60 @@ func (s *SyncService) SyncCalendar(ctx context.Context, calendarID string) (*mod
 		SyncedAt:     time.Now().UTC(),
 	}
 
+	run := &models.CalendarSyncRun{CalendarID: calendar.ID, StartedAt: result.SyncedAt}
+	err = s.runSync(ctx, calendar, confirmed, result, run)
+	s.recordRun(ctx, run, result, err)
+
+	return result, err
+}
+
+// runSync fetches a calendar and applies its plan, filling in result and the
+// feed details of run.
+func (s *SyncService) runSync(ctx context.Context, calendar *models.CalendarSubscription, confirmed bool, result *models.CalendarSyncResult, run *models.CalendarSyncRun) error {
+	calendarID := calendar.ID
+
 	// Update status to syncing
 	if err := s.calendarRepo.UpdateSyncStatus(ctx, calendarID, models.SyncStatusSyncing, nil); err != nil {
 		log.Printf("Failed to update sync status: %v", err)
 	}
 
-	// Fetch and parse the calendar
-	events, err := s.parser.FetchAndParse(calendar.URL)
+	// Fetch and parse the calendar, skipping it if it has not changed
+	conditional := !confirmed && feedStateFresh(calendar, result.SyncedAt)
+	var validators FeedValidators
+	if conditional {
+		validators = FeedValidators{ETag: derefString(calendar.FeedETag), LastModified: derefString(calendar.FeedLastModified)}
+	}
+	feed, err := s.fetchFeed(ctx, calendar, validators)
+	if feed != nil {
+		if feed.StatusCode != 0 {
+			run.HTTPStatus = &feed.StatusCode
+		}
+		run.Snapshot = feed.Body
+		run.SnapshotFormat = feed.Format
+	}
+	if err == nil && conditional && (feed.NotModified || feed.ContentHash == *calendar.FeedContentHash) {
+		result.Unchanged = true
+		run.Snapshot = nil
+		if err := s.calendarRepo.UpdateSyncStatus(ctx, calendarID, models.SyncStatusSuccess, nil); err != nil {
+			log.Printf("Failed to update sync status: %v", err)
+		}
+		return nil
+	}
+	var events []models.CalendarEvent
+	if err == nil {
+		events, err = s.parser.decodeFeed(feed.Format, feed.Body)
+	}
 	if err != nil {
 		errMsg := err.Error()
 		s.calendarRepo.UpdateSyncStatus(ctx, calendarID, models.SyncStatusError, &errMsg)
 		result.Error = err
-		return result, err
+		return err
 	}
 
-	result.EventsFound = len(events)
-
-	// Filter to future events only
-	now := time.Now().UTC()
-	events = FilterFutureEvents(events, now)
+	// Fetching runs in parallel; from here on calendars sharing locks would
+	// race for the same slots
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
 
 	// Get locks assigned to this calendar
 	lockIDs, err := s.calendarRepo.GetLockIDs(ctx, calendarID)
@@ -88,145 +237,284 @@ func (s *SyncService) SyncCalendar(ctx context.Context, calendarID string) (*mod
 		lockIDs = []string{}
 	}
 
-	// Process each event
-	for _, event := range events {
-		created, updated, err := s.processEvent(ctx, calendar.ID, event, lockIDs)
-		if err != nil {
-			log.Printf("Error processing event %s: %v", event.UID, err)
-			continue
-		}
-		if created {
-			result.PINsCreated++
-		} else if updated {
-			result.PINsUpdated++
+	plan, err := s.buildPlan(ctx, calendar, events, lockIDs)
+	if err == nil && !confirmed {
+		err = s.checkAnomaly(ctx, calendar, plan)
+	}
+	if err != nil {
+		errMsg := err.Error()
+		s.calendarRepo.UpdateSyncStatus(ctx, calendarID, models.SyncStatusError, &errMsg)
+		result.Error = err
+		return err
+	}
+
+	result.EventsFound = plan.Summary.EventsFound
+	result.EventsSkipped = plan.Summary.EventsSkipped
+	result.SkippedByReason = plan.Summary.SkippedByReason
+	result.PendingRemoval = plan.Summary.PendingRemoval
+	s.applyPlan(ctx, plan, result)
+
+	// Keep the anomaly on the calendar until the feed recovers or is confirmed
+	if plan.Anomaly != "" {
+		anomaly := &AnomalyError{CalendarID: calendarID, Reason: plan.Anomaly, HeldRemovals: plan.HeldRemovals}
+		if err := s.calendarRepo.SetAnomaly(ctx, calendarID, &plan.Anomaly); err != nil {
+			log.Printf("Failed to record sync anomaly: %v", err)
 		}
+		errMsg := anomaly.Error()
+		s.calendarRepo.UpdateSyncStatus(ctx, calendarID, models.SyncStatusError, &errMsg)
+		result.Error = anomaly
+		return anomaly
 	}
 
-	// Mark expired PINs
-	removed, err := s.markExpiredPINs(ctx, calendarID, events)
-	if err != nil {
-		log.Printf("Error marking expired PINs: %v", err)
+	if err := s.calendarRepo.SaveSyncBaseline(ctx, calendarID, plan.baseline()); err != nil {
+		log.Printf("Failed to save sync baseline: %v", err)
+	}
+	if calendar.AnomalyReason != nil {
+		if err := s.calendarRepo.SetAnomaly(ctx, calendarID, nil); err != nil {
+			log.Printf("Failed to clear sync anomaly: %v", err)
+		}
+	}
+	if plan.conflicted || len(plan.PendingRemoval) > 0 {
+		// PINs in conflict status are checked again, and the grace of PINs
+		// missing from the feed advances, only when the feed is processed
+		if err := s.calendarRepo.ClearFeedState(ctx, calendarID); err != nil {
+			log.Printf("Failed to clear feed state: %v", err)
+		}
+	} else if err := s.calendarRepo.SaveFeedState(ctx, calendarID, feed.ETag, feed.LastModified, feed.ContentHash); err != nil {
+		log.Printf("Failed to save feed state: %v", err)
 	}
-	result.PINsRemoved = removed
 
 	// Update calendar status to success
 	if err := s.calendarRepo.UpdateSyncStatus(ctx, calendarID, models.SyncStatusSuccess, nil); err != nil {
 		log.Printf("Failed to update sync status: %v", err)
 	}
 
-	return result, nil
+	return nil
+}
+
+// PreviewCalendar fetches a saved calendar and returns the changes a sync
+// would make, without writing PINs, lock assignments or sync status.
+func (s *SyncService) PreviewCalendar(ctx context.Context, calendarID string) (*SyncPlan, error) {
+	calendar, err := s.calendarRepo.GetByID(ctx, calendarID)
+	if err != nil {
+		return nil, fmt.Errorf("getting calendar: %w", err)
+	}
+	if calendar == nil {
+		return nil, fmt.Errorf("calendar not found: %s", calendarID)
+	}
+
+	lockIDs, err := s.calendarRepo.GetLockIDs(ctx, calendarID)
+	if err != nil {
+		return nil, fmt.Errorf("getting lock IDs: %w", err)
+	}
+
+	plan, err := s.PreviewSubscription(ctx, calendar, lockIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAnomaly(ctx, calendar, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
 }
 
-// processEvent processes a single calendar event, creating or updating the PIN.
-func (s *SyncService) processEvent(ctx context.Context, calendarID string, event models.CalendarEvent, lockIDs []string) (created, updated bool, err error) {
-	// Check if PIN already exists for this event
-	existing, err := s.guestPINRepo.GetByEventUID(ctx, calendarID, event.UID)
+// PreviewSubscription returns the changes a sync of cal would make when
+// assigned to lockIDs. cal need not be saved; an unsaved calendar has no
+// existing PINs, so every reservation is planned as a create.
+func (s *SyncService) PreviewSubscription(ctx context.Context, cal *models.CalendarSubscription, lockIDs []string) (*SyncPlan, error) {
+	feed, err := s.fetchFeed(ctx, cal, FeedValidators{})
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.parser.decodeFeed(feed.Format, feed.Body)
 	if err != nil {
-		return false, false, fmt.Errorf("checking existing PIN: %w", err)
+		return nil, err
 	}
 
-	// Calculate validity window with check-in/check-out times
-	validFrom := s.applyCheckinTime(event.Start)
-	validUntil := s.applyCheckoutTime(event.End)
+	return s.buildPlan(ctx, cal, events, lockIDs)
+}
 
-	if existing != nil {
-		// Update existing PIN if dates changed
-		if !existing.ValidFrom.Equal(validFrom) || !existing.ValidUntil.Equal(validUntil) {
-			existing.ValidFrom = validFrom
-			existing.ValidUntil = validUntil
-			existing.EventSummary = &event.Summary
+// applyReservationDetails copies the adapter-extracted reservation fields onto
+// a PIN and reports whether any of them changed.
+func applyReservationDetails(p *models.GuestPIN, event models.CalendarEvent) bool {
+	changed := false
+	set := func(field **string, value string) {
+		var next *string
+		if value != "" {
+			next = &value
+		}
+		if (*field == nil) != (next == nil) || (*field != nil && **field != value) {
+			*field = next
+			changed = true
+		}
+	}
 
-			// Regenerate PIN if using date-based method and dates changed
-			if existing.GenerationMethod == models.GenerationMethodDateBased {
-				result := s.generator.GenerateFromEvent(event, "")
-				existing.PINCode = result.PINCode
-			}
+	set(&p.ReservationCode, event.ReservationCode)
+	set(&p.GuestName, event.GuestName)
+	set(&p.GuestPhoneLast4, event.PhoneLast4)
+	set(&p.ReservationURL, event.ReservationURL)
 
-			if err := s.guestPINRepo.Update(ctx, existing); err != nil {
-				return false, false, fmt.Errorf("updating PIN: %w", err)
-			}
-			return false, true, nil
+	return changed
+}
+
+// propertyTimes holds the timezone and check-in/check-out times in effect for
+// one calendar's property.
+type propertyTimes struct {
+	location     *time.Location
+	checkinTime  string // Format: "15:04"
+	checkoutTime string
+}
+
+// SetPropertyDefaults changes the global check-in and check-out times and
+// property timezone used by calendars without overrides. Empty values leave
+// the current setting unchanged.
+func (s *SyncService) SetPropertyDefaults(checkinTime, checkoutTime, timezone string) {
+	var location *time.Location
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			log.Printf("Invalid timezone setting %q, keeping the current one: %v", timezone, err)
 		}
-		return false, false, nil
+		location = loc
 	}
 
-	// Generate new PIN
-	result := s.generator.GenerateFromEvent(event, "")
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if checkinTime != "" {
+		s.checkinTime = checkinTime
+	}
+	if checkoutTime != "" {
+		s.checkoutTime = checkoutTime
+	}
+	if location != nil {
+		s.location = location
+	}
+}
+
+// SetPINSettings changes the global PIN length bounds and the PIN policy.
+func (s *SyncService) SetPINSettings(minPIN, maxPIN int, policy pin.Policy) {
+	generator := pin.NewGenerator(minPIN, maxPIN).WithPolicy(policy)
+	conflicts := pin.NewConflictChecker(s.guestPINRepo.ListOverlapping, s.staticRepo.ListEnabledOnLock, policy)
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.generator = generator
+	s.conflicts = conflicts
+}
 
-	// Create new guest PIN
-	guestPIN := &models.GuestPIN{
-		CalendarID:           calendarID,
-		EventUID:             event.UID,
-		EventSummary:         &event.Summary,
-		PINCode:              result.PINCode,
-		GenerationMethod:     result.Method,
-		ValidFrom:            validFrom,
-		ValidUntil:           validUntil,
-		Status:               models.PINStatusPending,
-		RegenerationEligible: true,
+// defaultGenerator returns the PIN generator for the global PIN settings.
+func (s *SyncService) defaultGenerator() *pin.Generator {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.generator
+}
+
+// checker returns the conflict checker applying the PIN policy.
+func (s *SyncService) checker() *pin.ConflictChecker {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.conflicts
+}
+
+// SetConflictPolicy changes what syncs do with generated PINs that conflict.
+// Unknown policies are ignored.
+func (s *SyncService) SetConflictPolicy(policy string) {
+	if !pin.IsValidConflictPolicy(policy) {
+		return
 	}
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.conflictPolicy = policy
+}
 
-	// Check if PIN should be active now
-	now := time.Now().UTC()
-	if guestPIN.IsActive(now) {
-		guestPIN.Status = models.PINStatusActive
+// SetCodeReuseDays changes how many days a drawn PIN avoids codes a lock had.
+// Negative values are ignored.
+func (s *SyncService) SetCodeReuseDays(days int) {
+	if days < 0 {
+		return
 	}
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.codeReuseDays = days
+}
 
-	if err := s.guestPINRepo.Create(ctx, guestPIN); err != nil {
-		return false, false, fmt.Errorf("creating PIN: %w", err)
+// defaultPropertyTimes returns the global timezone and check-in/check-out times.
+func (s *SyncService) defaultPropertyTimes() propertyTimes {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return propertyTimes{
+		location:     s.location,
+		checkinTime:  s.checkinTime,
+		checkoutTime: s.checkoutTime,
 	}
+}
+
+// propertyTimesFor resolves a calendar's overrides against the global settings.
+func (s *SyncService) propertyTimesFor(cal *models.CalendarSubscription) propertyTimes {
+	times := s.defaultPropertyTimes()
 
-	// Assign to locks
-	slotNumber := 1 // TODO: Implement slot allocation
-	for _, lockID := range lockIDs {
-		if err := s.guestPINRepo.AssignToLock(ctx, guestPIN.ID, lockID, slotNumber); err != nil {
-			log.Printf("Failed to assign PIN to lock %s: %v", lockID, err)
+	if cal.Timezone != nil && *cal.Timezone != "" {
+		if loc, err := time.LoadLocation(*cal.Timezone); err == nil {
+			times.location = loc
+		} else {
+			log.Printf("Calendar %s has invalid timezone %q, using default: %v", cal.ID, *cal.Timezone, err)
 		}
-		slotNumber++
 	}
+	if cal.CheckinTime != nil && *cal.CheckinTime != "" {
+		times.checkinTime = *cal.CheckinTime
+	}
+	if cal.CheckoutTime != nil && *cal.CheckoutTime != "" {
+		times.checkoutTime = *cal.CheckoutTime
+	}
+
+	return times
+}
 
-	return true, false, nil
+// generatorFor returns the PIN generator configured with a calendar's method
+// order and PIN length.
+func (s *SyncService) generatorFor(cal *models.CalendarSubscription) (*pin.Generator, error) {
+	return s.defaultGenerator().WithPipeline(cal.PINMethods, cal.PINLength)
 }
 
-// markExpiredPINs marks PINs as expired if they're no longer in the calendar.
-func (s *SyncService) markExpiredPINs(ctx context.Context, calendarID string, currentEvents []models.CalendarEvent) (int, error) {
-	// Get all PINs for this calendar
-	pins, err := s.guestPINRepo.ListByCalendar(ctx, calendarID)
+// pinGenerator returns the calendar of a guest PIN, or nil if it is gone, and
+// the PIN generator configured for it.
+func (s *SyncService) pinGenerator(ctx context.Context, p *models.GuestPIN) (*models.CalendarSubscription, *pin.Generator, error) {
+	cal, err := s.calendarRepo.GetByID(ctx, p.CalendarID)
 	if err != nil {
-		return 0, fmt.Errorf("listing PINs: %w", err)
+		return nil, nil, err
 	}
-
-	// Build set of current event UIDs
-	currentUIDs := make(map[string]bool)
-	for _, e := range currentEvents {
-		currentUIDs[e.UID] = true
+	if cal == nil {
+		return nil, s.defaultGenerator(), nil
 	}
-
-	// Mark PINs as expired if event is no longer in calendar
-	removed := 0
-	for _, pin := range pins {
-		if !currentUIDs[pin.EventUID] && pin.Status != models.PINStatusExpired {
-			if err := s.guestPINRepo.UpdateStatus(ctx, pin.ID, models.PINStatusExpired); err != nil {
-				log.Printf("Failed to expire PIN %s: %v", pin.ID, err)
-				continue
-			}
-			removed++
-		}
+	gen, err := s.generatorFor(cal)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PIN generation settings: %w", err)
 	}
+	return cal, gen, nil
+}
 
-	return removed, nil
+// applyCheckinTime applies the check-in time to the property-local date of an event start.
+func (t propertyTimes) applyCheckinTime(date time.Time, allDay bool) time.Time {
+	hour, minute := parseTimeString(t.checkinTime, 15, 0)
+	return t.atLocalTime(date, allDay, hour, minute)
 }
 
-// applyCheckinTime applies the check-in time to a date.
-func (s *SyncService) applyCheckinTime(date time.Time) time.Time {
-	hour, minute := parseTimeString(s.checkinTime, 15, 0)
-	return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, date.Location())
+// applyCheckoutTime applies the check-out time to the property-local date of an event end.
+func (t propertyTimes) applyCheckoutTime(date time.Time, allDay bool) time.Time {
+	hour, minute := parseTimeString(t.checkoutTime, 11, 0)
+	return t.atLocalTime(date, allDay, hour, minute)
 }
 
-// applyCheckoutTime applies the check-out time to a date.
-func (s *SyncService) applyCheckoutTime(date time.Time) time.Time {
-	hour, minute := parseTimeString(s.checkoutTime, 11, 0)
-	return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, date.Location())
+// atLocalTime returns the UTC instant of hour:minute on the property-local
+// date of t. All-day dates carry no zone, so their calendar date is used as-is;
+// timed events are first converted to the property's timezone. time.Date
+// resolves the offset for that specific date, so windows stay correct across DST.
+func (t propertyTimes) atLocalTime(date time.Time, allDay bool, hour, minute int) time.Time {
+	if !allDay {
+		date = date.In(t.location)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, t.location).UTC()
 }
 
 // parseTimeString parses a time string "HH:MM" and returns hour and minute.
@@ -244,33 +532,82 @@ func parseTimeString(s string, defaultHour, defaultMinute int) (int, int) {
 	return hour, minute
 }
 
-// SyncAllEnabled synchronizes all enabled calendars.
-func (s *SyncService) SyncAllEnabled(ctx context.Context) ([]models.CalendarSyncResult, error) {
+// syncWorkers is how many calendars SyncAllEnabled syncs at once.
+const syncWorkers = 4
+
+// SyncProgress reports a calendar finished by SyncAllEnabled. Result is
+// empty in the first report, made before any calendar starts.
+type SyncProgress struct {
+	Completed int
+	Total     int
+	Result    models.CalendarSyncResult
+}
+
+// SyncAllEnabled synchronizes all enabled calendars, a few at a time. If
+// progress is not nil it is called once at the start and as each calendar
+// finishes; calls are not concurrent. Results are returned in the order
+// calendars finished.
+func (s *SyncService) SyncAllEnabled(ctx context.Context, progress func(SyncProgress)) ([]models.CalendarSyncResult, error) {
 	calendars, err := s.calendarRepo.ListEnabled(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("listing enabled calendars: %w", err)
 	}
 
-	var results []models.CalendarSyncResult
-	for _, cal := range calendars {
-		result, err := s.SyncCalendar(ctx, cal.ID)
-		if err != nil {
-			log.Printf("Error syncing calendar %s: %v", cal.ID, err)
-			if result == nil {
-				result = &models.CalendarSyncResult{
-					CalendarID:   cal.ID,
-					CalendarName: cal.Name,
-					Error:        err,
-					SyncedAt:     time.Now().UTC(),
+	if progress != nil {
+		progress(SyncProgress{Total: len(calendars)})
+	}
+
+	jobs := make(chan models.CalendarSubscription)
+	done := make(chan models.CalendarSyncResult)
+	var wg sync.WaitGroup
+	for i := 0; i < syncWorkers && i < len(calendars); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cal := range jobs {
+				result, err := s.SyncCalendar(ctx, cal.ID)
+				if err != nil {
+					log.Printf("Error syncing calendar %s: %v", cal.ID, err)
+					if result == nil {
+						result = &models.CalendarSyncResult{
+							CalendarID:   cal.ID,
+							CalendarName: cal.Name,
+							SyncedAt:     time.Now().UTC(),
+						}
+					}
+					result.Error = err
 				}
+				done <- *result
 			}
+		}()
+	}
+
+	go func() {
+		for _, cal := range calendars {
+			jobs <- cal
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	results := make([]models.CalendarSyncResult, 0, len(calendars))
+	for result := range done {
+		results = append(results, result)
+		if progress != nil {
+			progress(SyncProgress{Completed: len(results), Total: len(calendars), Result: result})
 		}
-		results = append(results, *result)
 	}
 
 	return results, nil
 }
 
+// NextCheckin returns the start of the earliest live PIN of a calendar that
+// begins after the given time, or nil if there is none.
+func (s *SyncService) NextCheckin(ctx context.Context, calendarID string, after time.Time) (*time.Time, error) {
+	return s.guestPINRepo.NextCheckin(ctx, calendarID, after)
+}
+
 // UpdatePINStatuses updates PIN statuses based on current time.
 func (s *SyncService) UpdatePINStatuses(ctx context.Context) error {
 	// Activate pending PINs that should now be active
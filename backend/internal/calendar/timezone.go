@@ -0,0 +1,484 @@
+package calendar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timezoneResolver interprets DTSTART/DTEND style values for one VCALENDAR.
+// TZIDs are resolved against the IANA database first, since a real location
+// keeps later date arithmetic DST-aware, and then against the feed's own
+// VTIMEZONE definitions (e.g. Outlook's "Eastern Standard Time").
+type timezoneResolver struct {
+	embedded map[string]*vtimezone
+	floating *time.Location
+	cache    map[string]*time.Location
+}
+
+// newTimezoneResolver collects VTIMEZONE blocks and X-WR-TIMEZONE from a VCALENDAR.
+func newTimezoneResolver(cal *component) *timezoneResolver {
+	r := &timezoneResolver{
+		embedded: make(map[string]*vtimezone),
+		floating: time.UTC,
+		cache:    make(map[string]*time.Location),
+	}
+
+	for _, child := range cal.Children {
+		if child.Name != "VTIMEZONE" {
+			continue
+		}
+		if vtz := parseVTimezone(child); vtz != nil {
+			r.embedded[vtz.id] = vtz
+		}
+	}
+
+	// Floating times (no Z, no TZID) are interpreted in the calendar's
+	// advertised timezone when it has one.
+	if name := strings.TrimSpace(cal.value("X-WR-TIMEZONE")); name != "" {
+		if loc, ok := loadIANALocation(name); ok {
+			r.floating = loc
+		}
+	}
+
+	return r
+}
+
+// parseDateTime parses a DATE or DATE-TIME property, honouring VALUE=DATE and TZID.
+// The boolean result reports whether the value was date-only.
+func (r *timezoneResolver) parseDateTime(p *contentLine) (time.Time, bool, error) {
+	return r.parseValue(strings.TrimSpace(p.Value), p.param("VALUE"), p.param("TZID"))
+}
+
+// parseValue parses a single DATE or DATE-TIME value with the given VALUE and TZID parameters.
+func (r *timezoneResolver) parseValue(value, valueType, tzid string) (time.Time, bool, error) {
+	if strings.EqualFold(valueType, "DATE") || len(value) == 8 || len(value) == 10 {
+		for _, layout := range []string{"20060102", "2006-01-02"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, true, nil
+			}
+		}
+		return time.Time{}, true, fmt.Errorf("unrecognised date value %q", value)
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		for _, layout := range []string{"20060102T150405Z", "2006-01-02T15:04:05Z"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, false, nil
+			}
+		}
+		return time.Time{}, false, fmt.Errorf("unrecognised UTC date-time value %q", value)
+	}
+
+	wall, err := parseWallClock(value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if tzid == "" {
+		return inLocation(wall, r.floating), false, nil
+	}
+
+	t, err := r.localize(wall, tzid)
+	return t, false, err
+}
+
+// localize interprets wall-clock fields in the zone identified by tzid.
+func (r *timezoneResolver) localize(wall time.Time, tzid string) (time.Time, error) {
+	if loc, ok := r.cache[tzid]; ok {
+		return inLocation(wall, loc), nil
+	}
+
+	if loc, ok := loadIANALocation(tzid); ok {
+		r.cache[tzid] = loc
+		return inLocation(wall, loc), nil
+	}
+
+	if vtz, ok := r.embedded[tzid]; ok {
+		return vtz.resolve(wall), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+}
+
+// localizer returns a function that interprets wall-clock fields the same way
+// p was interpreted, so that each instance of a recurring series gets the UTC
+// offset in effect on its own date.
+func (r *timezoneResolver) localizer(p *contentLine, allDay bool) func(time.Time) time.Time {
+	tzid := p.param("TZID")
+	switch {
+	case allDay, strings.HasSuffix(strings.TrimSpace(p.Value), "Z"):
+		return func(wall time.Time) time.Time { return wall }
+	case tzid == "":
+		return func(wall time.Time) time.Time { return inLocation(wall, r.floating) }
+	default:
+		return func(wall time.Time) time.Time {
+			t, err := r.localize(wall, tzid)
+			if err != nil {
+				return wall
+			}
+			return t
+		}
+	}
+}
+
+// parseWallClock parses a floating DATE-TIME into UTC-valued wall-clock fields.
+func parseWallClock(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date-time value %q", value)
+}
+
+// inLocation re-creates the wall-clock fields of t in loc.
+func inLocation(wall time.Time, loc *time.Location) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+}
+
+// loadIANALocation loads a TZID from the IANA database. Some producers prefix
+// the name with a vendor path (e.g. "/mozilla.org/20050126_1/America/Denver"),
+// so trailing path segments are tried as well.
+func loadIANALocation(tzid string) (*time.Location, bool) {
+	name := strings.Trim(strings.TrimSpace(tzid), "\"")
+	if name == "" {
+		return nil, false
+	}
+
+	if loc, err := time.LoadLocation(name); err == nil && name != "Local" {
+		return loc, true
+	}
+
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		candidate := strings.Join(parts[i:], "/")
+		if !strings.Contains(candidate, "/") {
+			break
+		}
+		if loc, err := time.LoadLocation(candidate); err == nil {
+			return loc, true
+		}
+	}
+
+	return nil, false
+}
+
+// vtimezone is an embedded VTIMEZONE definition.
+type vtimezone struct {
+	id          string
+	observances []tzObservance
+}
+
+// tzObservance is a STANDARD or DAYLIGHT sub-component.
+type tzObservance struct {
+	name       string
+	start      time.Time // wall clock, UTC-valued fields
+	offsetFrom int
+	offsetTo   int
+	rule       *tzRule
+	rdates     []time.Time
+}
+
+// tzRule is the yearly subset of RRULE used by VTIMEZONE observances.
+type tzRule struct {
+	month      time.Month
+	ordinal    int
+	weekday    time.Weekday
+	hasWeekday bool
+	monthDays  []int
+	until      time.Time
+}
+
+// parseVTimezone builds a vtimezone from a VTIMEZONE component.
+// Returns nil if the definition has no usable observances.
+func parseVTimezone(c *component) *vtimezone {
+	vtz := &vtimezone{id: c.value("TZID")}
+	if vtz.id == "" {
+		return nil
+	}
+
+	for _, child := range c.Children {
+		if child.Name != "STANDARD" && child.Name != "DAYLIGHT" {
+			continue
+		}
+
+		start, err := parseWallClock(child.value("DTSTART"))
+		if err != nil {
+			continue
+		}
+		from, errFrom := parseUTCOffset(child.value("TZOFFSETFROM"))
+		to, errTo := parseUTCOffset(child.value("TZOFFSETTO"))
+		if errFrom != nil || errTo != nil {
+			continue
+		}
+
+		obs := tzObservance{
+			name:       child.value("TZNAME"),
+			start:      start,
+			offsetFrom: from,
+			offsetTo:   to,
+		}
+		if rrule := child.value("RRULE"); rrule != "" {
+			obs.rule = parseTZRule(rrule)
+		}
+		for _, rdate := range child.props("RDATE") {
+			for _, v := range strings.Split(rdate.Value, ",") {
+				if t, err := parseWallClock(strings.TrimSpace(v)); err == nil {
+					obs.rdates = append(obs.rdates, t)
+				}
+			}
+		}
+
+		vtz.observances = append(vtz.observances, obs)
+	}
+
+	if len(vtz.observances) == 0 {
+		return nil
+	}
+	return vtz
+}
+
+// resolve returns wall in the offset of the observance in effect at that time.
+func (z *vtimezone) resolve(wall time.Time) time.Time {
+	var best *tzObservance
+	var bestOnset time.Time
+
+	for i := range z.observances {
+		obs := &z.observances[i]
+		if onset, ok := obs.latestOnset(wall); ok && (best == nil || onset.After(bestOnset)) {
+			best = obs
+			bestOnset = onset
+		}
+	}
+
+	if best == nil {
+		// Before every onset: use the offset in effect prior to the earliest one
+		earliest := &z.observances[0]
+		for i := range z.observances {
+			if z.observances[i].start.Before(earliest.start) {
+				earliest = &z.observances[i]
+			}
+		}
+		return inLocation(wall, time.FixedZone(earliest.name, earliest.offsetFrom))
+	}
+
+	return inLocation(wall, time.FixedZone(best.name, best.offsetTo))
+}
+
+// latestOnset returns the most recent onset of the observance at or before wall.
+func (o *tzObservance) latestOnset(wall time.Time) (time.Time, bool) {
+	var latest time.Time
+	found := false
+
+	consider := func(t time.Time) {
+		if t.Before(o.start) || t.After(wall) {
+			return
+		}
+		if !found || t.After(latest) {
+			latest = t
+			found = true
+		}
+	}
+
+	consider(o.start)
+	for _, rd := range o.rdates {
+		consider(rd)
+	}
+
+	if o.rule != nil {
+		for year := wall.Year(); year >= wall.Year()-1; year-- {
+			t, ok := o.rule.occurrence(year, o.start)
+			if !ok {
+				continue
+			}
+			if !o.rule.until.IsZero() && t.Add(-time.Duration(o.offsetFrom)*time.Second).After(o.rule.until) {
+				continue
+			}
+			consider(t)
+		}
+	}
+
+	return latest, found
+}
+
+// occurrence returns the rule's onset in the given year, at the clock time of start.
+func (r *tzRule) occurrence(year int, start time.Time) (time.Time, bool) {
+	month := r.month
+	if month == 0 {
+		month = start.Month()
+	}
+
+	day := 0
+	switch {
+	case r.hasWeekday && r.ordinal != 0:
+		day = nthWeekdayOfMonth(year, month, r.weekday, r.ordinal)
+	case r.hasWeekday && len(r.monthDays) > 0:
+		for _, md := range r.monthDays {
+			if time.Date(year, month, md, 0, 0, 0, 0, time.UTC).Weekday() == r.weekday {
+				day = md
+				break
+			}
+		}
+	case len(r.monthDays) > 0:
+		day = r.monthDays[0]
+	default:
+		day = start.Day()
+	}
+
+	if day <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, time.UTC), true
+}
+
+// parseTZRule parses the FREQ=YEARLY rules used in VTIMEZONE observances.
+// Other frequencies are not meaningful for timezone transitions and are ignored.
+func parseTZRule(value string) *tzRule {
+	parts := parseRuleParts(value)
+	if parts["FREQ"] != "YEARLY" {
+		return nil
+	}
+
+	rule := &tzRule{}
+	if m, err := strconv.Atoi(parts["BYMONTH"]); err == nil && m >= 1 && m <= 12 {
+		rule.month = time.Month(m)
+	}
+	if byday := parts["BYDAY"]; byday != "" {
+		ordinal, weekday, ok := parseByDay(strings.Split(byday, ",")[0])
+		if ok {
+			rule.ordinal = ordinal
+			rule.weekday = weekday
+			rule.hasWeekday = true
+		}
+	}
+	for _, v := range strings.Split(parts["BYMONTHDAY"], ",") {
+		if d, err := strconv.Atoi(v); err == nil {
+			rule.monthDays = append(rule.monthDays, d)
+		}
+	}
+	if until := parts["UNTIL"]; until != "" {
+		if t, err := time.Parse("20060102T150405Z", until); err == nil {
+			rule.until = t
+		} else if t, err := time.Parse("20060102", until); err == nil {
+			rule.until = t
+		}
+	}
+
+	return rule
+}
+
+// parseRuleParts splits an RRULE value into upper-cased key/value pairs.
+func parseRuleParts(value string) map[string]string {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		parts[strings.ToUpper(strings.TrimSpace(kv[0]))] = strings.ToUpper(strings.TrimSpace(kv[1]))
+	}
+	return parts
+}
+
+// parseByDay parses BYDAY entries such as "SU", "2SU" or "-1SU".
+func parseByDay(value string) (ordinal int, weekday time.Weekday, ok bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return 0, 0, false
+	}
+
+	weekday, ok = parseWeekday(value[len(value)-2:])
+	if !ok {
+		return 0, 0, false
+	}
+
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil {
+			return 0, 0, false
+		}
+		ordinal = n
+	}
+
+	return ordinal, weekday, true
+}
+
+// parseWeekday parses a two-letter iCal weekday code.
+func parseWeekday(code string) (time.Weekday, bool) {
+	switch strings.ToUpper(code) {
+	case "SU":
+		return time.Sunday, true
+	case "MO":
+		return time.Monday, true
+	case "TU":
+		return time.Tuesday, true
+	case "WE":
+		return time.Wednesday, true
+	case "TH":
+		return time.Thursday, true
+	case "FR":
+		return time.Friday, true
+	case "SA":
+		return time.Saturday, true
+	}
+	return 0, false
+}
+
+// nthWeekdayOfMonth returns the day of month of the nth weekday (negative
+// counts from the end), or 0 if the month has no such day.
+func nthWeekdayOfMonth(year int, month time.Month, weekday time.Weekday, n int) int {
+	if n > 0 {
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		day := 1 + (int(weekday)-int(first.Weekday())+7)%7 + (n-1)*7
+		if day > daysIn(year, month) {
+			return 0
+		}
+		return day
+	}
+
+	last := daysIn(year, month)
+	lastWeekday := time.Date(year, month, last, 0, 0, 0, 0, time.UTC).Weekday()
+	day := last - (int(lastWeekday)-int(weekday)+7)%7 - (-n-1)*7
+	if day < 1 {
+		return 0
+	}
+	return day
+}
+
+// daysIn returns the number of days in a month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// parseUTCOffset parses "+hhmm", "-hhmm" or "+hhmmss" into seconds east of UTC.
+func parseUTCOffset(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if len(value) != 5 && len(value) != 7 {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	sign := 1
+	switch value[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	h, err1 := strconv.Atoi(value[1:3])
+	m, err2 := strconv.Atoi(value[3:5])
+	s := 0
+	var err3 error
+	if len(value) == 7 {
+		s, err3 = strconv.Atoi(value[5:7])
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	return sign * (h*3600 + m*60 + s), nil
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -154,6 +155,55 @@ func (c *HAClient) GetEntityState(ctx context.Context, entityID string) (*Entity
 	return &state, nil
 }
 
+// CalendarEvent is an event of a Home Assistant calendar entity.
+type CalendarEvent struct {
+	UID          string       `json:"uid,omitempty"`
+	RecurrenceID string       `json:"recurrence_id,omitempty"`
+	Summary      string       `json:"summary"`
+	Description  string       `json:"description,omitempty"`
+	Location     string       `json:"location,omitempty"`
+	Start        CalendarTime `json:"start"`
+	End          CalendarTime `json:"end"`
+}
+
+// CalendarTime is the start or end of a calendar event: a date for all-day
+// events, otherwise an RFC 3339 date-time.
+type CalendarTime struct {
+	Date     string `json:"date,omitempty"`
+	DateTime string `json:"dateTime,omitempty"`
+}
+
+// GetCalendarEvents retrieves the events of a calendar entity between start and end.
+func (c *HAClient) GetCalendarEvents(ctx context.Context, entityID string, start, end time.Time) ([]CalendarEvent, error) {
+	query := url.Values{}
+	query.Set("start", start.UTC().Format(time.RFC3339))
+	query.Set("end", end.UTC().Format(time.RFC3339))
+	path := fmt.Sprintf("/api/calendars/%s?%s", url.PathEscape(entityID), query.Encode())
+
+	req, err := c.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, body)
+	}
+
+	var events []CalendarEvent
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return events, nil
+}
+
 // callService calls a Home Assistant service.
 func (c *HAClient) callService(ctx context.Context, domain, service string, data any) error {
 	path := fmt.Sprintf("/api/services/%s/%s", domain, service)
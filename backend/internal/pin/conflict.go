@@ -8,68 +8,141 @@ import (
 	"github.com/guest-lock-manager/backend/internal/storage/models"
 )
 
-// ConflictChecker detects PIN conflicts (same code, overlapping dates).
+// ConflictChecker detects PIN conflicts: codes on the same lock that clash
+// under the policy, the same code or one too alike, while both PINs are
+// valid. Static PINs on the lock always overlap.
 type ConflictChecker struct {
-	// findConflicts is a function that queries for conflicting PINs
-	findConflicts func(ctx context.Context, pinCode string, validFrom, validUntil string, excludeID string) ([]models.GuestPIN, error)
+	// findOverlapping queries for the guest PINs on a lock valid in a window
+	findOverlapping func(ctx context.Context, lockID string, validFrom, validUntil time.Time, excludeID string) ([]models.GuestPIN, error)
+	// findStatic queries for the enabled static PINs on a lock
+	findStatic func(ctx context.Context, lockID string) ([]models.StaticPIN, error)
+	policy     Policy
 }
 
 // NewConflictChecker creates a new conflict checker.
-func NewConflictChecker(findFunc func(ctx context.Context, pinCode string, validFrom, validUntil string, excludeID string) ([]models.GuestPIN, error)) *ConflictChecker {
+func NewConflictChecker(
+	findFunc func(ctx context.Context, lockID string, validFrom, validUntil time.Time, excludeID string) ([]models.GuestPIN, error),
+	findStaticFunc func(ctx context.Context, lockID string) ([]models.StaticPIN, error),
+	policy Policy,
+) *ConflictChecker {
 	return &ConflictChecker{
-		findConflicts: findFunc,
+		findOverlapping: findFunc,
+		findStatic:      findStaticFunc,
+		policy:          policy,
 	}
 }
 
+// Policy returns the PIN policy the checker applies.
+func (c *ConflictChecker) Policy() Policy {
+	return c.policy
+}
+
+// Conflict pin types, reported in Conflict.ConflictingType.
+const (
+	ConflictTypeGuest  = "guest"
+	ConflictTypeStatic = "static"
+)
+
+// Conflict policies decide what happens to a generated PIN whose code conflicts.
+// Custom PINs are chosen by the owner and are never given another code.
+const (
+	ConflictPolicyAutoResolve = "auto_resolve" // Use an alternative code from FindAlternativePIN
+	ConflictPolicyFlag        = "flag"         // Keep the code and set the PIN to conflict status
+)
+
+// IsValidConflictPolicy reports whether policy is a known conflict policy.
+func IsValidConflictPolicy(policy string) bool {
+	return policy == ConflictPolicyAutoResolve || policy == ConflictPolicyFlag
+}
+
 // Conflict represents a detected PIN conflict.
 type Conflict struct {
-	PINCode        string    `json:"pin_code"`
-	ConflictingPIN string    `json:"conflicting_pin_id"`
-	EventSummary   string    `json:"event_summary,omitempty"`
-	OverlapStart   time.Time `json:"overlap_start"`
-	OverlapEnd     time.Time `json:"overlap_end"`
+	PINCode          string    `json:"pin_code"`
+	LockID           string    `json:"lock_id,omitempty"`
+	ConflictingType  string    `json:"conflicting_type"`
+	Reason           string    `json:"reason"` // Why the codes clash; see Policy.Clash
+	ConflictingPIN   string    `json:"conflicting_pin_id,omitempty"`
+	ConflictingEvent string    `json:"conflicting_event_uid,omitempty"` // Set when the other PIN is not saved yet
+	EventSummary     string    `json:"event_summary,omitempty"`         // Event summary, or name of a static PIN
+	OverlapStart     time.Time `json:"overlap_start"`
+	OverlapEnd       time.Time `json:"overlap_end"`
 }
 
-// CheckConflicts checks if a PIN would conflict with existing PINs.
-func (c *ConflictChecker) CheckConflicts(ctx context.Context, pinCode string, validFrom, validUntil time.Time, excludeID string) ([]Conflict, error) {
-	conflicting, err := c.findConflicts(ctx, pinCode, validFrom.Format(time.RFC3339), validUntil.Format(time.RFC3339), excludeID)
-	if err != nil {
-		return nil, fmt.Errorf("checking conflicts: %w", err)
-	}
-
+// CheckConflicts checks if a PIN would conflict with existing PINs on any of
+// the given locks.
+func (c *ConflictChecker) CheckConflicts(ctx context.Context, pinCode string, lockIDs []string, validFrom, validUntil time.Time, excludeID string) ([]Conflict, error) {
 	var conflicts []Conflict
-	for _, pin := range conflicting {
-		// Calculate overlap period
-		overlapStart := validFrom
-		if pin.ValidFrom.After(overlapStart) {
-			overlapStart = pin.ValidFrom
+	for _, lockID := range lockIDs {
+		overlapping, err := c.findOverlapping(ctx, lockID, validFrom, validUntil, excludeID)
+		if err != nil {
+			return nil, fmt.Errorf("checking conflicts: %w", err)
 		}
 
-		overlapEnd := validUntil
-		if pin.ValidUntil.Before(overlapEnd) {
-			overlapEnd = pin.ValidUntil
+		for _, pin := range overlapping {
+			reason := c.policy.Clash(pinCode, pin.PINCode)
+			if reason == "" {
+				continue
+			}
+
+			// Calculate overlap period
+			overlapStart := validFrom
+			if pin.ValidFrom.After(overlapStart) {
+				overlapStart = pin.ValidFrom
+			}
+
+			overlapEnd := validUntil
+			if pin.ValidUntil.Before(overlapEnd) {
+				overlapEnd = pin.ValidUntil
+			}
+
+			summary := ""
+			if pin.EventSummary != nil {
+				summary = *pin.EventSummary
+			}
+
+			conflicts = append(conflicts, Conflict{
+				PINCode:         pinCode,
+				LockID:          lockID,
+				ConflictingType: ConflictTypeGuest,
+				ConflictingPIN:  pin.ID,
+				Reason:          reason,
+				EventSummary:    summary,
+				OverlapStart:    overlapStart,
+				OverlapEnd:      overlapEnd,
+			})
 		}
 
-		summary := ""
-		if pin.EventSummary != nil {
-			summary = *pin.EventSummary
+		if c.findStatic == nil {
+			continue
+		}
+		static, err := c.findStatic(ctx, lockID)
+		if err != nil {
+			return nil, fmt.Errorf("checking static PIN conflicts: %w", err)
+		}
+		for _, pin := range static {
+			reason := c.policy.Clash(pinCode, pin.PINCode)
+			if reason == "" {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{
+				PINCode:         pinCode,
+				LockID:          lockID,
+				ConflictingType: ConflictTypeStatic,
+				ConflictingPIN:  pin.ID,
+				Reason:          reason,
+				EventSummary:    pin.Name,
+				OverlapStart:    validFrom,
+				OverlapEnd:      validUntil,
+			})
 		}
-
-		conflicts = append(conflicts, Conflict{
-			PINCode:        pinCode,
-			ConflictingPIN: pin.ID,
-			EventSummary:   summary,
-			OverlapStart:   overlapStart,
-			OverlapEnd:     overlapEnd,
-		})
 	}
 
 	return conflicts, nil
 }
 
 // HasConflict returns true if there are any conflicts.
-func (c *ConflictChecker) HasConflict(ctx context.Context, pinCode string, validFrom, validUntil time.Time, excludeID string) (bool, error) {
-	conflicts, err := c.CheckConflicts(ctx, pinCode, validFrom, validUntil, excludeID)
+func (c *ConflictChecker) HasConflict(ctx context.Context, pinCode string, lockIDs []string, validFrom, validUntil time.Time, excludeID string) (bool, error) {
+	conflicts, err := c.CheckConflicts(ctx, pinCode, lockIDs, validFrom, validUntil, excludeID)
 	if err != nil {
 		return false, err
 	}
@@ -77,25 +150,23 @@ func (c *ConflictChecker) HasConflict(ctx context.Context, pinCode string, valid
 }
 
 // FindAlternativePIN tries to find a non-conflicting PIN by modifying the original.
-// Returns empty string if no alternative can be found within reasonable attempts.
-func (c *ConflictChecker) FindAlternativePIN(ctx context.Context, originalPIN string, validFrom, validUntil time.Time, maxAttempts int) (string, error) {
+// Returns an error if no alternative can be found within maxAttempts. Codes
+// the policy rejects are skipped, as are those taken reports: codes the
+// database does not know about yet, such as those of other PINs planned in
+// the same sync. taken may be nil.
+func (c *ConflictChecker) FindAlternativePIN(ctx context.Context, originalPIN string, lockIDs []string, validFrom, validUntil time.Time, excludeID string, taken func(code string) bool, maxAttempts int) (string, error) {
 	if maxAttempts <= 0 {
 		maxAttempts = 10
 	}
 
-	pinLen := len(originalPIN)
-	if pinLen < 4 {
-		pinLen = 4
-	}
-
 	for i := 0; i < maxAttempts; i++ {
 		// Modify the PIN by incrementing
 		modified := incrementPIN(originalPIN, i+1)
-		if len(modified) > pinLen {
-			modified = modified[len(modified)-pinLen:]
+		if modified == originalPIN || c.policy.Check(modified) != nil || (taken != nil && taken(modified)) {
+			continue
 		}
 
-		hasConflict, err := c.HasConflict(ctx, modified, validFrom, validUntil, "")
+		hasConflict, err := c.HasConflict(ctx, modified, lockIDs, validFrom, validUntil, excludeID)
 		if err != nil {
 			return "", err
 		}
@@ -2,23 +2,26 @@
 package pin
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/guest-lock-manager/backend/internal/storage/models"
 )
 
-// Generator generates PIN codes using various methods.
+// Generator generates PIN codes by trying a pipeline of strategies in order.
 type Generator struct {
 	minLength int
 	maxLength int
+	methods   []Strategy // Tried in order when no custom PIN is given
+	policy    Policy
 }
 
-// NewGenerator creates a new PIN generator.
+// maxVariations bounds how far a generated code the policy rejects is counted
+// up to find one it accepts.
+const maxVariations = 1000
+
+// NewGenerator creates a new PIN generator using DefaultMethods and DefaultPolicy.
 func NewGenerator(minLength, maxLength int) *Generator {
 	if minLength < 4 {
 		minLength = 4
@@ -30,10 +33,83 @@ func NewGenerator(minLength, maxLength int) *Generator {
 		maxLength = 8
 	}
 
+	methods := make([]Strategy, 0, len(DefaultMethods))
+	for _, name := range DefaultMethods {
+		if s, ok := LookupStrategy(name); ok {
+			methods = append(methods, s)
+		}
+	}
+
 	return &Generator{
 		minLength: minLength,
 		maxLength: maxLength,
+		methods:   methods,
+		policy:    DefaultPolicy(),
+	}
+}
+
+// Settings keys of the global PIN length bounds.
+const (
+	SettingMinLength = "min_pin_length"
+	SettingMaxLength = "max_pin_length"
+)
+
+// ParseLengths parses the PIN length settings. Empty values keep the defaults
+// of 4 and 6 digits; lengths must be between 4 and 8 digits.
+func ParseLengths(minValue, maxValue string) (minLength, maxLength int, err error) {
+	minLength, maxLength = 4, 6
+	for _, setting := range []struct {
+		key   string
+		value string
+		dest  *int
+	}{
+		{SettingMinLength, minValue, &minLength},
+		{SettingMaxLength, maxValue, &maxLength},
+	} {
+		v := strings.TrimSpace(setting.value)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 4 || n > 8 {
+			return 4, 6, fmt.Errorf("%s must be a number from 4 to 8", setting.key)
+		}
+		*setting.dest = n
 	}
+	if minLength > maxLength {
+		return 4, 6, fmt.Errorf("%s must not be greater than %s", SettingMinLength, SettingMaxLength)
+	}
+	return minLength, maxLength, nil
+}
+
+// WithPolicy returns a copy of the generator that enforces policy.
+func (g *Generator) WithPolicy(policy Policy) *Generator {
+	configured := *g
+	configured.policy = policy
+	return &configured
+}
+
+// WithPipeline returns a copy of the generator configured for one calendar.
+// methods replaces the method order unless empty, and length, if set, fixes
+// the code length, so a strategy that cannot produce it is skipped.
+func (g *Generator) WithPipeline(methods []string, length *int) (*Generator, error) {
+	if err := ValidatePipeline(methods, length); err != nil {
+		return nil, err
+	}
+
+	configured := *g
+	if len(methods) > 0 {
+		configured.methods = make([]Strategy, 0, len(methods))
+		for _, name := range methods {
+			s, _ := LookupStrategy(name)
+			configured.methods = append(configured.methods, s)
+		}
+	}
+	if length != nil {
+		configured.minLength = *length
+		configured.maxLength = *length
+	}
+	return &configured, nil
 }
 
 // GenerationResult contains the generated PIN and metadata.
@@ -43,13 +119,15 @@ type GenerationResult struct {
 	Success bool
 }
 
-// GenerateFromEvent generates a PIN for a calendar event using the priority chain:
-// 1. Custom PIN (if provided)
-// 2. Phone Last-4 (if pattern found in description)
-// 3. Description-based random
-// 4. Date-based (fallback, always succeeds)
-func (g *Generator) GenerateFromEvent(event models.CalendarEvent, customPIN string) GenerationResult {
-	// 1. Custom PIN (highest priority)
+// GenerateFromEvent generates a PIN for a calendar event: the custom PIN if
+// one is provided and valid, otherwise the first code produced by the
+// generator's methods. With the default methods the chain is phone last-4,
+// description-based random, then date-based, which always succeeds. A
+// pipeline without a method that fits the event returns Success false.
+// Codes the policy rejects are passed over. Methods that draw codes also skip
+// those taken reports; it may be nil.
+func (g *Generator) GenerateFromEvent(event models.CalendarEvent, customPIN string, taken func(code string) bool) GenerationResult {
+	// Custom PIN (highest priority)
 	if customPIN != "" {
 		if g.isValidPIN(customPIN) {
 			return GenerationResult{
@@ -60,116 +138,78 @@ func (g *Generator) GenerateFromEvent(event models.CalendarEvent, customPIN stri
 		}
 	}
 
-	// 2. Phone Last-4 extraction
-	if pin := g.extractPhoneLast4(event.Description); pin != "" {
-		return GenerationResult{
-			PINCode: pin,
-			Method:  models.GenerationMethodPhoneLast4,
-			Success: true,
-		}
+	return g.generateFrom(event, g.methods, taken)
+}
+
+// generateFrom returns the first valid code produced by methods. If the
+// methods only produce codes the policy rejects, the last one is counted up
+// until the policy accepts it and taken does not report it.
+func (g *Generator) generateFrom(event models.CalendarEvent, methods []Strategy, taken func(code string) bool) GenerationResult {
+	rejected := func(code string) bool {
+		return g.policy.Check(code) != nil || (taken != nil && taken(code))
 	}
 
-	// 3. Description-based random (deterministic hash)
-	if event.Description != "" {
-		pin := g.generateFromDescription(event.Description, event.UID)
-		return GenerationResult{
-			PINCode: pin,
-			Method:  models.GenerationMethodDescriptionRandom,
-			Success: true,
+	var weak GenerationResult
+	for _, s := range methods {
+		var pin string
+		var ok bool
+		if d, draws := s.(Drawer); draws {
+			pin, ok = d.Draw(event, g.minLength, rejected)
+		} else {
+			pin, ok = s.Generate(event, g.minLength)
 		}
+		if !ok || !g.hasValidFormat(pin) {
+			continue
+		}
+		if g.policy.Check(pin) != nil {
+			weak = GenerationResult{PINCode: pin, Method: s.Name()}
+			continue
+		}
+		return GenerationResult{PINCode: pin, Method: s.Name(), Success: true}
 	}
 
-	// 4. Date-based (fallback - always succeeds)
-	pin := g.generateFromDates(event.Start, event.End)
-	return GenerationResult{
-		PINCode: pin,
-		Method:  models.GenerationMethodDateBased,
-		Success: true,
+	if weak.PINCode != "" {
+		for i := 1; i <= maxVariations; i++ {
+			if pin := incrementPIN(weak.PINCode, i); g.hasValidFormat(pin) && !rejected(pin) {
+				return GenerationResult{PINCode: pin, Method: weak.Method, Success: true}
+			}
+		}
 	}
+	return GenerationResult{}
 }
 
-// extractPhoneLast4 extracts the last 4 digits from a phone pattern in the description.
-// Looks for patterns like "(Last 4 Digits): XXXX" or "Last 4 Digits: XXXX"
-func (g *Generator) extractPhoneLast4(description string) string {
-	// Pattern: (Last 4 Digits): XXXX or Last 4 Digits: XXXX
-	patterns := []string{
-		`\(Last 4 Digits\):\s*(\d{4})`,
-		`Last 4 Digits:\s*(\d{4})`,
-		`last 4 digits:\s*(\d{4})`,
-		`\(last 4 digits\):\s*(\d{4})`,
-	}
-
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(description); len(matches) > 1 {
-			return matches[1]
+// Draws reports whether any of the generator's methods draws codes, and so
+// needs to be told which codes are taken.
+func (g *Generator) Draws() bool {
+	for _, s := range g.methods {
+		if _, draws := s.(Drawer); draws {
+			return true
 		}
 	}
-
-	return ""
-}
-
-// generateFromDescription generates a deterministic PIN from the event description.
-// The PIN will change if the description changes.
-func (g *Generator) generateFromDescription(description, uid string) string {
-	// Create a hash from description + UID for uniqueness
-	data := description + "|" + uid
-	hash := sha256.Sum256([]byte(data))
-
-	// Convert first bytes to a number and extract digits
-	num := uint64(hash[0])<<24 | uint64(hash[1])<<16 | uint64(hash[2])<<8 | uint64(hash[3])
-
-	// Generate PIN of desired length
-	pin := fmt.Sprintf("%0*d", g.minLength, num%uint64(pow10(g.minLength)))
-
-	return pin
+	return false
 }
 
-// generateFromDates generates a PIN from check-in and check-out dates.
-// Format: check-in day (2 digits) + check-out day (2 digits)
-func (g *Generator) generateFromDates(checkIn, checkOut time.Time) string {
-	inDay := checkIn.Day()
-	outDay := checkOut.Day()
-
-	// Basic format: DDDD (in-day + out-day)
-	pin := fmt.Sprintf("%02d%02d", inDay, outDay)
-
-	// If we need more digits, add month info
-	if g.minLength > 4 {
-		pin = fmt.Sprintf("%02d%02d%02d", checkIn.Month(), inDay, outDay)
-	}
-
-	// Ensure minimum length
-	for len(pin) < g.minLength {
-		pin = "0" + pin
-	}
-
-	// Truncate if longer than max (but don't try to extend)
-	if len(pin) > g.maxLength {
-		return pin[:g.maxLength]
-	}
-
-	return pin
+// isValidPIN checks if a PIN meets the length requirements and the policy.
+func (g *Generator) isValidPIN(pin string) bool {
+	return g.hasValidFormat(pin) && g.policy.Check(pin) == nil
 }
 
-// isValidPIN checks if a PIN meets the length requirements.
-func (g *Generator) isValidPIN(pin string) bool {
+// hasValidFormat checks if a PIN meets the length requirements.
+func (g *Generator) hasValidFormat(pin string) bool {
 	if len(pin) < g.minLength || len(pin) > g.maxLength {
 		return false
 	}
 
 	// Must be all digits
-	for _, c := range pin {
-		if c < '0' || c > '9' {
-			return false
-		}
-	}
-
-	return true
+	return isDigits(pin)
 }
 
-// ValidatePIN checks if a PIN is valid and returns an error message if not.
+// ValidatePIN checks if a PIN is valid and meets the policy, and returns an
+// error message if not.
 func (g *Generator) ValidatePIN(pin string) error {
+	if g.minLength == g.maxLength && len(pin) != g.minLength {
+		return fmt.Errorf("PIN must be %d digits", g.minLength)
+	}
 	if len(pin) < g.minLength {
 		return fmt.Errorf("PIN must be at least %d digits", g.minLength)
 	}
@@ -177,42 +217,34 @@ func (g *Generator) ValidatePIN(pin string) error {
 		return fmt.Errorf("PIN must be at most %d digits", g.maxLength)
 	}
 
-	for _, c := range pin {
-		if c < '0' || c > '9' {
-			return fmt.Errorf("PIN must contain only digits")
-		}
+	if !isDigits(pin) {
+		return fmt.Errorf("PIN must contain only digits")
 	}
 
-	return nil
+	return g.policy.Check(pin)
 }
 
-// RegeneratePIN generates a new PIN using the next available method after the current one.
-func (g *Generator) RegeneratePIN(event models.CalendarEvent, currentMethod string) GenerationResult {
-	switch currentMethod {
-	case models.GenerationMethodCustom:
-		// Try phone last-4 first
-		if pin := g.extractPhoneLast4(event.Description); pin != "" {
-			return GenerationResult{PINCode: pin, Method: models.GenerationMethodPhoneLast4, Success: true}
-		}
-		// Fall through to description-based
-		fallthrough
-	case models.GenerationMethodPhoneLast4:
-		// Try description-based
-		if event.Description != "" {
-			pin := g.generateFromDescription(event.Description, event.UID)
-			return GenerationResult{PINCode: pin, Method: models.GenerationMethodDescriptionRandom, Success: true}
+// RegeneratePIN generates a new PIN using the next available method after the
+// current one. Custom PINs, and methods the generator no longer uses, start
+// over from the first method. Once the chain is exhausted the last method is
+// used again, so callers must vary its code themselves unless it draws codes.
+// Methods that draw codes skip those taken reports; it may be nil.
+func (g *Generator) RegeneratePIN(event models.CalendarEvent, currentMethod string, taken func(code string) bool) GenerationResult {
+	next := 0
+	for i, s := range g.methods {
+		if s.Name() == currentMethod {
+			next = i + 1
 		}
-		// Fall through to date-based
-		fallthrough
-	case models.GenerationMethodDescriptionRandom:
-		// Use date-based as final fallback
-		pin := g.generateFromDates(event.Start, event.End)
-		return GenerationResult{PINCode: pin, Method: models.GenerationMethodDateBased, Success: true}
-	default:
-		// Already at date-based, regenerate with slight variation
-		pin := g.generateFromDates(event.Start, event.End)
-		return GenerationResult{PINCode: pin, Method: models.GenerationMethodDateBased, Success: true}
 	}
+
+	if result := g.generateFrom(event, g.methods[next:], taken); result.Success {
+		return result
+	}
+	if next > 0 {
+		// Already at the end of the chain
+		return g.generateFrom(event, g.methods[next-1:], taken)
+	}
+	return GenerationResult{}
 }
 
 // pow10 returns 10^n.
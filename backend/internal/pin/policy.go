@@ -0,0 +1,206 @@
+package pin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Policy is the strength policy PIN codes must meet. Every PIN source is
+// checked: generated and custom guest PINs and static PINs. Check covers a
+// code on its own; Clash compares it with another code on the same lock.
+type Policy struct {
+	RejectSequences bool     // One digit or block repeated, or a run counting up or down (1111, 1212, 1234, 9876)
+	RejectCommon    bool     // Codes on the common PIN blocklist
+	RejectYears     bool     // 4-digit codes that read as a year from 1900 to 2099
+	RejectPrefixes  bool     // Codes that begin with another code on the same lock, which some locks accept early
+	MinDistance     int      // Digits same-length codes on a lock must differ in; 0 or 1 only forbids equal codes
+	Blocklist       []string // Codes rejected in addition to the common ones
+}
+
+// DefaultPolicy is the policy used when no settings are stored.
+func DefaultPolicy() Policy {
+	return Policy{
+		RejectSequences: true,
+		RejectCommon:    true,
+		RejectYears:     true,
+		RejectPrefixes:  true,
+		MinDistance:     1,
+	}
+}
+
+// Settings keys of the PIN policy.
+const (
+	SettingRejectSequences = "pin_reject_sequences"
+	SettingRejectCommon    = "pin_reject_common"
+	SettingRejectYears     = "pin_reject_years"
+	SettingRejectPrefixes  = "pin_reject_prefixes"
+	SettingMinDistance     = "pin_min_distance"
+	SettingBlocklist       = "pin_blocklist" // Comma separated codes
+)
+
+// PolicySettings lists the settings keys ParsePolicy reads.
+var PolicySettings = []string{
+	SettingRejectSequences,
+	SettingRejectCommon,
+	SettingRejectYears,
+	SettingRejectPrefixes,
+	SettingMinDistance,
+	SettingBlocklist,
+}
+
+// ParsePolicy builds a policy from settings values. Missing or empty values
+// keep the default.
+func ParsePolicy(settings map[string]string) (Policy, error) {
+	p := DefaultPolicy()
+
+	flags := map[string]*bool{
+		SettingRejectSequences: &p.RejectSequences,
+		SettingRejectCommon:    &p.RejectCommon,
+		SettingRejectYears:     &p.RejectYears,
+		SettingRejectPrefixes:  &p.RejectPrefixes,
+	}
+	for key, flag := range flags {
+		if v := strings.TrimSpace(settings[key]); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("%s must be true or false", key)
+			}
+			*flag = b
+		}
+	}
+
+	if v := strings.TrimSpace(settings[SettingMinDistance]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 8 {
+			return p, fmt.Errorf("%s must be a number from 0 to 8", SettingMinDistance)
+		}
+		p.MinDistance = n
+	}
+
+	for _, code := range strings.Split(settings[SettingBlocklist], ",") {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		if !isDigits(code) {
+			return p, fmt.Errorf("%s must be a comma separated list of digit codes", SettingBlocklist)
+		}
+		p.Blocklist = append(p.Blocklist, code)
+	}
+
+	return p, nil
+}
+
+// commonPINs are among the most frequently chosen PINs. Repeated digits and
+// plain sequences are caught by RejectSequences, and 19xx and 20xx codes by
+// RejectYears, so only the other common choices are listed.
+var commonPINs = map[string]bool{
+	"1004": true, "1122": true, "1313": true, "6969": true, "1230": true,
+	"2580": true, "0852": true, "1470": true, "0741": true, "1379": true,
+	"7410": true, "1478": true, "2468": true, "1357": true, "1590": true,
+	"112233": true, "123321": true, "159753": true, "147258": true,
+	"159357": true, "007007": true, "520520": true, "147852": true,
+	"258852": true, "789456": true, "696969": true,
+}
+
+// Errors reported by Policy.Check.
+var (
+	ErrSequentialPIN = errors.New("PIN must not be a repeated digit or pattern, or a sequence such as 1111, 1212, 1234 or 9876")
+	ErrCommonPIN     = errors.New("PIN is on the list of commonly used PINs")
+	ErrYearPIN       = errors.New("PIN must not look like a year (1900-2099)")
+)
+
+// Check returns why code alone does not meet the policy, or nil.
+func (p Policy) Check(code string) error {
+	if p.RejectSequences && (isRepeated(code) || isSequence(code)) {
+		return ErrSequentialPIN
+	}
+	if p.RejectCommon && commonPINs[code] {
+		return ErrCommonPIN
+	}
+	for _, blocked := range p.Blocklist {
+		if code == blocked {
+			return ErrCommonPIN
+		}
+	}
+	if p.RejectYears && len(code) == 4 && (strings.HasPrefix(code, "19") || strings.HasPrefix(code, "20")) {
+		return ErrYearPIN
+	}
+	return nil
+}
+
+// Conflict reasons, reported in Conflict.Reason.
+const (
+	ConflictReasonSameCode = "same_code" // Both PINs use the code
+	ConflictReasonPrefix   = "prefix"    // One code begins with the other
+	ConflictReasonSimilar  = "similar"   // The codes differ in fewer than MinDistance digits
+)
+
+// Clash returns the reason code and other cannot both be on one lock, or ""
+// if they can.
+func (p Policy) Clash(code, other string) string {
+	switch {
+	case code == other:
+		return ConflictReasonSameCode
+	case p.RejectPrefixes && (strings.HasPrefix(code, other) || strings.HasPrefix(other, code)):
+		return ConflictReasonPrefix
+	case len(code) == len(other) && hammingDistance(code, other) < p.MinDistance:
+		return ConflictReasonSimilar
+	}
+	return ""
+}
+
+// ClashError describes a clash reason found by Clash as a validation error.
+func (p Policy) ClashError(reason string) error {
+	switch reason {
+	case ConflictReasonSameCode:
+		return errors.New("PIN is already used by another PIN on the same lock")
+	case ConflictReasonPrefix:
+		return errors.New("PIN must not begin with, or be the beginning of, another PIN on the same lock")
+	case ConflictReasonSimilar:
+		return fmt.Errorf("PIN must differ from other PINs on the same lock in at least %d digits", p.MinDistance)
+	}
+	return nil
+}
+
+// isRepeated reports whether code is one block of digits repeated, such as
+// 1111, 1212 or 123123.
+func isRepeated(code string) bool {
+	for size := 1; size <= len(code)/2; size++ {
+		if len(code)%size == 0 && strings.Repeat(code[:size], len(code)/size) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isSequence reports whether each digit of code is one more, or each one
+// less, than the one before.
+func isSequence(code string) bool {
+	if len(code) < 2 {
+		return false
+	}
+	step := int(code[1]) - int(code[0])
+	if step != 1 && step != -1 {
+		return false
+	}
+	for i := 2; i < len(code); i++ {
+		if int(code[i])-int(code[i-1]) != step {
+			return false
+		}
+	}
+	return true
+}
+
+// hammingDistance counts the positions at which two same-length codes differ.
+func hammingDistance(a, b string) int {
+	d := 0
+	for i := range a {
+		if a[i] != b[i] {
+			d++
+		}
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package pin
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// maxDraws bounds how many codes randomStrategy draws before giving up.
+const maxDraws = 100
+
+// randomStrategy draws uniformly random codes from crypto/rand. Unlike the
+// description-based method its codes carry nothing about the guest or stay.
+type randomStrategy struct{}
+
+func (randomStrategy) Name() string { return models.GenerationMethodRandom }
+
+func (s randomStrategy) Generate(event models.CalendarEvent, length int) (string, bool) {
+	return s.Draw(event, length, nil)
+}
+
+// Draw returns the first drawn code that taken does not report.
+func (randomStrategy) Draw(_ models.CalendarEvent, length int, taken func(code string) bool) (string, bool) {
+	limit := big.NewInt(int64(pow10(length)))
+	for i := 0; i < maxDraws; i++ {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			log.Printf("Failed to draw random PIN: %v", err)
+			return "", false
+		}
+		code := fmt.Sprintf("%0*d", length, n.Int64())
+		if taken == nil || !taken(code) {
+			return code, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,208 @@
+package pin
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// Strategy produces a PIN code for a calendar event. A Generator tries its
+// strategies in order until one produces a code; the strategy's name is
+// recorded as the PIN's generation method.
+type Strategy interface {
+	// Name identifies the strategy in calendar configuration and on PINs.
+	Name() string
+	// Generate returns a code of exactly length digits for event, or false
+	// when the event lacks what the strategy needs.
+	Generate(event models.CalendarEvent, length int) (string, bool)
+}
+
+// Drawer is implemented by strategies that draw codes at random instead of
+// deriving them from the event. Draw returns a code for which taken, if not
+// nil, reports false. A drawn code is kept until the stay's dates change.
+type Drawer interface {
+	Draw(event models.CalendarEvent, length int, taken func(code string) bool) (string, bool)
+}
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]Strategy{}
+)
+
+// DefaultMethods is the method order used by calendars that configure none.
+var DefaultMethods = []string{
+	models.GenerationMethodPhoneLast4,
+	models.GenerationMethodDescriptionRandom,
+	models.GenerationMethodDateBased,
+}
+
+func init() {
+	RegisterStrategy(phoneLast4Strategy{})
+	RegisterStrategy(descriptionStrategy{})
+	RegisterStrategy(dateStrategy{})
+	RegisterStrategy(randomStrategy{})
+}
+
+// RegisterStrategy makes a strategy available to calendar pipelines under its
+// name, replacing any strategy registered with the same name. The custom
+// method is reserved for owner-specified PINs.
+func RegisterStrategy(s Strategy) {
+	if s.Name() == models.GenerationMethodCustom {
+		panic("pin: strategy name " + models.GenerationMethodCustom + " is reserved")
+	}
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[s.Name()] = s
+}
+
+// LookupStrategy returns the strategy registered under name.
+func LookupStrategy(name string) (Strategy, bool) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	s, ok := strategies[name]
+	return s, ok
+}
+
+// StrategyNames returns the names of all registered strategies, sorted.
+func StrategyNames() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DependsOnStay reports whether a PIN generated with method gets a new code
+// when its stay's dates change: date-based codes are built from them, and
+// drawn codes belong to the stay they were drawn for.
+func DependsOnStay(method string) bool {
+	if method == models.GenerationMethodDateBased {
+		return true
+	}
+	s, ok := LookupStrategy(method)
+	if !ok {
+		return false
+	}
+	_, draws := s.(Drawer)
+	return draws
+}
+
+// ValidatePipeline checks a calendar's PIN generation configuration: every
+// method must be registered and listed once, and a length, if set, must be
+// between 4 and 8 digits.
+func ValidatePipeline(methods []string, length *int) error {
+	seen := make(map[string]bool, len(methods))
+	for _, name := range methods {
+		if _, ok := LookupStrategy(name); !ok {
+			return fmt.Errorf("unknown PIN method %q (available: %v)", name, StrategyNames())
+		}
+		if seen[name] {
+			return fmt.Errorf("PIN method %q is listed more than once", name)
+		}
+		seen[name] = true
+	}
+	if length != nil && (*length < 4 || *length > 8) {
+		return fmt.Errorf("PIN length must be between 4 and 8 digits")
+	}
+	return nil
+}
+
+// phoneLast4Strategy uses the last four digits of the guest's phone number,
+// from the platform adapter's structured field or a pattern in the
+// description. It only produces 4-digit codes.
+type phoneLast4Strategy struct{}
+
+// phonePatterns match patterns like "(Last 4 Digits): XXXX" or "Last 4 Digits: XXXX".
+var phonePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\(Last 4 Digits\):\s*(\d{4})`),
+	regexp.MustCompile(`Last 4 Digits:\s*(\d{4})`),
+	regexp.MustCompile(`last 4 digits:\s*(\d{4})`),
+	regexp.MustCompile(`\(last 4 digits\):\s*(\d{4})`),
+}
+
+func (phoneLast4Strategy) Name() string { return models.GenerationMethodPhoneLast4 }
+
+func (phoneLast4Strategy) Generate(event models.CalendarEvent, length int) (string, bool) {
+	if length != 4 {
+		return "", false
+	}
+	if isDigits(event.PhoneLast4) && len(event.PhoneLast4) == 4 {
+		return event.PhoneLast4, true
+	}
+	for _, re := range phonePatterns {
+		if matches := re.FindStringSubmatch(event.Description); len(matches) > 1 {
+			return matches[1], true
+		}
+	}
+	return "", false
+}
+
+// descriptionStrategy derives a deterministic code from the event description
+// and UID. The code changes if the description changes.
+type descriptionStrategy struct{}
+
+func (descriptionStrategy) Name() string { return models.GenerationMethodDescriptionRandom }
+
+func (descriptionStrategy) Generate(event models.CalendarEvent, length int) (string, bool) {
+	if event.Description == "" {
+		return "", false
+	}
+
+	// Create a hash from description + UID for uniqueness
+	hash := sha256.Sum256([]byte(event.Description + "|" + event.UID))
+
+	// Convert first bytes to a number and extract digits
+	num := uint64(hash[0])<<24 | uint64(hash[1])<<16 | uint64(hash[2])<<8 | uint64(hash[3])
+
+	return fmt.Sprintf("%0*d", length, num%uint64(pow10(length))), true
+}
+
+// dateStrategy builds a code from the check-in and check-out days (DDDD).
+// Longer codes are prefixed with the last digit of the check-in year (5
+// digits), the check-in month (6), the year digit and month (7), or the last
+// two year digits and month (8). It always succeeds for those lengths.
+type dateStrategy struct{}
+
+func (dateStrategy) Name() string { return models.GenerationMethodDateBased }
+
+func (dateStrategy) Generate(event models.CalendarEvent, length int) (string, bool) {
+	days := fmt.Sprintf("%02d%02d", event.Start.Day(), event.End.Day())
+	year, month := event.Start.Year(), int(event.Start.Month())
+
+	var pin string
+	switch length {
+	case 4:
+		pin = days
+	case 5:
+		pin = fmt.Sprintf("%d%s", year%10, days)
+	case 6:
+		pin = fmt.Sprintf("%02d%s", month, days)
+	case 7:
+		pin = fmt.Sprintf("%d%02d%s", year%10, month, days)
+	case 8:
+		pin = fmt.Sprintf("%02d%02d%s", year%100, month, days)
+	default:
+		return "", false
+	}
+	return pin, true
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// CalDAVRepository stores the CalDAV objects and sync-token of CalDAV calendars.
+type CalDAVRepository struct {
+	BaseRepository
+}
+
+// NewCalDAVRepository creates a new CalDAV repository.
+func NewCalDAVRepository(db *DB) *CalDAVRepository {
+	return &CalDAVRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// GetSyncToken returns a calendar's stored sync-token, or "" if it has none.
+func (r *CalDAVRepository) GetSyncToken(ctx context.Context, calendarID string) (string, error) {
+	var token sql.NullString
+	err := r.DB().QueryRowContext(ctx, `
+		SELECT caldav_sync_token FROM calendar_subscriptions WHERE id = ?
+	`, calendarID).Scan(&token)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("querying sync token: %w", err)
+	}
+
+	return token.String, nil
+}
+
+// ListObjects returns a calendar's stored objects ordered by href.
+func (r *CalDAVRepository) ListObjects(ctx context.Context, calendarID string) ([]models.CalDAVObject, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT href, etag, data FROM caldav_objects WHERE calendar_id = ? ORDER BY href
+	`, calendarID)
+	if err != nil {
+		return nil, fmt.Errorf("querying caldav objects: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []models.CalDAVObject
+	for rows.Next() {
+		var obj models.CalDAVObject
+		var etag sql.NullString
+		if err := rows.Scan(&obj.Href, &etag, &obj.Data); err != nil {
+			return nil, fmt.Errorf("scanning caldav object: %w", err)
+		}
+		obj.ETag = etag.String
+		objects = append(objects, obj)
+	}
+
+	return objects, rows.Err()
+}
+
+// SaveChanges applies a sync to a calendar's stored objects and records its
+// new sync-token. A full sync replaces all stored objects with changed.
+func (r *CalDAVRepository) SaveChanges(ctx context.Context, calendarID, syncToken string, full bool, changed []models.CalDAVObject, deleted []string) error {
+	return r.Transaction(func(tx *sql.Tx) error {
+		if full {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM caldav_objects WHERE calendar_id = ?", calendarID); err != nil {
+				return fmt.Errorf("clearing caldav objects: %w", err)
+			}
+		}
+
+		for _, href := range deleted {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM caldav_objects WHERE calendar_id = ? AND href = ?
+			`, calendarID, href); err != nil {
+				return fmt.Errorf("deleting caldav object: %w", err)
+			}
+		}
+
+		for _, obj := range changed {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO caldav_objects (calendar_id, href, etag, data) VALUES (?, ?, ?, ?)
+				ON CONFLICT(calendar_id, href) DO UPDATE SET etag = excluded.etag, data = excluded.data
+			`, calendarID, obj.Href, obj.ETag, obj.Data); err != nil {
+				return fmt.Errorf("saving caldav object: %w", err)
+			}
+		}
+
+		var token *string
+		if syncToken != "" {
+			token = &syncToken
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE calendar_subscriptions SET caldav_sync_token = ? WHERE id = ?
+		`, token, calendarID); err != nil {
+			return fmt.Errorf("saving sync token: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -3,12 +3,122 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/guest-lock-manager/backend/internal/storage/models"
 )
 
+// calendarColumns is the column list read by scanCalendar.
+const calendarColumns = `id, name, url, source_type, sync_interval_min, last_sync_at, sync_status,
+		       sync_error, enabled, platform, event_rules, timezone, checkin_time, checkout_time,
+		       removal_grace_syncs, removal_grace_min, anomaly_threshold_pct, anomaly_reason, anomaly_detected_at,
+		       feed_etag, feed_last_modified, feed_content_hash, feed_processed_at,
+		       auth_type, auth_credentials, fetch_headers, max_feed_bytes, redirect_policy, ca_bundle, reservation_mapping,
+		       priority, pin_methods, pin_length, created_at, updated_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCalendar scans a row selected with calendarColumns, decrypting the
+// feed credentials with db's secret key.
+func scanCalendar(db *DB, row rowScanner, cal *models.CalendarSubscription) error {
+	var eventRules, credentials, headers, mapping, pinMethods *string
+	var authType string
+	err := row.Scan(
+		&cal.ID, &cal.Name, &cal.URL, &cal.SourceType, &cal.SyncIntervalMin,
+		&cal.LastSyncAt, &cal.SyncStatus, &cal.SyncError,
+		&cal.Enabled, &cal.Platform, &eventRules, &cal.Timezone, &cal.CheckinTime, &cal.CheckoutTime,
+		&cal.RemovalGraceSyncs, &cal.RemovalGraceMin, &cal.AnomalyThresholdPct, &cal.AnomalyReason, &cal.AnomalyDetectedAt,
+		&cal.FeedETag, &cal.FeedLastModified, &cal.FeedContentHash, &cal.FeedProcessedAt,
+		&authType, &credentials, &headers, &cal.Fetch.MaxBytes, &cal.Fetch.RedirectPolicy, &cal.Fetch.CABundle,
+		&mapping, &cal.Priority, &pinMethods, &cal.PINLength, &cal.CreatedAt, &cal.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	cal.EventRules, err = models.ParseEventRules(eventRules)
+	if err != nil {
+		return fmt.Errorf("decoding event rules for calendar %s: %w", cal.ID, err)
+	}
+	cal.ReservationMapping, err = models.ParseReservationMapping(mapping)
+	if err != nil {
+		return fmt.Errorf("decoding reservation mapping for calendar %s: %w", cal.ID, err)
+	}
+	cal.PINMethods, err = models.ParsePINMethods(pinMethods)
+	if err != nil {
+		return fmt.Errorf("decoding PIN methods for calendar %s: %w", cal.ID, err)
+	}
+	if headers != nil {
+		if err := json.Unmarshal([]byte(*headers), &cal.Fetch.Headers); err != nil {
+			return fmt.Errorf("decoding fetch headers for calendar %s: %w", cal.ID, err)
+		}
+	}
+	if authType != models.FeedAuthNone && credentials != nil {
+		cal.Fetch.Auth, err = DecryptFeedAuth(db, *credentials)
+		if err != nil {
+			return fmt.Errorf("decoding credentials for calendar %s: %w", cal.ID, err)
+		}
+	}
+	return nil
+}
+
+// EncryptFeedAuth returns the auth type and encrypted credentials to store
+// for auth. A nil auth is stored as type none without credentials.
+func EncryptFeedAuth(db *DB, auth *models.FeedAuth) (string, *string, error) {
+	if auth == nil || auth.Type == models.FeedAuthNone {
+		return models.FeedAuthNone, nil, nil
+	}
+
+	box, err := db.Secrets()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", nil, fmt.Errorf("encoding credentials: %w", err)
+	}
+	sealed, err := box.Encrypt(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return auth.Type, &sealed, nil
+}
+
+// DecryptFeedAuth opens credentials stored by EncryptFeedAuth.
+func DecryptFeedAuth(db *DB, sealed string) (*models.FeedAuth, error) {
+	box, err := db.Secrets()
+	if err != nil {
+		return nil, err
+	}
+	data, err := box.Decrypt(sealed)
+	if err != nil {
+		return nil, err
+	}
+	var auth models.FeedAuth
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, fmt.Errorf("decoding credentials: %w", err)
+	}
+	return &auth, nil
+}
+
+// EncodeFetchHeaders encodes custom feed headers for storage; no headers are stored as NULL.
+func EncodeFetchHeaders(headers map[string]string) (*string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
 // CalendarRepository provides data access for calendar subscriptions.
 type CalendarRepository struct {
 	BaseRepository
@@ -27,14 +137,46 @@ func (r *CalendarRepository) Create(ctx context.Context, cal *models.CalendarSub
 	cal.CreatedAt = r.Now()
 	cal.UpdatedAt = r.Now()
 	cal.SyncStatus = models.SyncStatusPending
+	if cal.Platform == "" {
+		cal.Platform = models.PlatformGeneric
+	}
+	if cal.SourceType == "" {
+		cal.SourceType = models.SourceICal
+	}
+	if cal.Fetch.RedirectPolicy == "" {
+		cal.Fetch.RedirectPolicy = models.RedirectFollow
+	}
 
-	_, err := r.DB().ExecContext(ctx, `
+	eventRules, err := models.EncodeEventRules(cal.EventRules)
+	if err != nil {
+		return fmt.Errorf("encoding event rules: %w", err)
+	}
+	authType, credentials, headers, err := r.encodeFetchOptions(cal.Fetch)
+	if err != nil {
+		return err
+	}
+	mapping, err := models.EncodeReservationMapping(cal.ReservationMapping)
+	if err != nil {
+		return fmt.Errorf("encoding reservation mapping: %w", err)
+	}
+	pinMethods, err := models.EncodePINMethods(cal.PINMethods)
+	if err != nil {
+		return fmt.Errorf("encoding PIN methods: %w", err)
+	}
+
+	_, err = r.DB().ExecContext(ctx, `
 		INSERT INTO calendar_subscriptions (
-			id, name, url, sync_interval_min, sync_status, enabled, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			id, name, url, source_type, sync_interval_min, sync_status, enabled, platform, event_rules,
+			timezone, checkin_time, checkout_time, removal_grace_syncs, removal_grace_min,
+			anomaly_threshold_pct, auth_type, auth_credentials, fetch_headers, max_feed_bytes,
+			redirect_policy, ca_bundle, reservation_mapping, priority, pin_methods, pin_length, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
-		cal.ID, cal.Name, cal.URL, cal.SyncIntervalMin,
-		cal.SyncStatus, cal.Enabled, cal.CreatedAt, cal.UpdatedAt,
+		cal.ID, cal.Name, cal.URL, cal.SourceType, cal.SyncIntervalMin,
+		cal.SyncStatus, cal.Enabled, cal.Platform, eventRules,
+		cal.Timezone, cal.CheckinTime, cal.CheckoutTime, cal.RemovalGraceSyncs, cal.RemovalGraceMin,
+		cal.AnomalyThresholdPct, authType, credentials, headers, cal.Fetch.MaxBytes,
+		cal.Fetch.RedirectPolicy, cal.Fetch.CABundle, mapping, cal.Priority, pinMethods, cal.PINLength, cal.CreatedAt, cal.UpdatedAt,
 	)
 
 	if err != nil {
@@ -48,15 +190,10 @@ func (r *CalendarRepository) Create(ctx context.Context, cal *models.CalendarSub
 func (r *CalendarRepository) GetByID(ctx context.Context, id string) (*models.CalendarSubscription, error) {
 	cal := &models.CalendarSubscription{}
 
-	err := r.DB().QueryRowContext(ctx, `
-		SELECT id, name, url, sync_interval_min, last_sync_at, sync_status, 
-		       sync_error, enabled, created_at, updated_at
+	err := scanCalendar(r.DB(), r.DB().QueryRowContext(ctx, `
+		SELECT `+calendarColumns+`
 		FROM calendar_subscriptions WHERE id = ?
-	`, id).Scan(
-		&cal.ID, &cal.Name, &cal.URL, &cal.SyncIntervalMin,
-		&cal.LastSyncAt, &cal.SyncStatus, &cal.SyncError,
-		&cal.Enabled, &cal.CreatedAt, &cal.UpdatedAt,
-	)
+	`, id), cal)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -71,8 +208,7 @@ func (r *CalendarRepository) GetByID(ctx context.Context, id string) (*models.Ca
 // List retrieves all calendar subscriptions.
 func (r *CalendarRepository) List(ctx context.Context) ([]models.CalendarSubscription, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, name, url, sync_interval_min, last_sync_at, sync_status,
-		       sync_error, enabled, created_at, updated_at
+		SELECT `+calendarColumns+`
 		FROM calendar_subscriptions
 		ORDER BY name
 	`)
@@ -84,11 +220,7 @@ func (r *CalendarRepository) List(ctx context.Context) ([]models.CalendarSubscri
 	var calendars []models.CalendarSubscription
 	for rows.Next() {
 		var cal models.CalendarSubscription
-		if err := rows.Scan(
-			&cal.ID, &cal.Name, &cal.URL, &cal.SyncIntervalMin,
-			&cal.LastSyncAt, &cal.SyncStatus, &cal.SyncError,
-			&cal.Enabled, &cal.CreatedAt, &cal.UpdatedAt,
-		); err != nil {
+		if err := scanCalendar(r.DB(), rows, &cal); err != nil {
 			return nil, fmt.Errorf("scanning calendar: %w", err)
 		}
 		calendars = append(calendars, cal)
@@ -100,8 +232,7 @@ func (r *CalendarRepository) List(ctx context.Context) ([]models.CalendarSubscri
 // ListEnabled retrieves all enabled calendars that need syncing.
 func (r *CalendarRepository) ListEnabled(ctx context.Context) ([]models.CalendarSubscription, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, name, url, sync_interval_min, last_sync_at, sync_status,
-		       sync_error, enabled, created_at, updated_at
+		SELECT `+calendarColumns+`
 		FROM calendar_subscriptions
 		WHERE enabled = 1
 		ORDER BY last_sync_at ASC NULLS FIRST
@@ -114,11 +245,7 @@ func (r *CalendarRepository) ListEnabled(ctx context.Context) ([]models.Calendar
 	var calendars []models.CalendarSubscription
 	for rows.Next() {
 		var cal models.CalendarSubscription
-		if err := rows.Scan(
-			&cal.ID, &cal.Name, &cal.URL, &cal.SyncIntervalMin,
-			&cal.LastSyncAt, &cal.SyncStatus, &cal.SyncError,
-			&cal.Enabled, &cal.CreatedAt, &cal.UpdatedAt,
-		); err != nil {
+		if err := scanCalendar(r.DB(), rows, &cal); err != nil {
 			return nil, fmt.Errorf("scanning calendar: %w", err)
 		}
 		calendars = append(calendars, cal)
@@ -131,12 +258,45 @@ func (r *CalendarRepository) ListEnabled(ctx context.Context) ([]models.Calendar
 func (r *CalendarRepository) Update(ctx context.Context, cal *models.CalendarSubscription) error {
 	cal.UpdatedAt = r.Now()
 
+	if cal.SourceType == "" {
+		cal.SourceType = models.SourceICal
+	}
+	if cal.Fetch.RedirectPolicy == "" {
+		cal.Fetch.RedirectPolicy = models.RedirectFollow
+	}
+
+	eventRules, err := models.EncodeEventRules(cal.EventRules)
+	if err != nil {
+		return fmt.Errorf("encoding event rules: %w", err)
+	}
+	authType, credentials, headers, err := r.encodeFetchOptions(cal.Fetch)
+	if err != nil {
+		return err
+	}
+	mapping, err := models.EncodeReservationMapping(cal.ReservationMapping)
+	if err != nil {
+		return fmt.Errorf("encoding reservation mapping: %w", err)
+	}
+	pinMethods, err := models.EncodePINMethods(cal.PINMethods)
+	if err != nil {
+		return fmt.Errorf("encoding PIN methods: %w", err)
+	}
+
 	result, err := r.DB().ExecContext(ctx, `
 		UPDATE calendar_subscriptions SET
-			name = ?, url = ?, sync_interval_min = ?, enabled = ?, updated_at = ?
+			name = ?, url = ?, source_type = ?, sync_interval_min = ?, enabled = ?, platform = ?, event_rules = ?,
+			timezone = ?, checkin_time = ?, checkout_time = ?,
+			removal_grace_syncs = ?, removal_grace_min = ?, anomaly_threshold_pct = ?,
+			auth_type = ?, auth_credentials = ?, fetch_headers = ?, max_feed_bytes = ?, redirect_policy = ?, ca_bundle = ?,
+			reservation_mapping = ?, priority = ?, pin_methods = ?, pin_length = ?,
+			feed_etag = NULL, feed_last_modified = NULL, feed_content_hash = NULL, caldav_sync_token = NULL, updated_at = ?
 		WHERE id = ?
 	`,
-		cal.Name, cal.URL, cal.SyncIntervalMin, cal.Enabled, cal.UpdatedAt, cal.ID,
+		cal.Name, cal.URL, cal.SourceType, cal.SyncIntervalMin, cal.Enabled, cal.Platform, eventRules,
+		cal.Timezone, cal.CheckinTime, cal.CheckoutTime,
+		cal.RemovalGraceSyncs, cal.RemovalGraceMin, cal.AnomalyThresholdPct,
+		authType, credentials, headers, cal.Fetch.MaxBytes, cal.Fetch.RedirectPolicy, cal.Fetch.CABundle,
+		mapping, cal.Priority, pinMethods, cal.PINLength, cal.UpdatedAt, cal.ID,
 	)
 
 	if err != nil {
@@ -151,6 +311,163 @@ func (r *CalendarRepository) Update(ctx context.Context, cal *models.CalendarSub
 	return nil
 }
 
+// encodeFetchOptions returns the stored form of a calendar's auth type,
+// encrypted credentials and custom headers.
+func (r *CalendarRepository) encodeFetchOptions(opts models.FetchOptions) (string, *string, *string, error) {
+	authType, credentials, err := EncryptFeedAuth(r.DB(), opts.Auth)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("encrypting credentials: %w", err)
+	}
+	headers, err := EncodeFetchHeaders(opts.Headers)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("encoding fetch headers: %w", err)
+	}
+	return authType, credentials, headers, nil
+}
+
+// GetUpload returns the ICS body uploaded for a calendar, or nil if none has
+// been uploaded.
+func (r *CalendarRepository) GetUpload(ctx context.Context, id string) ([]byte, error) {
+	var body []byte
+	err := r.DB().QueryRowContext(ctx, `
+		SELECT body FROM calendar_uploads WHERE calendar_id = ?
+	`, id).Scan(&body)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying calendar upload: %w", err)
+	}
+
+	return body, nil
+}
+
+// GetSyncBaseline returns what the last accepted sync of a calendar saw, or nil
+// if the calendar has never synced cleanly.
+func (r *CalendarRepository) GetSyncBaseline(ctx context.Context, id string) (*models.SyncBaseline, error) {
+	var count *int
+	var reservations *string
+	err := r.DB().QueryRowContext(ctx, `
+		SELECT last_good_event_count, last_good_reservations FROM calendar_subscriptions WHERE id = ?
+	`, id).Scan(&count, &reservations)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying sync baseline: %w", err)
+	}
+	if count == nil {
+		return nil, nil
+	}
+
+	baseline := &models.SyncBaseline{EventCount: *count, Reservations: map[string]time.Time{}}
+	if reservations != nil && *reservations != "" {
+		if err := json.Unmarshal([]byte(*reservations), &baseline.Reservations); err != nil {
+			return nil, fmt.Errorf("decoding sync baseline: %w", err)
+		}
+	}
+
+	return baseline, nil
+}
+
+// SaveSyncBaseline records the result of an accepted sync as the new baseline.
+func (r *CalendarRepository) SaveSyncBaseline(ctx context.Context, id string, baseline models.SyncBaseline) error {
+	reservations, err := json.Marshal(baseline.Reservations)
+	if err != nil {
+		return fmt.Errorf("encoding sync baseline: %w", err)
+	}
+
+	_, err = r.DB().ExecContext(ctx, `
+		UPDATE calendar_subscriptions SET
+			last_good_event_count = ?, last_good_reservations = ?
+		WHERE id = ?
+	`, baseline.EventCount, string(reservations), id)
+
+	if err != nil {
+		return fmt.Errorf("saving sync baseline: %w", err)
+	}
+
+	return nil
+}
+
+// SaveFeedState records the validators and content hash of a fully processed
+// feed, so later syncs can skip it while it is unchanged.
+func (r *CalendarRepository) SaveFeedState(ctx context.Context, id, etag, lastModified, contentHash string) error {
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE calendar_subscriptions SET
+			feed_etag = ?, feed_last_modified = ?, feed_content_hash = ?, feed_processed_at = ?
+		WHERE id = ?
+	`, nullString(etag), nullString(lastModified), nullString(contentHash), r.Now(), id)
+
+	if err != nil {
+		return fmt.Errorf("saving feed state: %w", err)
+	}
+
+	return nil
+}
+
+// ClearFeedState forgets a calendar's feed validators so the next sync
+// processes the feed in full, e.g. after its settings or locks change.
+func (r *CalendarRepository) ClearFeedState(ctx context.Context, id string) error {
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE calendar_subscriptions SET
+			feed_etag = NULL, feed_last_modified = NULL, feed_content_hash = NULL
+		WHERE id = ?
+	`, id)
+
+	if err != nil {
+		return fmt.Errorf("clearing feed state: %w", err)
+	}
+
+	return nil
+}
+
+// nullString maps an empty string to NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// SetAnomaly records the anomaly holding back a calendar's removals, or clears
+// it when reason is nil. The detection time is kept while the anomaly persists.
+func (r *CalendarRepository) SetAnomaly(ctx context.Context, id string, reason *string) error {
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE calendar_subscriptions SET
+			anomaly_reason = ?,
+			anomaly_detected_at = CASE WHEN ? IS NULL THEN NULL ELSE COALESCE(anomaly_detected_at, ?) END
+		WHERE id = ?
+	`, reason, reason, time.Now().UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("updating calendar anomaly: %w", err)
+	}
+
+	return nil
+}
+
+// SetNextSyncAt records when the scheduler will next sync a calendar; nil
+// means it is not scheduled.
+func (r *CalendarRepository) SetNextSyncAt(ctx context.Context, id string, next *time.Time) error {
+	if next != nil {
+		utc := next.UTC()
+		next = &utc
+	}
+
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE calendar_subscriptions SET next_sync_at = ? WHERE id = ?
+	`, next, id)
+
+	if err != nil {
+		return fmt.Errorf("updating next sync time: %w", err)
+	}
+
+	return nil
+}
+
 // UpdateSyncStatus updates the sync status of a calendar.
 func (r *CalendarRepository) UpdateSyncStatus(ctx context.Context, id string, status string, syncError *string) error {
 	now := time.Now().UTC()
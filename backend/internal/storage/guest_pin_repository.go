@@ -4,10 +4,31 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/guest-lock-manager/backend/internal/storage/models"
 )
 
+// guestPINColumns is the column list read by scanGuestPIN.
+const guestPINColumns = `id, calendar_id, event_uid, event_summary, pin_code, generation_method,
+		       custom_pin, valid_from, valid_until, status, regeneration_eligible,
+		       reservation_code, guest_name, guest_phone_last4, reservation_url,
+		       missing_since, missing_count, linked_pin_id,
+		       event_sequence, event_last_modified, created_at, updated_at`
+
+// scanGuestPIN scans a row selected with guestPINColumns.
+func scanGuestPIN(row rowScanner, pin *models.GuestPIN) error {
+	return row.Scan(
+		&pin.ID, &pin.CalendarID, &pin.EventUID, &pin.EventSummary, &pin.PINCode,
+		&pin.GenerationMethod, &pin.CustomPIN, &pin.ValidFrom, &pin.ValidUntil,
+		&pin.Status, &pin.RegenerationEligible,
+		&pin.ReservationCode, &pin.GuestName, &pin.GuestPhoneLast4, &pin.ReservationURL,
+		&pin.MissingSince, &pin.MissingCount, &pin.LinkedPINID,
+		&pin.EventSequence, &pin.EventLastModified, &pin.CreatedAt, &pin.UpdatedAt,
+	)
+}
+
 // GuestPINRepository provides data access for guest PINs.
 type GuestPINRepository struct {
 	BaseRepository
@@ -29,12 +50,16 @@ func (r *GuestPINRepository) Create(ctx context.Context, pin *models.GuestPIN) e
 	_, err := r.DB().ExecContext(ctx, `
 		INSERT INTO guest_pins (
 			id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-			custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			custom_pin, valid_from, valid_until, status, regeneration_eligible,
+			reservation_code, guest_name, guest_phone_last4, reservation_url, linked_pin_id,
+			event_sequence, event_last_modified, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		pin.ID, pin.CalendarID, pin.EventUID, pin.EventSummary, pin.PINCode,
 		pin.GenerationMethod, pin.CustomPIN, pin.ValidFrom, pin.ValidUntil,
-		pin.Status, pin.RegenerationEligible, pin.CreatedAt, pin.UpdatedAt,
+		pin.Status, pin.RegenerationEligible,
+		pin.ReservationCode, pin.GuestName, pin.GuestPhoneLast4, pin.ReservationURL,
+		pin.LinkedPINID, pin.EventSequence, pin.EventLastModified, pin.CreatedAt, pin.UpdatedAt,
 	)
 
 	if err != nil {
@@ -48,15 +73,10 @@ func (r *GuestPINRepository) Create(ctx context.Context, pin *models.GuestPIN) e
 func (r *GuestPINRepository) GetByID(ctx context.Context, id string) (*models.GuestPIN, error) {
 	pin := &models.GuestPIN{}
 
-	err := r.DB().QueryRowContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+	err := scanGuestPIN(r.DB().QueryRowContext(ctx, `
+		SELECT `+guestPINColumns+`
 		FROM guest_pins WHERE id = ?
-	`, id).Scan(
-		&pin.ID, &pin.CalendarID, &pin.EventUID, &pin.EventSummary, &pin.PINCode,
-		&pin.GenerationMethod, &pin.CustomPIN, &pin.ValidFrom, &pin.ValidUntil,
-		&pin.Status, &pin.RegenerationEligible, &pin.CreatedAt, &pin.UpdatedAt,
-	)
+	`, id), pin)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -72,15 +92,10 @@ func (r *GuestPINRepository) GetByID(ctx context.Context, id string) (*models.Gu
 func (r *GuestPINRepository) GetByEventUID(ctx context.Context, calendarID, eventUID string) (*models.GuestPIN, error) {
 	pin := &models.GuestPIN{}
 
-	err := r.DB().QueryRowContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+	err := scanGuestPIN(r.DB().QueryRowContext(ctx, `
+		SELECT `+guestPINColumns+`
 		FROM guest_pins WHERE calendar_id = ? AND event_uid = ?
-	`, calendarID, eventUID).Scan(
-		&pin.ID, &pin.CalendarID, &pin.EventUID, &pin.EventSummary, &pin.PINCode,
-		&pin.GenerationMethod, &pin.CustomPIN, &pin.ValidFrom, &pin.ValidUntil,
-		&pin.Status, &pin.RegenerationEligible, &pin.CreatedAt, &pin.UpdatedAt,
-	)
+	`, calendarID, eventUID), pin)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -95,8 +110,7 @@ func (r *GuestPINRepository) GetByEventUID(ctx context.Context, calendarID, even
 // ListByCalendar retrieves all guest PINs for a calendar.
 func (r *GuestPINRepository) ListByCalendar(ctx context.Context, calendarID string) ([]models.GuestPIN, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+		SELECT `+guestPINColumns+`
 		FROM guest_pins
 		WHERE calendar_id = ?
 		ORDER BY valid_from DESC
@@ -112,8 +126,7 @@ func (r *GuestPINRepository) ListByCalendar(ctx context.Context, calendarID stri
 // ListByStatus retrieves all guest PINs with a specific status.
 func (r *GuestPINRepository) ListByStatus(ctx context.Context, status string) ([]models.GuestPIN, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+		SELECT `+guestPINColumns+`
 		FROM guest_pins
 		WHERE status = ?
 		ORDER BY valid_from DESC
@@ -129,8 +142,7 @@ func (r *GuestPINRepository) ListByStatus(ctx context.Context, status string) ([
 // ListActive retrieves all currently active guest PINs.
 func (r *GuestPINRepository) ListActive(ctx context.Context) ([]models.GuestPIN, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+		SELECT `+guestPINColumns+`
 		FROM guest_pins
 		WHERE status = 'active'
 		ORDER BY valid_from DESC
@@ -143,11 +155,30 @@ func (r *GuestPINRepository) ListActive(ctx context.Context) ([]models.GuestPIN,
 	return r.scanPINs(rows)
 }
 
+// ListProgrammed retrieves the live guest PINs programmed, or to be
+// programmed, on at least one lock. Linked PINs and PINs flagged as conflicts
+// are not programmed.
+func (r *GuestPINRepository) ListProgrammed(ctx context.Context) ([]models.GuestPIN, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT `+guestPINColumns+`
+		FROM guest_pins
+		WHERE status IN ('pending', 'active')
+		  AND linked_pin_id IS NULL
+		  AND id IN (SELECT guest_pin_id FROM guest_pin_locks WHERE sync_status != 'removed')
+		ORDER BY valid_from
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying programmed guest PINs: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanPINs(rows)
+}
+
 // ListPendingActivation retrieves PINs that should become active.
 func (r *GuestPINRepository) ListPendingActivation(ctx context.Context) ([]models.GuestPIN, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+		SELECT `+guestPINColumns+`
 		FROM guest_pins
 		WHERE status = 'pending' AND valid_from <= datetime('now')
 		ORDER BY valid_from
@@ -160,13 +191,13 @@ func (r *GuestPINRepository) ListPendingActivation(ctx context.Context) ([]model
 	return r.scanPINs(rows)
 }
 
-// ListExpired retrieves PINs that should be marked as expired.
+// ListExpired retrieves PINs that should be marked as expired. PINs left in
+// conflict status expire too, so their slots are released.
 func (r *GuestPINRepository) ListExpired(ctx context.Context) ([]models.GuestPIN, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+		SELECT `+guestPINColumns+`
 		FROM guest_pins
-		WHERE status = 'active' AND valid_until <= datetime('now')
+		WHERE status IN ('active', 'conflict') AND valid_until <= datetime('now')
 		ORDER BY valid_until
 	`)
 	if err != nil {
@@ -177,15 +208,32 @@ func (r *GuestPINRepository) ListExpired(ctx context.Context) ([]models.GuestPIN
 	return r.scanPINs(rows)
 }
 
+// NextCheckin returns the start of the earliest live PIN of a calendar that
+// begins after the given time, or nil if there is none.
+func (r *GuestPINRepository) NextCheckin(ctx context.Context, calendarID string, after time.Time) (*time.Time, error) {
+	var validFrom time.Time
+	err := r.DB().QueryRowContext(ctx, `
+		SELECT valid_from FROM guest_pins
+		WHERE calendar_id = ? AND status IN ('pending', 'active', 'conflict') AND valid_from > ?
+		ORDER BY valid_from
+		LIMIT 1
+	`, calendarID, after.UTC()).Scan(&validFrom)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying next check-in: %w", err)
+	}
+
+	return &validFrom, nil
+}
+
 func (r *GuestPINRepository) scanPINs(rows *sql.Rows) ([]models.GuestPIN, error) {
 	var pins []models.GuestPIN
 	for rows.Next() {
 		var pin models.GuestPIN
-		if err := rows.Scan(
-			&pin.ID, &pin.CalendarID, &pin.EventUID, &pin.EventSummary, &pin.PINCode,
-			&pin.GenerationMethod, &pin.CustomPIN, &pin.ValidFrom, &pin.ValidUntil,
-			&pin.Status, &pin.RegenerationEligible, &pin.CreatedAt, &pin.UpdatedAt,
-		); err != nil {
+		if err := scanGuestPIN(rows, &pin); err != nil {
 			return nil, fmt.Errorf("scanning guest PIN: %w", err)
 		}
 		pins = append(pins, pin)
@@ -200,12 +248,15 @@ func (r *GuestPINRepository) Update(ctx context.Context, pin *models.GuestPIN) e
 	result, err := r.DB().ExecContext(ctx, `
 		UPDATE guest_pins SET
 			event_summary = ?, pin_code = ?, generation_method = ?, custom_pin = ?,
-			valid_from = ?, valid_until = ?, status = ?, regeneration_eligible = ?, updated_at = ?
+			valid_from = ?, valid_until = ?, status = ?, regeneration_eligible = ?,
+			reservation_code = ?, guest_name = ?, guest_phone_last4 = ?, reservation_url = ?,
+			linked_pin_id = ?, event_sequence = ?, event_last_modified = ?, updated_at = ?
 		WHERE id = ?
 	`,
 		pin.EventSummary, pin.PINCode, pin.GenerationMethod, pin.CustomPIN,
 		pin.ValidFrom, pin.ValidUntil, pin.Status, pin.RegenerationEligible,
-		pin.UpdatedAt, pin.ID,
+		pin.ReservationCode, pin.GuestName, pin.GuestPhoneLast4, pin.ReservationURL,
+		pin.LinkedPINID, pin.EventSequence, pin.EventLastModified, pin.UpdatedAt, pin.ID,
 	)
 
 	if err != nil {
@@ -233,6 +284,107 @@ func (r *GuestPINRepository) UpdateStatus(ctx context.Context, id, status string
 	return nil
 }
 
+// UpdateMissing records how long a PIN's event has been absent from its feed.
+// A nil since with a zero count clears the record when the event returns.
+func (r *GuestPINRepository) UpdateMissing(ctx context.Context, id string, since *time.Time, count int) error {
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE guest_pins SET missing_since = ?, missing_count = ?, updated_at = ? WHERE id = ?
+	`, since, count, r.Now(), id)
+
+	if err != nil {
+		return fmt.Errorf("updating PIN missing state: %w", err)
+	}
+
+	return nil
+}
+
+// Link makes a PIN, and every PIN linked to it, mirrors of primaryID. The
+// PIN's lock assignments are released so its slots can be reused.
+func (r *GuestPINRepository) Link(ctx context.Context, id, primaryID string) error {
+	now := r.Now()
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE guest_pins SET linked_pin_id = ?, updated_at = ? WHERE id = ? OR linked_pin_id = ?
+	`, primaryID, now, id, id)
+	if err != nil {
+		return fmt.Errorf("linking guest PIN: %w", err)
+	}
+
+	_, err = r.DB().ExecContext(ctx, `
+		UPDATE guest_pin_locks SET sync_status = 'removed' WHERE guest_pin_id = ?
+	`, id)
+	if err != nil {
+		return fmt.Errorf("releasing linked PIN slots: %w", err)
+	}
+
+	return nil
+}
+
+// QueueLockSync marks a PIN's lock assignments for syncing again, so the lock
+// manager programs its new code, or clears the code once the PIN expired.
+func (r *GuestPINRepository) QueueLockSync(ctx context.Context, id string) error {
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE guest_pin_locks SET sync_status = 'pending', error_message = NULL
+		WHERE guest_pin_id = ? AND sync_status != 'removed'
+	`, id)
+	if err != nil {
+		return fmt.Errorf("queueing lock sync: %w", err)
+	}
+	return nil
+}
+
+// ListLinked retrieves the PINs linked to a primary PIN.
+func (r *GuestPINRepository) ListLinked(ctx context.Context, primaryID string) ([]models.GuestPIN, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT `+guestPINColumns+`
+		FROM guest_pins
+		WHERE linked_pin_id = ?
+		ORDER BY created_at
+	`, primaryID)
+	if err != nil {
+		return nil, fmt.Errorf("querying linked guest PINs: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanPINs(rows)
+}
+
+// FindStays finds the live, unlinked PINs of other calendars that share a lock
+// with lockIDs and whose windows overlap validFrom to validUntil. They are the
+// candidates for being the same stay listed on more than one calendar.
+func (r *GuestPINRepository) FindStays(ctx context.Context, calendarID string, lockIDs []string, validFrom, validUntil time.Time) ([]models.GuestPIN, error) {
+	if len(lockIDs) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{calendarID, validUntil.UTC(), validFrom.UTC()}
+	placeholders := make([]string, len(lockIDs))
+	for i, id := range lockIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT `+guestPINColumns+`
+		FROM guest_pins
+		WHERE calendar_id != ?
+		  AND linked_pin_id IS NULL
+		  AND status IN ('pending', 'active', 'conflict')
+		  AND valid_from < ?
+		  AND valid_until > ?
+		  AND calendar_id IN (
+			SELECT calendar_id FROM calendar_lock_mappings
+			WHERE lock_id IN (`+strings.Join(placeholders, ", ")+`)
+		  )
+		ORDER BY created_at
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying overlapping stays: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanPINs(rows)
+}
+
 // Delete removes a guest PIN by ID.
 func (r *GuestPINRepository) Delete(ctx context.Context, id string) error {
 	result, err := r.DB().ExecContext(ctx, "DELETE FROM guest_pins WHERE id = ?", id)
@@ -257,18 +409,23 @@ func (r *GuestPINRepository) DeleteByCalendar(ctx context.Context, calendarID st
 	return nil
 }
 
-// FindConflicts finds PINs with the same code that have overlapping validity windows.
-func (r *GuestPINRepository) FindConflicts(ctx context.Context, pinCode string, validFrom, validUntil string, excludeID string) ([]models.GuestPIN, error) {
+// ListOverlapping finds the PINs programmed, or to be programmed, on a lock
+// while their validity windows overlap the given one. Linked PINs are not
+// programmed and never conflict, nor do PINs already flagged as conflicts.
+func (r *GuestPINRepository) ListOverlapping(ctx context.Context, lockID string, validFrom, validUntil time.Time, excludeID string) ([]models.GuestPIN, error) {
 	rows, err := r.DB().QueryContext(ctx, `
-		SELECT id, calendar_id, event_uid, event_summary, pin_code, generation_method,
-		       custom_pin, valid_from, valid_until, status, regeneration_eligible, created_at, updated_at
+		SELECT `+guestPINColumns+`
 		FROM guest_pins
-		WHERE pin_code = ?
+		WHERE id IN (
+			SELECT guest_pin_id FROM guest_pin_locks
+			WHERE lock_id = ? AND sync_status != 'removed'
+		  )
 		  AND id != ?
 		  AND valid_from < ?
 		  AND valid_until > ?
 		  AND status NOT IN ('expired', 'conflict')
-	`, pinCode, excludeID, validUntil, validFrom)
+		  AND linked_pin_id IS NULL
+	`, lockID, excludeID, validUntil.UTC(), validFrom.UTC())
 	if err != nil {
 		return nil, fmt.Errorf("querying PIN conflicts: %w", err)
 	}
@@ -331,5 +488,70 @@ func (r *GuestPINRepository) GetLockAssignments(ctx context.Context, guestPINID
 	return assignments, rows.Err()
 }
 
+// UsedCodes returns the codes on a lock that a randomly drawn PIN must not
+// take: those of enabled static PINs, of live guest PINs assigned to the lock,
+// and of guest PINs assigned to it whose validity ended after since. The guest
+// PIN excludeID is left out.
+func (r *GuestPINRepository) UsedCodes(ctx context.Context, lockID string, since time.Time, excludeID string) (map[string]bool, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT sp.pin_code
+		FROM static_pins sp
+		JOIN static_pin_locks spl ON spl.static_pin_id = sp.id
+		WHERE spl.lock_id = ? AND sp.enabled = 1
+		UNION
+		SELECT gp.pin_code
+		FROM guest_pin_locks gpl
+		JOIN guest_pins gp ON gp.id = gpl.guest_pin_id
+		WHERE gpl.lock_id = ?
+		  AND gp.id != ?
+		  AND (gp.status IN ('pending', 'active', 'conflict') OR gp.valid_until > ?)
+	`, lockID, lockID, excludeID, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("querying used codes: %w", err)
+	}
+	defer rows.Close()
+
+	used := make(map[string]bool)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("scanning code: %w", err)
+		}
+		used[code] = true
+	}
+
+	return used, rows.Err()
+}
+
+// OccupiedSlots returns the slot numbers on a lock that are unavailable to a
+// guest PIN valid from validFrom to validUntil: every static PIN slot, plus the
+// slots of live guest PINs whose windows overlap.
+func (r *GuestPINRepository) OccupiedSlots(ctx context.Context, lockID string, validFrom, validUntil time.Time) (map[int]bool, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT slot_number FROM static_pin_locks WHERE lock_id = ?
+		UNION
+		SELECT gpl.slot_number
+		FROM guest_pin_locks gpl
+		JOIN guest_pins gp ON gp.id = gpl.guest_pin_id
+		WHERE gpl.lock_id = ?
+		  AND gpl.sync_status != 'removed'
+		  AND gp.status IN ('pending', 'active', 'conflict')
+		  AND gp.valid_from < ?
+		  AND gp.valid_until > ?
+	`, lockID, lockID, validUntil.UTC(), validFrom.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("querying occupied slots: %w", err)
+	}
+	defer rows.Close()
 
+	occupied := make(map[int]bool)
+	for rows.Next() {
+		var slot int
+		if err := rows.Scan(&slot); err != nil {
+			return nil, fmt.Errorf("scanning slot: %w", err)
+		}
+		occupied[slot] = true
+	}
 
+	return occupied, rows.Err()
+}
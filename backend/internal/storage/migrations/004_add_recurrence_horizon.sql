@@ -0,0 +1,3 @@
+-- How many days ahead recurring calendar events are expanded during sync
+INSERT OR IGNORE INTO settings (key, value) VALUES
+    ('recurrence_horizon_days', '180');
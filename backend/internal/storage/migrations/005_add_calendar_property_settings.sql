@@ -0,0 +1,5 @@
+-- Per-property timezone and check-in/check-out overrides.
+-- NULL falls back to the global settings.
+ALTER TABLE calendar_subscriptions ADD COLUMN timezone TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN checkin_time TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN checkout_time TEXT;
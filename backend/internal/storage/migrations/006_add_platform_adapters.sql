@@ -0,0 +1,10 @@
+-- Booking platform used to tell reservations apart from blocked dates
+ALTER TABLE calendar_subscriptions
+ADD COLUMN platform TEXT NOT NULL DEFAULT 'generic'
+    CHECK (platform IN ('generic', 'airbnb', 'vrbo', 'booking_com'));
+
+-- Reservation details extracted from the calendar event
+ALTER TABLE guest_pins ADD COLUMN reservation_code TEXT;
+ALTER TABLE guest_pins ADD COLUMN guest_name TEXT;
+ALTER TABLE guest_pins ADD COLUMN guest_phone_last4 TEXT;
+ALTER TABLE guest_pins ADD COLUMN reservation_url TEXT;
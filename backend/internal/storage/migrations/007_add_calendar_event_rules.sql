@@ -0,0 +1,2 @@
+-- Include/exclude rules and stay-length limits applied before PIN generation (JSON)
+ALTER TABLE calendar_subscriptions ADD COLUMN event_rules TEXT;
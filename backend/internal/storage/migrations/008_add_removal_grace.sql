@@ -0,0 +1,9 @@
+-- Track guest PINs whose events have gone missing from the feed, so a single
+-- bad fetch does not expire them.
+ALTER TABLE guest_pins ADD COLUMN missing_since DATETIME;
+ALTER TABLE guest_pins ADD COLUMN missing_count INTEGER NOT NULL DEFAULT 0;
+
+-- Per-calendar grace before a missing event's PIN is expired: the event must be
+-- absent for this many consecutive syncs or this many minutes (0 disables a check).
+ALTER TABLE calendar_subscriptions ADD COLUMN removal_grace_syncs INTEGER NOT NULL DEFAULT 3;
+ALTER TABLE calendar_subscriptions ADD COLUMN removal_grace_min INTEGER NOT NULL DEFAULT 60;
@@ -0,0 +1,7 @@
+-- Feed anomaly guard: what the last accepted sync saw, and the anomaly (if any)
+-- holding back PIN removals until it is confirmed.
+ALTER TABLE calendar_subscriptions ADD COLUMN last_good_event_count INTEGER;
+ALTER TABLE calendar_subscriptions ADD COLUMN last_good_reservations TEXT; -- JSON: event key -> end time
+ALTER TABLE calendar_subscriptions ADD COLUMN anomaly_threshold_pct INTEGER NOT NULL DEFAULT 50;
+ALTER TABLE calendar_subscriptions ADD COLUMN anomaly_reason TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN anomaly_detected_at DATETIME;
@@ -0,0 +1,28 @@
+-- History of calendar sync runs, with a gzip snapshot of the raw feed for the
+-- most recent runs (older snapshots are cleared to NULL).
+CREATE TABLE calendar_sync_runs (
+    id TEXT PRIMARY KEY,
+    calendar_id TEXT NOT NULL,
+    started_at DATETIME NOT NULL,
+    finished_at DATETIME NOT NULL,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    http_status INTEGER,
+    events_found INTEGER NOT NULL DEFAULT 0,
+    events_skipped INTEGER NOT NULL DEFAULT 0,
+    pins_created INTEGER NOT NULL DEFAULT 0,
+    pins_updated INTEGER NOT NULL DEFAULT 0,
+    pins_removed INTEGER NOT NULL DEFAULT 0,
+    pending_removal INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    snapshot BLOB,
+    snapshot_size INTEGER,
+    FOREIGN KEY (calendar_id) REFERENCES calendar_subscriptions(id) ON DELETE CASCADE,
+    CHECK (status IN ('success', 'error', 'anomaly'))
+);
+
+CREATE INDEX idx_calendar_sync_runs_calendar ON calendar_sync_runs(calendar_id, started_at);
+
+-- How many raw feed snapshots to keep per calendar
+INSERT OR IGNORE INTO settings (key, value) VALUES
+    ('sync_snapshot_retention', '10');
@@ -0,0 +1,35 @@
+-- Conditional fetching: validators and content hash of the last fully processed
+-- feed. Cleared whenever the calendar's settings change so the next sync
+-- reprocesses the feed.
+ALTER TABLE calendar_subscriptions ADD COLUMN feed_etag TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN feed_last_modified TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN feed_content_hash TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN feed_processed_at DATETIME;
+
+-- Allow 'unchanged' sync runs (rebuild to change the CHECK constraint)
+CREATE TABLE calendar_sync_runs_new (
+    id TEXT PRIMARY KEY,
+    calendar_id TEXT NOT NULL,
+    started_at DATETIME NOT NULL,
+    finished_at DATETIME NOT NULL,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    http_status INTEGER,
+    events_found INTEGER NOT NULL DEFAULT 0,
+    events_skipped INTEGER NOT NULL DEFAULT 0,
+    pins_created INTEGER NOT NULL DEFAULT 0,
+    pins_updated INTEGER NOT NULL DEFAULT 0,
+    pins_removed INTEGER NOT NULL DEFAULT 0,
+    pending_removal INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    snapshot BLOB,
+    snapshot_size INTEGER,
+    FOREIGN KEY (calendar_id) REFERENCES calendar_subscriptions(id) ON DELETE CASCADE,
+    CHECK (status IN ('success', 'error', 'anomaly', 'unchanged'))
+);
+
+INSERT INTO calendar_sync_runs_new SELECT * FROM calendar_sync_runs;
+DROP TABLE calendar_sync_runs;
+ALTER TABLE calendar_sync_runs_new RENAME TO calendar_sync_runs;
+
+CREATE INDEX idx_calendar_sync_runs_calendar ON calendar_sync_runs(calendar_id, started_at);
@@ -0,0 +1,10 @@
+-- Per-calendar fetch options. auth_credentials holds the FeedAuth JSON
+-- encrypted with the key in secret.key; it is never returned by the API.
+ALTER TABLE calendar_subscriptions ADD COLUMN auth_type TEXT NOT NULL DEFAULT 'none'
+    CHECK (auth_type IN ('none', 'basic', 'header', 'query'));
+ALTER TABLE calendar_subscriptions ADD COLUMN auth_credentials TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN fetch_headers TEXT; -- JSON object of header name -> value
+ALTER TABLE calendar_subscriptions ADD COLUMN max_feed_bytes INTEGER NOT NULL DEFAULT 0; -- 0 uses the default limit
+ALTER TABLE calendar_subscriptions ADD COLUMN redirect_policy TEXT NOT NULL DEFAULT 'follow'
+    CHECK (redirect_policy IN ('follow', 'same_host', 'none'));
+ALTER TABLE calendar_subscriptions ADD COLUMN ca_bundle TEXT; -- PEM certificates
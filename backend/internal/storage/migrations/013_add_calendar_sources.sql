@@ -0,0 +1,13 @@
+-- Calendar source types. 'ical' reads the feed at url (http, https or
+-- file://); 'upload' syncs the last ICS body uploaded through the API.
+-- Validated by the API so later sources need no table rebuild.
+ALTER TABLE calendar_subscriptions ADD COLUMN source_type TEXT NOT NULL DEFAULT 'ical';
+
+-- Uploaded ICS bodies, one per 'upload' calendar
+CREATE TABLE calendar_uploads (
+    calendar_id TEXT PRIMARY KEY,
+    body BLOB NOT NULL,
+    size INTEGER NOT NULL,
+    uploaded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (calendar_id) REFERENCES calendar_subscriptions(id) ON DELETE CASCADE
+);
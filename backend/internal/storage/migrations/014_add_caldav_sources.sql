@@ -0,0 +1,12 @@
+-- CalDAV calendars: the collection's sync-token and the calendar objects it
+-- returned, so later syncs only download what changed.
+ALTER TABLE calendar_subscriptions ADD COLUMN caldav_sync_token TEXT;
+
+CREATE TABLE caldav_objects (
+    calendar_id TEXT NOT NULL,
+    href TEXT NOT NULL,
+    etag TEXT,
+    data BLOB NOT NULL, -- iCalendar object as returned by the server
+    PRIMARY KEY (calendar_id, href),
+    FOREIGN KEY (calendar_id) REFERENCES calendar_subscriptions(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Home Assistant calendar entities as calendar sources ('ha_calendar', with
+-- the entity ID in url). Their snapshots are the entity's events as JSON, so
+-- record each snapshot's format.
+ALTER TABLE calendar_sync_runs ADD COLUMN snapshot_format TEXT NOT NULL DEFAULT 'ical';
@@ -0,0 +1,3 @@
+-- Inbound webhooks that trigger an immediate calendar sync. The HMAC secret
+-- is encrypted with the database's secret key; NULL disables the webhook.
+ALTER TABLE calendar_subscriptions ADD COLUMN webhook_secret TEXT;
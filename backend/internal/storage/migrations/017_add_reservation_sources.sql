@@ -0,0 +1,4 @@
+-- Channel manager reservation APIs as calendar sources ('reservation_api',
+-- with the API endpoint in url). reservation_mapping is the JSON field
+-- mapping used to read reservations from the API's responses.
+ALTER TABLE calendar_subscriptions ADD COLUMN reservation_mapping TEXT;
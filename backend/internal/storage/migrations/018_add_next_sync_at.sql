@@ -0,0 +1,4 @@
+-- When the scheduler will next sync each calendar. The time adapts to
+-- upcoming check-ins, recent changes and failed syncs; NULL when the calendar
+-- is not scheduled.
+ALTER TABLE calendar_subscriptions ADD COLUMN next_sync_at DATETIME;
@@ -0,0 +1,12 @@
+-- Cross-calendar de-duplication. When two calendars mapped to the same lock
+-- list the same stay (a booking mirrored between platforms by a channel
+-- manager), only one guest PIN is programmed. Priority picks which calendar's
+-- reservation is the real one (higher wins); the others are linked to it.
+ALTER TABLE calendar_subscriptions ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+
+-- The guest PIN this one mirrors. A linked PIN holds no lock slots. Not a
+-- foreign key, so a PIN whose primary is deleted still reads as linked until
+-- the next sync promotes it.
+ALTER TABLE guest_pins ADD COLUMN linked_pin_id TEXT;
+
+CREATE INDEX idx_guest_pin_linked ON guest_pins(linked_pin_id);
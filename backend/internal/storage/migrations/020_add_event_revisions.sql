@@ -0,0 +1,9 @@
+-- Revision of the event each guest PIN was last updated from (iCalendar
+-- SEQUENCE and LAST-MODIFIED), so stale copies of an event do not roll a PIN
+-- back.
+ALTER TABLE guest_pins ADD COLUMN event_sequence INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE guest_pins ADD COLUMN event_last_modified DATETIME;
+
+-- PINs expired because their event was cancelled (STATUS:CANCELLED or
+-- METHOD:CANCEL), counted apart from PINs removed for missing events.
+ALTER TABLE calendar_sync_runs ADD COLUMN cancellations INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- What a sync does with a generated PIN whose code is already in use on one
+-- of its locks: 'auto_resolve' picks another code, 'flag' sets the PIN to
+-- conflict status so it is not programmed until resolved
+INSERT OR IGNORE INTO settings (key, value) VALUES
+    ('pin_conflict_policy', 'auto_resolve');
@@ -0,0 +1,62 @@
+-- Per-calendar PIN generation: the generation methods tried, in order (a JSON
+-- array of method names), and the code length. NULL uses the default method
+-- order and the global PIN length settings.
+ALTER TABLE calendar_subscriptions ADD COLUMN pin_methods TEXT;
+ALTER TABLE calendar_subscriptions ADD COLUMN pin_length INTEGER;
+
+-- generation_method records whichever registered strategy produced the PIN,
+-- so it is no longer limited to the built-in methods (rebuild to drop the
+-- CHECK constraint). Dropping guest_pins cascades to guest_pin_locks, so the
+-- lock assignments are kept aside and restored.
+CREATE TEMP TABLE guest_pin_locks_backup AS SELECT * FROM guest_pin_locks;
+
+CREATE TABLE guest_pins_new (
+    id TEXT PRIMARY KEY,
+    calendar_id TEXT NOT NULL,
+    event_uid TEXT NOT NULL,
+    event_summary TEXT,
+    pin_code TEXT NOT NULL,
+    generation_method TEXT NOT NULL,
+    custom_pin TEXT,
+    valid_from DATETIME NOT NULL,
+    valid_until DATETIME NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    regeneration_eligible INTEGER NOT NULL DEFAULT 1,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reservation_code TEXT,
+    guest_name TEXT,
+    guest_phone_last4 TEXT,
+    reservation_url TEXT,
+    missing_since DATETIME,
+    missing_count INTEGER NOT NULL DEFAULT 0,
+    linked_pin_id TEXT,
+    event_sequence INTEGER NOT NULL DEFAULT 0,
+    event_last_modified DATETIME,
+    FOREIGN KEY (calendar_id) REFERENCES calendar_subscriptions(id) ON DELETE CASCADE,
+    UNIQUE (calendar_id, event_uid),
+    CHECK (status IN ('pending', 'active', 'expired', 'conflict')),
+    CHECK (valid_from < valid_until)
+);
+
+INSERT INTO guest_pins_new (
+    id, calendar_id, event_uid, event_summary, pin_code, generation_method, custom_pin,
+    valid_from, valid_until, status, regeneration_eligible, created_at, updated_at,
+    reservation_code, guest_name, guest_phone_last4, reservation_url,
+    missing_since, missing_count, linked_pin_id, event_sequence, event_last_modified
+)
+SELECT
+    id, calendar_id, event_uid, event_summary, pin_code, generation_method, custom_pin,
+    valid_from, valid_until, status, regeneration_eligible, created_at, updated_at,
+    reservation_code, guest_name, guest_phone_last4, reservation_url,
+    missing_since, missing_count, linked_pin_id, event_sequence, event_last_modified
+FROM guest_pins;
+DROP TABLE guest_pins;
+ALTER TABLE guest_pins_new RENAME TO guest_pins;
+
+INSERT INTO guest_pin_locks SELECT * FROM guest_pin_locks_backup;
+DROP TABLE guest_pin_locks_backup;
+
+CREATE INDEX idx_guest_pin_validity ON guest_pins(status, valid_from, valid_until);
+CREATE INDEX idx_guest_pin_calendar ON guest_pins(calendar_id);
+CREATE INDEX idx_guest_pin_linked ON guest_pins(linked_pin_id);
@@ -0,0 +1,4 @@
+-- How many days a code stays unavailable to randomly drawn PINs on a lock
+-- after a guest PIN using it there ends
+INSERT OR IGNORE INTO settings (key, value) VALUES
+    ('pin_code_reuse_days', '30');
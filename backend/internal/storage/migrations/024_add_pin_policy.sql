@@ -0,0 +1,12 @@
+-- PIN policy applied to every guest and static PIN: reject repeated digits and
+-- sequences, common PINs, year-like codes, codes that begin with another code
+-- on the same lock, and codes differing from another on the same lock in
+-- fewer than pin_min_distance digits. pin_blocklist holds extra rejected
+-- codes, comma separated.
+INSERT OR IGNORE INTO settings (key, value) VALUES
+    ('pin_reject_sequences', 'true'),
+    ('pin_reject_common', 'true'),
+    ('pin_reject_years', 'true'),
+    ('pin_reject_prefixes', 'true'),
+    ('pin_min_distance', '1'),
+    ('pin_blocklist', '');
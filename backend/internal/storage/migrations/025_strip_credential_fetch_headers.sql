@@ -0,0 +1,20 @@
+-- Custom fetch headers are returned by the API, so credentials must be set
+-- through feed auth, which is stored encrypted. Headers saved before that was
+-- enforced are stripped if they look like credentials (the same names the
+-- calendar handlers reject); such feeds need their auth set again.
+UPDATE calendar_subscriptions
+SET fetch_headers = (
+    SELECT CASE WHEN COUNT(*) = 0 THEN NULL ELSE json_group_object(key, value) END
+    FROM json_each(calendar_subscriptions.fetch_headers)
+    WHERE lower(key) NOT IN (
+            'authorization', 'proxy-authorization', 'cookie', 'x-api-key',
+            'api-key', 'x-auth-token', 'x-access-token'
+        )
+      AND instr(lower(key), 'token') = 0
+      AND instr(lower(key), 'secret') = 0
+      AND instr(lower(key), 'password') = 0
+      AND instr(lower(key), 'apikey') = 0
+      AND instr(lower(key), 'api-key') = 0
+      AND instr(lower(key), 'api_key') = 0
+)
+WHERE fetch_headers IS NOT NULL;
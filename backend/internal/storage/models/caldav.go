@@ -0,0 +1,8 @@
+package models
+
+// CalDAVObject is one calendar object resource of a CalDAV collection.
+type CalDAVObject struct {
+	Href string
+	ETag string
+	Data []byte // iCalendar data
+}
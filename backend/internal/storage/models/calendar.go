@@ -2,21 +2,109 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 // CalendarSubscription represents a rental calendar subscription (iCal feed).
 type CalendarSubscription struct {
-	ID              string     `json:"id"`
-	Name            string     `json:"name"`
-	URL             string     `json:"url"`
-	SyncIntervalMin int        `json:"sync_interval_min"`
-	LastSyncAt      *time.Time `json:"last_sync_at,omitempty"`
-	SyncStatus      string     `json:"sync_status"`
-	SyncError       *string    `json:"sync_error,omitempty"`
-	Enabled         bool       `json:"enabled"`
-	CreatedAt       time.Time  `json:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	URL             string      `json:"url"`
+	SourceType      string      `json:"source_type"`
+	SyncIntervalMin int         `json:"sync_interval_min"`
+	LastSyncAt      *time.Time  `json:"last_sync_at,omitempty"`
+	SyncStatus      string      `json:"sync_status"`
+	SyncError       *string     `json:"sync_error,omitempty"`
+	Enabled         bool        `json:"enabled"`
+	Platform        string      `json:"platform"`
+	EventRules      *EventRules `json:"event_rules,omitempty"`
+	Timezone        *string     `json:"timezone,omitempty"`      // IANA name; nil uses the global setting
+	CheckinTime     *string     `json:"checkin_time,omitempty"`  // Format: "15:04"; nil uses the global setting
+	CheckoutTime    *string     `json:"checkout_time,omitempty"` // Format: "15:04"; nil uses the global setting
+	// Grace before expiring the PIN of an event missing from the feed; 0 disables a check.
+	RemovalGraceSyncs int `json:"removal_grace_syncs"`
+	RemovalGraceMin   int `json:"removal_grace_min"`
+	// Percentage drop from the last good sync that holds removals; 0 disables the guard.
+	AnomalyThresholdPct int        `json:"anomaly_threshold_pct"`
+	AnomalyReason       *string    `json:"anomaly_reason,omitempty"` // Set while removals are held
+	AnomalyDetectedAt   *time.Time `json:"anomaly_detected_at,omitempty"`
+	// Validators and hash of the last fully processed feed, for conditional fetches.
+	FeedETag         *string    `json:"feed_etag,omitempty"`
+	FeedLastModified *string    `json:"feed_last_modified,omitempty"`
+	FeedContentHash  *string    `json:"feed_content_hash,omitempty"`
+	FeedProcessedAt  *time.Time `json:"feed_processed_at,omitempty"`
+	// How the feed is fetched; credentials are stored encrypted and never serialized.
+	Fetch FetchOptions `json:"fetch"`
+	// Field mapping of a reservation_api calendar's JSON responses.
+	ReservationMapping *ReservationMapping `json:"reservation_mapping,omitempty"`
+	// Priority decides which calendar's reservation is programmed when calendars
+	// sharing a lock list the same stay; the highest wins.
+	Priority int `json:"priority"`
+	// PIN generation methods tried in order, and the code length. Nil uses the
+	// default method order and the global PIN length settings.
+	PINMethods []string  `json:"pin_methods,omitempty"`
+	PINLength  *int      `json:"pin_length,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Default removal grace and anomaly threshold for new calendars.
+const (
+	DefaultRemovalGraceSyncs   = 3
+	DefaultRemovalGraceMin     = 60
+	DefaultAnomalyThresholdPct = 50
+)
+
+// FetchOptions controls how a calendar's feed is downloaded.
+type FetchOptions struct {
+	Auth           *FeedAuth         `json:"-"`
+	Headers        map[string]string `json:"headers,omitempty"`   // Extra request headers
+	MaxBytes       int64             `json:"max_bytes,omitempty"` // 0 uses DefaultMaxFeedBytes
+	RedirectPolicy string            `json:"redirect_policy"`
+	CABundle       *string           `json:"ca_bundle,omitempty"` // PEM certificates trusted in addition to the system roots
+}
+
+// FeedAuth is a credential sent with feed requests.
+type FeedAuth struct {
+	Type     string `json:"type"`               // basic, header or query
+	Username string `json:"username,omitempty"` // basic only; CalDAV also answers Digest challenges with it
+	Name     string `json:"name,omitempty"`     // Header or query parameter name
+	Secret   string `json:"secret,omitempty"`   // Password, header value or token
+}
+
+// Feed auth type constants
+const (
+	FeedAuthNone   = "none"
+	FeedAuthBasic  = "basic"
+	FeedAuthHeader = "header"
+	FeedAuthQuery  = "query"
+)
+
+// Redirect policy constants
+const (
+	RedirectFollow   = "follow"    // Follow up to 10 redirects
+	RedirectSameHost = "same_host" // Follow redirects that stay on the feed's host and scheme
+	RedirectNone     = "none"      // Treat any redirect as an error
+)
+
+// DefaultMaxFeedBytes is the largest feed downloaded when no limit is set.
+const DefaultMaxFeedBytes = 10 << 20
+
+// IsValidRedirectPolicy reports whether p is a known redirect policy.
+func IsValidRedirectPolicy(p string) bool {
+	switch p {
+	case RedirectFollow, RedirectSameHost, RedirectNone:
+		return true
+	}
+	return false
+}
+
+// SyncBaseline is what the last accepted sync of a calendar saw. Later syncs
+// are compared against it to catch feeds that suddenly lose their events.
+type SyncBaseline struct {
+	EventCount   int
+	Reservations map[string]time.Time // Event key -> end of the PIN window
 }
 
 // SyncStatus constants
@@ -27,6 +115,123 @@ const (
 	SyncStatusError   = "error"
 )
 
+// Calendar source type constants select where a calendar's feed comes from.
+const (
+	SourceICal           = "ical"            // Feed at URL: http, https or a file:// path
+	SourceUpload         = "upload"          // Last ICS body uploaded through the API; URL is a placeholder
+	SourceCalDAV         = "caldav"          // CalDAV calendar collection at URL
+	SourceHACalendar     = "ha_calendar"     // Home Assistant calendar entity; URL is the entity ID
+	SourceReservationAPI = "reservation_api" // Channel manager JSON API at URL, read with a ReservationMapping
+)
+
+// IsValidSourceType reports whether t is a known calendar source type.
+func IsValidSourceType(t string) bool {
+	switch t {
+	case SourceICal, SourceUpload, SourceCalDAV, SourceHACalendar, SourceReservationAPI:
+		return true
+	}
+	return false
+}
+
+// Booking platform constants select the SourceAdapter used to read a feed.
+const (
+	PlatformGeneric    = "generic"     // Every event is a reservation
+	PlatformAirbnb     = "airbnb"      // Skips "Airbnb (Not available)" blocks
+	PlatformVRBO       = "vrbo"        // Skips "Blocked" entries
+	PlatformBookingCom = "booking_com" // Booking.com extranet export
+)
+
+// IsValidPlatform reports whether p is a known booking platform.
+func IsValidPlatform(p string) bool {
+	switch p {
+	case PlatformGeneric, PlatformAirbnb, PlatformVRBO, PlatformBookingCom:
+		return true
+	}
+	return false
+}
+
+// EventRules decides which events of a feed are stays. They are applied after
+// the platform adapter and before PIN generation.
+type EventRules struct {
+	Include       []EventRule `json:"include,omitempty"`         // If set, an event must match at least one
+	Exclude       []EventRule `json:"exclude,omitempty"`         // An event matching any of these is skipped
+	MinStayNights int         `json:"min_stay_nights,omitempty"` // 0 = no minimum
+	MaxStayNights int         `json:"max_stay_nights,omitempty"` // 0 = no maximum
+}
+
+// EventRule matches one event field against a pattern.
+type EventRule struct {
+	Name    string `json:"name,omitempty"` // Label used in skip counts; derived from the rule if empty
+	Field   string `json:"field"`          // summary, description or location
+	Match   string `json:"match"`          // contains (case-insensitive) or regex
+	Pattern string `json:"pattern"`
+}
+
+// Event rule field and match constants
+const (
+	RuleFieldSummary     = "summary"
+	RuleFieldDescription = "description"
+	RuleFieldLocation    = "location"
+
+	RuleMatchContains = "contains"
+	RuleMatchRegex    = "regex"
+)
+
+// IsEmpty reports whether the rule set filters nothing.
+func (r *EventRules) IsEmpty() bool {
+	return r == nil || (len(r.Include) == 0 && len(r.Exclude) == 0 && r.MinStayNights == 0 && r.MaxStayNights == 0)
+}
+
+// ParseEventRules decodes the JSON stored in calendar_subscriptions.event_rules.
+func ParseEventRules(raw *string) (*EventRules, error) {
+	if raw == nil || *raw == "" {
+		return nil, nil
+	}
+	var rules EventRules
+	if err := json.Unmarshal([]byte(*raw), &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// EncodeEventRules encodes rules for storage; empty rule sets are stored as NULL.
+func EncodeEventRules(rules *EventRules) (*string, error) {
+	if rules.IsEmpty() {
+		return nil, nil
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
+// ParsePINMethods decodes a stored PIN method list; NULL decodes to nil.
+func ParsePINMethods(raw *string) ([]string, error) {
+	if raw == nil || *raw == "" {
+		return nil, nil
+	}
+	var methods []string
+	if err := json.Unmarshal([]byte(*raw), &methods); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+// EncodePINMethods encodes a PIN method list for storage; empty lists are stored as NULL.
+func EncodePINMethods(methods []string) (*string, error) {
+	if len(methods) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(methods)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
 // CalendarLockMapping represents the M:N relationship between calendars and locks.
 type CalendarLockMapping struct {
 	CalendarID string `json:"calendar_id"`
@@ -40,20 +245,116 @@ type CalendarEvent struct {
 	Description string    `json:"description"`
 	Start       time.Time `json:"start"`
 	End         time.Time `json:"end"`
+	AllDay      bool      `json:"all_day"`
 	Location    string    `json:"location,omitempty"`
+	// RecurrenceID identifies one instance of a recurring event (UTC start, or
+	// date for all-day series). Empty for non-recurring events.
+	RecurrenceID string `json:"recurrence_id,omitempty"`
+	// Status is the iCalendar STATUS (see EventStatus*); empty when not given.
+	Status string `json:"status,omitempty"`
+	// Sequence and LastModified identify the event's revision; a copy with a
+	// lower revision than the one a PIN was built from is stale.
+	Sequence     int        `json:"sequence,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+
+	// Reservation details extracted by the calendar's platform adapter.
+	ReservationCode string `json:"reservation_code,omitempty"`
+	GuestName       string `json:"guest_name,omitempty"`
+	PhoneLast4      string `json:"phone_last4,omitempty"`
+	ReservationURL  string `json:"reservation_url,omitempty"`
+}
+
+// Event status values (iCalendar STATUS).
+const (
+	EventStatusTentative = "TENTATIVE"
+	EventStatusConfirmed = "CONFIRMED"
+	EventStatusCancelled = "CANCELLED"
+)
+
+// IsCancelled reports whether the event was cancelled.
+func (e CalendarEvent) IsCancelled() bool {
+	return e.Status == EventStatusCancelled
+}
+
+// Key returns the identifier used to match an event across syncs.
+// Recurring instances share a UID, so their recurrence ID is appended.
+func (e CalendarEvent) Key() string {
+	if e.RecurrenceID == "" {
+		return e.UID
+	}
+	return e.UID + "#" + e.RecurrenceID
 }
 
 // CalendarSyncResult contains the results of a calendar sync operation.
 type CalendarSyncResult struct {
-	CalendarID   string    `json:"calendar_id"`
-	CalendarName string    `json:"calendar_name"`
-	EventsFound  int       `json:"events_found"`
-	PINsCreated  int       `json:"pins_created"`
-	PINsUpdated  int       `json:"pins_updated"`
-	PINsRemoved  int       `json:"pins_removed"`
-	Error        error     `json:"-"`
-	SyncedAt     time.Time `json:"synced_at"`
+	CalendarID   string `json:"calendar_id"`
+	CalendarName string `json:"calendar_name"`
+	EventsFound  int    `json:"events_found"`
+	PINsCreated  int    `json:"pins_created"`
+	PINsUpdated  int    `json:"pins_updated"`
+	PINsRemoved  int    `json:"pins_removed"`
+	// PINsLinked counts PINs linked to the same stay on another calendar instead of being programmed.
+	PINsLinked int `json:"pins_linked,omitempty"`
+	// Cancellations counts PINs expired because their event was cancelled; they
+	// are not included in PINsRemoved.
+	Cancellations int `json:"cancellations"`
+	// EventsSkipped counts events that did not produce a PIN, by reason in SkippedByReason.
+	EventsSkipped   int            `json:"events_skipped"`
+	SkippedByReason map[string]int `json:"skipped_by_reason,omitempty"`
+	// PendingRemoval lists PINs whose events are missing but still within their grace period.
+	PendingRemoval []PendingRemoval `json:"pending_removal,omitempty"`
+	// ConflictsResolved counts PINs given another code because theirs was in use on a lock.
+	ConflictsResolved int `json:"conflicts_resolved,omitempty"`
+	// Conflicts lists the conflicts of PINs set to conflict status, which are not programmed.
+	Conflicts []PINConflict `json:"conflicts,omitempty"`
+	// Unchanged is set when the feed matched the last processed one and was skipped.
+	Unchanged bool      `json:"unchanged,omitempty"`
+	Error     error     `json:"-"`
+	SyncedAt  time.Time `json:"synced_at"`
+	// NextSyncAt is when the scheduler will sync the calendar again, if known.
+	NextSyncAt *time.Time `json:"next_sync_at,omitempty"`
 }
 
+// PendingRemoval is a guest PIN kept alive while its event is missing from the feed.
+type PendingRemoval struct {
+	GuestPINID   string    `json:"guest_pin_id"`
+	EventUID     string    `json:"event_uid"`
+	EventSummary string    `json:"event_summary,omitempty"`
+	Status       string    `json:"status"`
+	MissingSince time.Time `json:"missing_since"`
+	MissingCount int       `json:"missing_count"`
+}
 
+// PINConflict is a guest PIN whose code another PIN uses on the same lock
+// while both are valid.
+type PINConflict struct {
+	GuestPINID         string    `json:"guest_pin_id"`
+	EventSummary       string    `json:"event_summary,omitempty"`
+	PINCode            string    `json:"pin_code"`
+	LockID             string    `json:"lock_id"`
+	ConflictingType    string    `json:"conflicting_type"` // "guest" or "static"
+	Reason             string    `json:"reason"`           // "same_code", "prefix" or "similar"
+	ConflictingPINID   string    `json:"conflicting_pin_id,omitempty"`
+	ConflictingSummary string    `json:"conflicting_summary,omitempty"` // Event summary, or name of a static PIN
+	OverlapStart       time.Time `json:"overlap_start"`
+	OverlapEnd         time.Time `json:"overlap_end"`
+}
 
+// TrackSkipReason makes reason appear in SkippedByReason even when it skips nothing.
+func (r *CalendarSyncResult) TrackSkipReason(reason string) {
+	if r.SkippedByReason == nil {
+		r.SkippedByReason = make(map[string]int)
+	}
+	if _, ok := r.SkippedByReason[reason]; !ok {
+		r.SkippedByReason[reason] = 0
+	}
+}
+
+// Skip records an event that was not turned into a PIN.
+func (r *CalendarSyncResult) Skip(reason string) {
+	if r.SkippedByReason == nil {
+		r.SkippedByReason = make(map[string]int)
+	}
+	r.EventsSkipped++
+	r.SkippedByReason[reason]++
+}
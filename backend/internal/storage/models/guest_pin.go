@@ -17,16 +17,28 @@ type GuestPIN struct {
 	ValidUntil           time.Time  `json:"valid_until"`
 	Status               string     `json:"status"`
 	RegenerationEligible bool       `json:"regeneration_eligible"`
+	ReservationCode      *string    `json:"reservation_code,omitempty"`
+	GuestName            *string    `json:"guest_name,omitempty"`
+	GuestPhoneLast4      *string    `json:"guest_phone_last4,omitempty"`
+	ReservationURL       *string    `json:"reservation_url,omitempty"`
+	MissingSince         *time.Time `json:"missing_since,omitempty"`       // First sync the event was absent from the feed
+	MissingCount         int        `json:"missing_count,omitempty"`       // Consecutive syncs the event has been absent
+	LinkedPINID          *string    `json:"linked_pin_id,omitempty"`       // PIN of the same stay on another calendar; set PINs are not programmed
+	EventSequence        int        `json:"event_sequence,omitempty"`      // SEQUENCE of the event revision last applied
+	EventLastModified    *time.Time `json:"event_last_modified,omitempty"` // LAST-MODIFIED of the event revision last applied
 	CreatedAt            time.Time  `json:"created_at"`
 	UpdatedAt            time.Time  `json:"updated_at"`
 }
 
-// PIN generation method constants - priority order (highest first)
+// PIN generation method constants - default priority order (highest first).
+// Methods other than custom name the pin package strategy that produced the
+// code; calendars may configure other registered strategies.
 const (
 	GenerationMethodCustom           = "custom"            // Owner-specified custom PIN
 	GenerationMethodPhoneLast4       = "phone_last4"       // Last 4 digits from phone pattern
 	GenerationMethodDescriptionRandom = "description_random" // Deterministic from description
 	GenerationMethodDateBased        = "date_based"        // Check-in + check-out days
+	GenerationMethodRandom           = "random"            // Drawn from a CSPRNG; not in the default order
 )
 
 // PIN status constants
@@ -67,6 +79,26 @@ func (p *GuestPIN) IsActive(now time.Time) bool {
 	return now.After(p.ValidFrom) && now.Before(p.ValidUntil)
 }
 
+// IsStale reports whether event is an older revision than the one the PIN
+// was last updated from.
+func (p *GuestPIN) IsStale(event CalendarEvent) bool {
+	if event.Sequence != p.EventSequence {
+		return event.Sequence < p.EventSequence
+	}
+	return event.LastModified != nil && p.EventLastModified != nil && event.LastModified.Before(*p.EventLastModified)
+}
+
+// SetEventRevision records event's revision as the one last applied.
+func (p *GuestPIN) SetEventRevision(event CalendarEvent) {
+	p.EventSequence = event.Sequence
+	p.EventLastModified = event.LastModified
+}
+
+// IsLinked reports whether the PIN mirrors a stay programmed by another PIN.
+func (p *GuestPIN) IsLinked() bool {
+	return p.LinkedPINID != nil
+}
+
 // CanRegenerate returns true if the PIN can be regenerated.
 // PINs can only be regenerated if the start date is at least 1 day in the future.
 func (p *GuestPIN) CanRegenerate(now time.Time) bool {
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Reservation is a booking read from a channel manager's reservation API.
+type Reservation struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status,omitempty"`
+	GuestName string    `json:"guest_name,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	CheckIn   time.Time `json:"check_in"`
+	CheckOut  time.Time `json:"check_out"`
+	// AllDay is set when the API gives dates only; the calendar's check-in and
+	// check-out times are applied to them like iCalendar all-day events.
+	AllDay    bool `json:"all_day,omitempty"`
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// ReservationMapping locates reservation fields in a JSON API response.
+// Paths are dot separated object keys, e.g. "guest.phone".
+type ReservationMapping struct {
+	ItemsPath string `json:"items_path,omitempty"` // Path to the reservation array; empty if the response is the array
+	ID        string `json:"id"`
+	Status    string `json:"status,omitempty"`
+	GuestName string `json:"guest_name,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+	CheckIn   string `json:"check_in"`  // RFC 3339 date-time or YYYY-MM-DD date
+	CheckOut  string `json:"check_out"` // RFC 3339 date-time or YYYY-MM-DD date
+
+	// UnitID keeps only reservations whose unit equals it; empty keeps all.
+	UnitID string `json:"unit_id,omitempty"`
+	// CancelledStatuses are status values (case-insensitive) of cancelled
+	// reservations; empty uses DefaultCancelledStatuses.
+	CancelledStatuses []string `json:"cancelled_statuses,omitempty"`
+	// StartParam and EndParam, if set, are query parameters given the sync
+	// window as YYYY-MM-DD dates.
+	StartParam string `json:"start_param,omitempty"`
+	EndParam   string `json:"end_param,omitempty"`
+}
+
+// DefaultCancelledStatuses are the reservation statuses treated as cancelled
+// when a mapping does not list its own.
+var DefaultCancelledStatuses = []string{"cancelled", "canceled"}
+
+// ParseReservationMapping decodes the JSON stored in calendar_subscriptions.reservation_mapping.
+func ParseReservationMapping(raw *string) (*ReservationMapping, error) {
+	if raw == nil || *raw == "" {
+		return nil, nil
+	}
+	var mapping ReservationMapping
+	if err := json.Unmarshal([]byte(*raw), &mapping); err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+// EncodeReservationMapping encodes a mapping for storage; nil is stored as NULL.
+func EncodeReservationMapping(mapping *ReservationMapping) (*string, error) {
+	if mapping == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(mapping)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
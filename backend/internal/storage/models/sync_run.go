@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// CalendarSyncRun is the record of one calendar sync.
+type CalendarSyncRun struct {
+	ID             string    `json:"id"`
+	CalendarID     string    `json:"calendar_id"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	DurationMS     int64     `json:"duration_ms"`
+	Status         string    `json:"status"`
+	HTTPStatus     *int      `json:"http_status,omitempty"`
+	EventsFound    int       `json:"events_found"`
+	EventsSkipped  int       `json:"events_skipped"`
+	PINsCreated    int       `json:"pins_created"`
+	PINsUpdated    int       `json:"pins_updated"`
+	PINsRemoved    int       `json:"pins_removed"`
+	PendingRemoval int       `json:"pending_removal"`
+	Cancellations  int       `json:"cancellations"`
+	Error          *string   `json:"error,omitempty"`
+	SnapshotSize   *int      `json:"snapshot_size,omitempty"` // Uncompressed feed size; nil once pruned
+	Snapshot       []byte    `json:"-"`                       // Raw feed body, compressed when stored
+	SnapshotFormat string    `json:"snapshot_format"`         // Feed format of Snapshot
+}
+
+// Feed format constants name how a raw feed body is decoded into events.
+const (
+	FeedFormatICal         = "ical"         // iCalendar data
+	FeedFormatHACalendar   = "ha_calendar"  // JSON events of a Home Assistant calendar entity
+	FeedFormatReservations = "reservations" // JSON Reservations read from a channel manager API
+)
+
+// Sync run status constants
+const (
+	SyncRunSuccess   = "success"
+	SyncRunError     = "error"
+	SyncRunAnomaly   = "anomaly"
+	SyncRunUnchanged = "unchanged" // Feed not modified since it was last processed
+)
+
+// HasSnapshot reports whether the run's raw feed is still stored.
+func (r *CalendarSyncRun) HasSnapshot() bool {
+	return r.SnapshotSize != nil
+}
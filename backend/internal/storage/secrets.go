@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// secretKeyFile is the name of the key file kept next to the database.
+const secretKeyFile = "secret.key"
+
+// SecretBox encrypts values such as feed credentials before they are stored.
+// It uses AES-256-GCM with a key kept in a file beside the database, so a
+// copied database alone does not reveal them.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// LoadSecretBox reads the key at path, creating a new random key if the file
+// does not exist.
+func LoadSecretBox(path string) (*SecretBox, error) {
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generating secret key: %w", err)
+		}
+		if err := os.WriteFile(path, key, 0o600); err != nil {
+			return nil, fmt.Errorf("writing secret key: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("reading secret key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key %s must be 32 bytes", path)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Encrypt returns plaintext sealed and base64 encoded for storage.
+func (b *SecretBox) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt.
+func (b *SecretBox) Decrypt(encoded string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding secret: %w", err)
+	}
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("decrypting secret: value too short")
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secret: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Secrets returns the SecretBox for this database, loading or creating its
+// key file on first use.
+func (db *DB) Secrets() (*SecretBox, error) {
+	db.secretsOnce.Do(func() {
+		db.secrets, db.secretsErr = LoadSecretBox(filepath.Join(filepath.Dir(db.path), secretKeyFile))
+	})
+	return db.secrets, db.secretsErr
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -14,6 +15,10 @@ import (
 type DB struct {
 	*sql.DB
 	path string
+
+	secretsOnce sync.Once
+	secrets     *SecretBox
+	secretsErr  error
 }
 
 // NewDB creates a new database connection to the SQLite file at the given path.
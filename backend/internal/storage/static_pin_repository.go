@@ -266,3 +266,30 @@ func (r *StaticPINRepository) ListPendingSync(ctx context.Context) ([]models.Sta
 }
 
 
+
+// ListEnabledOnLock retrieves the enabled static PINs on a lock. Static PINs
+// recur, so any window overlaps them.
+func (r *StaticPINRepository) ListEnabledOnLock(ctx context.Context, lockID string) ([]models.StaticPIN, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT sp.id, sp.name, sp.pin_code, sp.enabled, sp.always_active, spl.slot_number, sp.created_at, sp.updated_at
+		FROM static_pins sp
+		JOIN static_pin_locks spl ON spl.static_pin_id = sp.id
+		WHERE spl.lock_id = ? AND sp.enabled = 1
+	`, lockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pins []models.StaticPIN
+	for rows.Next() {
+		var pin models.StaticPIN
+		if err := rows.Scan(&pin.ID, &pin.Name, &pin.PINCode, &pin.Enabled, &pin.AlwaysActive, &pin.SlotNumber,
+			&pin.CreatedAt, &pin.UpdatedAt); err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+
+	return pins, rows.Err()
+}
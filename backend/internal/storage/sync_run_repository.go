@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/guest-lock-manager/backend/internal/storage/models"
+)
+
+// syncRunColumns is the column list read by scanSyncRun. The snapshot itself
+// is only read by GetSnapshot.
+const syncRunColumns = `id, calendar_id, started_at, finished_at, duration_ms, status, http_status,
+		       events_found, events_skipped, pins_created, pins_updated, pins_removed, pending_removal,
+		       cancellations, error, snapshot_size, snapshot_format`
+
+// scanSyncRun scans a row selected with syncRunColumns.
+func scanSyncRun(row rowScanner, run *models.CalendarSyncRun) error {
+	return row.Scan(
+		&run.ID, &run.CalendarID, &run.StartedAt, &run.FinishedAt, &run.DurationMS, &run.Status, &run.HTTPStatus,
+		&run.EventsFound, &run.EventsSkipped, &run.PINsCreated, &run.PINsUpdated, &run.PINsRemoved, &run.PendingRemoval,
+		&run.Cancellations, &run.Error, &run.SnapshotSize, &run.SnapshotFormat,
+	)
+}
+
+// SyncRunRepository provides data access for calendar sync history.
+type SyncRunRepository struct {
+	BaseRepository
+}
+
+// NewSyncRunRepository creates a new sync run repository.
+func NewSyncRunRepository(db *DB) *SyncRunRepository {
+	return &SyncRunRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// Create inserts a sync run, gzip-compressing its snapshot if it has one.
+func (r *SyncRunRepository) Create(ctx context.Context, run *models.CalendarSyncRun) error {
+	run.ID = GenerateID()
+
+	var snapshot []byte
+	run.SnapshotSize = nil
+	if run.Snapshot != nil {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(run.Snapshot); err != nil {
+			return fmt.Errorf("compressing snapshot: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("compressing snapshot: %w", err)
+		}
+		snapshot = buf.Bytes()
+		size := len(run.Snapshot)
+		run.SnapshotSize = &size
+	}
+
+	_, err := r.DB().ExecContext(ctx, `
+		INSERT INTO calendar_sync_runs (
+			id, calendar_id, started_at, finished_at, duration_ms, status, http_status,
+			events_found, events_skipped, pins_created, pins_updated, pins_removed, pending_removal,
+			cancellations, error, snapshot, snapshot_size, snapshot_format
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		run.ID, run.CalendarID, run.StartedAt, run.FinishedAt, run.DurationMS, run.Status, run.HTTPStatus,
+		run.EventsFound, run.EventsSkipped, run.PINsCreated, run.PINsUpdated, run.PINsRemoved, run.PendingRemoval,
+		run.Cancellations, run.Error, snapshot, run.SnapshotSize, run.SnapshotFormat,
+	)
+
+	if err != nil {
+		return fmt.Errorf("inserting sync run: %w", err)
+	}
+
+	return nil
+}
+
+// ListByCalendar returns a calendar's most recent sync runs, newest first.
+func (r *SyncRunRepository) ListByCalendar(ctx context.Context, calendarID string, limit int) ([]models.CalendarSyncRun, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT `+syncRunColumns+`
+		FROM calendar_sync_runs
+		WHERE calendar_id = ?
+		ORDER BY started_at DESC, id DESC
+		LIMIT ?
+	`, calendarID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying sync runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.CalendarSyncRun
+	for rows.Next() {
+		var run models.CalendarSyncRun
+		if err := scanSyncRun(rows, &run); err != nil {
+			return nil, fmt.Errorf("scanning sync run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// ListSnapshotRunIDs returns the IDs of a calendar's runs that still have a
+// snapshot, newest first.
+func (r *SyncRunRepository) ListSnapshotRunIDs(ctx context.Context, calendarID string) ([]string, error) {
+	rows, err := r.DB().QueryContext(ctx, `
+		SELECT id FROM calendar_sync_runs
+		WHERE calendar_id = ? AND snapshot IS NOT NULL
+		ORDER BY started_at DESC, id DESC
+	`, calendarID)
+	if err != nil {
+		return nil, fmt.Errorf("querying snapshot runs: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning snapshot run: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// GetByID retrieves a calendar's sync run, including its decompressed snapshot.
+// Returns nil if the run does not exist.
+func (r *SyncRunRepository) GetByID(ctx context.Context, calendarID, id string) (*models.CalendarSyncRun, error) {
+	var run models.CalendarSyncRun
+	var snapshot []byte
+	err := r.DB().QueryRowContext(ctx, `
+		SELECT `+syncRunColumns+`, snapshot
+		FROM calendar_sync_runs WHERE calendar_id = ? AND id = ?
+	`, calendarID, id).Scan(
+		&run.ID, &run.CalendarID, &run.StartedAt, &run.FinishedAt, &run.DurationMS, &run.Status, &run.HTTPStatus,
+		&run.EventsFound, &run.EventsSkipped, &run.PINsCreated, &run.PINsUpdated, &run.PINsRemoved, &run.PendingRemoval,
+		&run.Cancellations, &run.Error, &run.SnapshotSize, &run.SnapshotFormat, &snapshot,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying sync run: %w", err)
+	}
+
+	if snapshot != nil {
+		zr, err := gzip.NewReader(bytes.NewReader(snapshot))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing snapshot: %w", err)
+		}
+		defer zr.Close()
+		if run.Snapshot, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("decompressing snapshot: %w", err)
+		}
+	}
+
+	return &run, nil
+}
+
+// PruneSnapshots clears the snapshots of all but a calendar's keep most recent
+// runs that have one. The run records themselves are kept.
+func (r *SyncRunRepository) PruneSnapshots(ctx context.Context, calendarID string, keep int) error {
+	_, err := r.DB().ExecContext(ctx, `
+		UPDATE calendar_sync_runs SET snapshot = NULL, snapshot_size = NULL
+		WHERE calendar_id = ? AND snapshot IS NOT NULL AND id NOT IN (
+			SELECT id FROM calendar_sync_runs
+			WHERE calendar_id = ? AND snapshot IS NOT NULL
+			ORDER BY started_at DESC, id DESC
+			LIMIT ?
+		)
+	`, calendarID, calendarID, keep)
+
+	if err != nil {
+		return fmt.Errorf("pruning snapshots: %w", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"errors"
 	"log"
 	"time"
 
@@ -20,36 +21,102 @@ func NewEventBroadcaster(hub *Hub) *EventBroadcaster {
 // BroadcastCalendarSyncCompleted sends a calendar sync completed event.
 func (b *EventBroadcaster) BroadcastCalendarSyncCompleted(result models.CalendarSyncResult) {
 	payload := CalendarSyncPayload{
-		CalendarID:   result.CalendarID,
-		CalendarName: result.CalendarName,
-		Status:       "success",
-		EventsFound:  result.EventsFound,
-		PinsCreated:  result.PINsCreated,
-		PinsUpdated:  result.PINsUpdated,
-		PinsRemoved:  result.PINsRemoved,
+		CalendarID:        result.CalendarID,
+		CalendarName:      result.CalendarName,
+		Status:            "success",
+		EventsFound:       result.EventsFound,
+		PinsCreated:       result.PINsCreated,
+		PinsUpdated:       result.PINsUpdated,
+		PinsRemoved:       result.PINsRemoved,
+		PinsLinked:        result.PINsLinked,
+		Cancellations:     result.Cancellations,
+		ConflictsResolved: result.ConflictsResolved,
+		Conflicts:         len(result.Conflicts),
+		EventsSkipped:     result.EventsSkipped,
+		SkippedByReason:   result.SkippedByReason,
+		Unchanged:         result.Unchanged,
+	}
+
+	for _, p := range result.PendingRemoval {
+		payload.PendingRemoval = append(payload.PendingRemoval, PendingRemovalPayload{
+			GuestPinID:   p.GuestPINID,
+			EventUID:     p.EventUID,
+			EventSummary: p.EventSummary,
+			Status:       p.Status,
+			MissingSince: p.MissingSince,
+			MissingCount: p.MissingCount,
+		})
 	}
 
 	if result.Error != nil {
 		payload.Status = "error"
 	}
+	if result.NextSyncAt != nil {
+		payload.NextSyncAt = *result.NextSyncAt
+	}
 
 	msg := NewMessage(TypeCalendarSyncCompleted, payload)
 	b.broadcast(msg)
+
+	for _, c := range result.Conflicts {
+		b.BroadcastPINConflictDetected(c)
+	}
 }
 
-// BroadcastCalendarSyncError sends a calendar sync error event.
+// BroadcastCalendarSyncError sends a calendar sync error event. Errors that
+// implement SyncErrorCode() (such as feed anomalies) report that code instead
+// of "sync_error".
 func (b *EventBroadcaster) BroadcastCalendarSyncError(calendarID, calendarName string, err error) {
+	b.BroadcastCalendarSyncRetrying(calendarID, calendarName, err, time.Time{})
+}
+
+// BroadcastCalendarSyncRetrying sends a calendar sync error event for a sync
+// that will be retried at retryAt.
+func (b *EventBroadcaster) BroadcastCalendarSyncRetrying(calendarID, calendarName string, err error, retryAt time.Time) {
+	code := "sync_error"
+	var coded interface{ SyncErrorCode() string }
+	if errors.As(err, &coded) {
+		code = coded.SyncErrorCode()
+	}
+
 	payload := CalendarSyncErrorPayload{
 		CalendarID:   calendarID,
 		CalendarName: calendarName,
-		Error:        "sync_error",
+		Error:        code,
 		Message:      err.Error(),
+		RetryAt:      retryAt,
 	}
 
 	msg := NewMessage(TypeCalendarSyncError, payload)
 	b.broadcast(msg)
 }
 
+// BroadcastCalendarSyncProgress sends a calendar sync progress event. result
+// is the calendar that just finished, or nil when the sync starts.
+func (b *EventBroadcaster) BroadcastCalendarSyncProgress(completed, total int, result *models.CalendarSyncResult) {
+	payload := CalendarSyncProgressPayload{
+		Completed: completed,
+		Total:     total,
+	}
+
+	if result != nil {
+		payload.CalendarID = result.CalendarID
+		payload.CalendarName = result.CalendarName
+		switch {
+		case result.Error != nil:
+			payload.Status = "error"
+			payload.Error = result.Error.Error()
+		case result.Unchanged:
+			payload.Status = "unchanged"
+		default:
+			payload.Status = "success"
+		}
+	}
+
+	msg := NewMessage(TypeCalendarSyncProgress, payload)
+	b.broadcast(msg)
+}
+
 // BroadcastPINStatusChanged sends a PIN status changed event.
 func (b *EventBroadcaster) BroadcastPINStatusChanged(pinID, pinType, previousStatus, newStatus string, eventSummary string) {
 	payload := PinStatusPayload{
@@ -80,6 +147,31 @@ func (b *EventBroadcaster) BroadcastPINSyncStatusChanged(pinID, pinType, lockID,
 	b.broadcast(msg)
 }
 
+// BroadcastPINConflictDetected sends a PIN conflict detected event naming
+// both PINs of the conflict.
+func (b *EventBroadcaster) BroadcastPINConflictDetected(conflict models.PINConflict) {
+	payload := PinConflictPayload{
+		LockID:  conflict.LockID,
+		PinCode: conflict.PINCode,
+		Reason:  conflict.Reason,
+		Pin: ConflictPartyPayload{
+			PinID:   conflict.GuestPINID,
+			PinType: "guest",
+			Name:    conflict.EventSummary,
+		},
+		ConflictingPin: ConflictPartyPayload{
+			PinID:   conflict.ConflictingPINID,
+			PinType: conflict.ConflictingType,
+			Name:    conflict.ConflictingSummary,
+		},
+		OverlapStart: conflict.OverlapStart,
+		OverlapEnd:   conflict.OverlapEnd,
+	}
+
+	msg := NewMessage(TypePinConflictDetected, payload)
+	b.broadcast(msg)
+}
+
 // BroadcastLockStatusChanged sends a lock status changed event.
 func (b *EventBroadcaster) BroadcastLockStatusChanged(lockID, entityID string, online bool, batteryLevel *int) {
 	payload := LockStatusPayload{
@@ -123,6 +215,3 @@ func (b *EventBroadcaster) broadcast(msg Message) {
 
 	b.hub.Broadcast(data)
 }
-
-
-
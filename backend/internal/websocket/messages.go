@@ -16,6 +16,7 @@ const (
 	TypePinConflictDetected   MessageType = "pin.conflict_detected"
 	TypeCalendarSyncCompleted MessageType = "calendar.sync_completed"
 	TypeCalendarSyncError     MessageType = "calendar.sync_error"
+	TypeCalendarSyncProgress  MessageType = "calendar.sync_progress"
 	TypeSystemStatusChanged   MessageType = "system.status_changed"
 	TypeNotification          MessageType = "notification"
 
@@ -80,6 +81,26 @@ type PinSyncStatusPayload struct {
 	SlotNumber     int    `json:"slot_number"`
 }
 
+// PinConflictPayload is the payload for pin.conflict_detected events. Pin is
+// the PIN set to conflict status; ConflictingPin already uses the code, or a
+// clashing one, on the lock.
+type PinConflictPayload struct {
+	LockID         string               `json:"lock_id"`
+	PinCode        string               `json:"pin_code"`
+	Reason         string               `json:"reason"` // "same_code", "prefix" or "similar"
+	Pin            ConflictPartyPayload `json:"pin"`
+	ConflictingPin ConflictPartyPayload `json:"conflicting_pin"`
+	OverlapStart   time.Time            `json:"overlap_start"`
+	OverlapEnd     time.Time            `json:"overlap_end"`
+}
+
+// ConflictPartyPayload identifies one of the two PINs of a conflict.
+type ConflictPartyPayload struct {
+	PinID   string `json:"pin_id,omitempty"`
+	PinType string `json:"pin_type"`       // "guest" or "static"
+	Name    string `json:"name,omitempty"` // Event summary, or name of a static PIN
+}
+
 // CalendarSyncPayload is the payload for calendar.sync_completed events.
 type CalendarSyncPayload struct {
 	CalendarID   string    `json:"calendar_id"`
@@ -90,6 +111,32 @@ type CalendarSyncPayload struct {
 	PinsUpdated  int       `json:"pins_updated"`
 	PinsRemoved  int       `json:"pins_removed"`
 	NextSyncAt   time.Time `json:"next_sync_at,omitempty"`
+	// PinsLinked counts PINs linked to the same stay on another calendar instead of being programmed.
+	PinsLinked int `json:"pins_linked,omitempty"`
+	// Cancellations counts PINs expired because their event was cancelled.
+	Cancellations int `json:"cancellations"`
+	// ConflictsResolved counts PINs given another code because theirs was in use on a lock;
+	// Conflicts counts the conflicts of PINs set to conflict status, each also sent as pin.conflict_detected.
+	ConflictsResolved int `json:"conflicts_resolved,omitempty"`
+	Conflicts         int `json:"conflicts,omitempty"`
+	// EventsSkipped counts events that did not become PINs, broken down in SkippedByReason.
+	EventsSkipped   int            `json:"events_skipped"`
+	SkippedByReason map[string]int `json:"skipped_by_reason,omitempty"`
+	// PendingRemoval lists PINs kept alive while their events are missing from the feed.
+	PendingRemoval []PendingRemovalPayload `json:"pending_removal,omitempty"`
+	// Unchanged is set when the feed had not changed and was not reprocessed.
+	Unchanged bool `json:"unchanged,omitempty"`
+}
+
+// PendingRemovalPayload describes a guest PIN whose event is missing from the
+// feed but which is kept until the calendar's removal grace runs out.
+type PendingRemovalPayload struct {
+	GuestPinID   string    `json:"guest_pin_id"`
+	EventUID     string    `json:"event_uid"`
+	EventSummary string    `json:"event_summary,omitempty"`
+	Status       string    `json:"status"`
+	MissingSince time.Time `json:"missing_since"`
+	MissingCount int       `json:"missing_count"`
 }
 
 // CalendarSyncErrorPayload is the payload for calendar.sync_error events.
@@ -101,6 +148,18 @@ type CalendarSyncErrorPayload struct {
 	RetryAt      time.Time `json:"retry_at,omitempty"`
 }
 
+// CalendarSyncProgressPayload is the payload for calendar.sync_progress
+// events, sent while all calendars are synced. The first event has Completed
+// 0 and no calendar; the last has Completed equal to Total.
+type CalendarSyncProgressPayload struct {
+	Completed    int    `json:"completed"`
+	Total        int    `json:"total"`
+	CalendarID   string `json:"calendar_id,omitempty"`
+	CalendarName string `json:"calendar_name,omitempty"`
+	Status       string `json:"status,omitempty"` // success, unchanged or error
+	Error        string `json:"error,omitempty"`
+}
+
 // NotificationPayload is the payload for notification events.
 type NotificationPayload struct {
 	Level       string             `json:"level"` // info, warning, error, success